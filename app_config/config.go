@@ -24,6 +24,7 @@ import (
 	"github.com/UnownHash/Fletchling/processor"
 	"github.com/UnownHash/Fletchling/pyroscope"
 	"github.com/UnownHash/Fletchling/stats_collector"
+	"github.com/UnownHash/Fletchling/webhook_recorder"
 	"github.com/UnownHash/Fletchling/webhook_sender"
 )
 
@@ -73,10 +74,12 @@ type Config struct {
 	WebhookSettings webhook_sender.SettingsConfig    `koanf:"webhook_settings"`
 	Webhooks        webhook_sender.WebhooksConfig    `koanf:"webhooks"`
 	HTTP            httpserver.Config                `koanf:"http"`
+	Ingest          httpserver.IngestConfig          `koanf:"ingest"`
 	Logging         logging.Config                   `koanf:"logging"`
 	Processor       processor.Config                 `koanf:"processor"`
 	Pyroscope       pyroscope.Config                 `koanf:"pyroscope"`
 	Prometheus      stats_collector.PrometheusConfig `koanf:"prometheus"`
+	WebhookRecorder webhook_recorder.Config          `koanf:"webhook_recorder"`
 }
 
 func (cfg *Config) GetPrometheusConfig() stats_collector.PrometheusConfig {
@@ -121,6 +124,10 @@ func (cfg *Config) Validate() error {
 		return err
 	}
 
+	if err := cfg.Ingest.Validate(); err != nil {
+		return err
+	}
+
 	if err := cfg.NestsDb.Validate(); err != nil {
 		return err
 	}
@@ -139,6 +146,10 @@ func (cfg *Config) Validate() error {
 		return err
 	}
 
+	if err := cfg.WebhookRecorder.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -175,9 +186,14 @@ func GetDefaultConfig() Config {
 		},
 
 		HTTP: httpserver.Config{
-			Addr: "127.0.0.1:9042",
+			Addr:                   "127.0.0.1:9042",
+			WebhookWorkers:         httpserver.DEFAULT_WEBHOOK_WORKERS,
+			WebhookQueueSize:       httpserver.DEFAULT_WEBHOOK_QUEUE_SIZE,
+			WebhookQueueFullPolicy: httpserver.DEFAULT_WEBHOOK_QUEUE_FULL_POLICY,
 		},
 
+		Ingest: httpserver.GetDefaultIngestConfig(),
+
 		NestsDb: db_store.DBConfig{
 			Addr: "127.0.0.1:3306",
 			Db:   "fletchling",
@@ -190,6 +206,8 @@ func GetDefaultConfig() Config {
 		},
 
 		Prometheus: stats_collector.GetDefaultPrometheusConfig(),
+
+		WebhookRecorder: webhook_recorder.GetDefaultConfig(),
 	}
 }
 
@@ -225,3 +243,43 @@ func LoadConfig(filename string, defaultConfig Config) (*Config, error) {
 
 	return &cfg, nil
 }
+
+// LoadProcessorConfig loads only the [processor] section from 'filename'
+// over 'defaultConfig'.
+func LoadProcessorConfig(filename string, defaultConfig processor.Config) (*processor.Config, error) {
+	k := koanf.New(".")
+	err := k.Load(structs.Provider(defaultConfig, "koanf"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load default processor config: %w", err)
+	}
+
+	fileK := koanf.New(".")
+	err = fileK.Load(file.Provider(filename), toml.Parser())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load processor config file: %w", err)
+	}
+
+	if fileK.Exists("processor") {
+		err = k.Merge(fileK.Cut("processor"))
+	} else {
+		// allow a file with just the processor settings at the top level.
+		err = k.Merge(fileK)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge processor config: %w", err)
+	}
+
+	var cfg processor.Config
+
+	err = k.Unmarshal("", &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal processor config: %w", err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/app_config"
+	"github.com/UnownHash/Fletchling/areas"
+	"github.com/UnownHash/Fletchling/db_store"
+	"github.com/UnownHash/Fletchling/httpserver"
+	"github.com/UnownHash/Fletchling/processor"
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+	"github.com/UnownHash/Fletchling/processor/nest_loader"
+	"github.com/UnownHash/Fletchling/stats_collector"
+	"github.com/UnownHash/Fletchling/version"
+	"github.com/UnownHash/Fletchling/webhook_recorder"
+	"github.com/UnownHash/Fletchling/webhook_sender"
+)
+
+const (
+	DEFAULT_CONFIG_FILENAME = "./configs/fletchling.toml"
+)
+
+func usage(flagSet *flag.FlagSet, output io.Writer) {
+	fmt.Fprintf(output, `** A wild Fletchling has appeared. Version %s **
+Usage: %s [-help] [-debug] [-verbose] [-f configfile] [-processor processor-configfile] <recording> [<recording> ...]
+
+%s replays webhooks recorded by fletchling's webhook_recorder
+through the nest processor and prints the nesting decisions it makes.
+
+Recordings should be given oldest first. Stats rotate following the
+recorded timestamps. Nests are loaded from the nests DB, but nothing is
+written to it. Migrations are not simulated.
+
+Decisions go to stdout. Logging goes to stderr.
+`,
+		version.APP_VERSION, os.Args[0], os.Args[0])
+
+	fmt.Fprint(output, "Options:\n")
+	flagSet.SetOutput(output)
+	flagSet.PrintDefaults()
+
+	fmt.Fprintf(output, `
+Examples:
+%s logs/webhooks-*.ndjson.gz logs/webhooks.ndjson
+%s -processor try-this.toml logs/webhooks.ndjson
+`,
+		os.Args[0], os.Args[0])
+}
+
+type replayer struct {
+	logger           *logrus.Logger
+	clock            *clock.Simulated
+	processorManager *processor.NestProcessorManager
+	processorConfig  processor.Config
+	ingestConfig     httpserver.IngestConfig
+
+	started      bool
+	nextRotation time.Time
+	nesting      map[int64]models.PokemonKey
+
+	numRecords   uint64
+	numPokemon   uint64
+	numRotations uint64
+	numDecisions uint64
+}
+
+func (r *replayer) start(ctx context.Context, t time.Time) error {
+	r.clock.Set(t)
+	if err := r.processorManager.LoadConfig(ctx, r.processorConfig); err != nil {
+		return fmt.Errorf("failed to load config into NestProcessorManager: %w", err)
+	}
+
+	// start from nothing nesting rather than what is in the DB now, which
+	// likely came from the future relative to the recording.
+	for _, nest := range r.processorManager.GetNests() {
+		nest.SetNestingPokemon(nil, time.Time{})
+	}
+
+	r.started = true
+	r.nextRotation = r.processorConfig.NextRotationAfter(t)
+
+	return nil
+}
+
+// rotate rotates at the simulated time 't' and prints what changed.
+func (r *replayer) rotate(t time.Time) {
+	r.clock.Set(t)
+	r.processorManager.RotateAndProcessStats()
+	r.numRotations++
+
+	nests := r.processorManager.GetNests()
+	sort.Slice(nests, func(i, j int) bool {
+		return nests[i].Id < nests[j].Id
+	})
+
+	timeStr := t.Format(time.RFC3339)
+
+	for _, nest := range nests {
+		var cur *models.PokemonKey
+		if ni, _ := nest.GetNestingPokemon(); ni != nil && !ni.Stale {
+			cur = &ni.PokemonKey
+		}
+
+		old, hadOld := r.nesting[nest.Id]
+
+		switch {
+		case cur == nil && hadOld:
+			fmt.Printf("%s NEST-END    %s: was %s\n", timeStr, nest, old)
+			delete(r.nesting, nest.Id)
+		case cur == nil:
+			continue
+		case !hadOld:
+			fmt.Printf("%s NEST-START  %s: %s\n", timeStr, nest, *cur)
+			r.nesting[nest.Id] = *cur
+		case old != *cur:
+			fmt.Printf("%s NEST-CHANGE %s: %s -> %s\n", timeStr, nest, old, *cur)
+			r.nesting[nest.Id] = *cur
+		default:
+			continue
+		}
+
+		r.numDecisions++
+	}
+}
+
+func (r *replayer) processRecord(ctx context.Context, record webhook_recorder.Record) error {
+	r.numRecords++
+
+	receivedAt := record.ReceivedAt
+
+	if !r.started {
+		if err := r.start(ctx, receivedAt); err != nil {
+			return err
+		}
+	}
+
+	for !receivedAt.Before(r.nextRotation) {
+		r.rotate(r.nextRotation)
+		r.nextRotation = r.processorConfig.NextRotationAfter(r.nextRotation)
+	}
+
+	r.clock.Set(receivedAt)
+
+	var msgs []httpserver.WebhookMessage
+
+	if err := json.Unmarshal(record.Payload, &msgs); err != nil {
+		r.logger.Warnf("REPLAY: skipping record received at %s: %v", receivedAt.Format(time.RFC3339), err)
+		return nil
+	}
+
+	for _, msg := range msgs {
+		pokemon, _, err := msg.ToPokemon(&r.ingestConfig)
+		if err != nil {
+			r.logger.Debug(err)
+			continue
+		}
+		if pokemon == nil {
+			continue
+		}
+		r.processorManager.ProcessPokemon(pokemon)
+		r.numPokemon++
+	}
+
+	return ctx.Err()
+}
+
+func (r *replayer) finish() {
+	if !r.started {
+		return
+	}
+
+	// count what is left as a final, partial period.
+	r.rotate(r.clock.Now())
+
+	nests := r.processorManager.GetNests()
+	sort.Slice(nests, func(i, j int) bool {
+		return nests[i].Id < nests[j].Id
+	})
+
+	fmt.Printf("\nReplayed %d record(s), %d pokemon, %d rotation(s), %d decision(s).\n",
+		r.numRecords,
+		r.numPokemon,
+		r.numRotations,
+		r.numDecisions,
+	)
+	fmt.Printf("Nesting at %s:\n", r.clock.Now().Format(time.RFC3339))
+
+	for _, nest := range nests {
+		if pokemonKey, ok := r.nesting[nest.Id]; ok {
+			fmt.Printf("  %s: %s\n", nest, pokemonKey)
+		}
+	}
+}
+
+func main() {
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	helpFlag := flagSet.Bool("help", false, "help!")
+	debugFlag := flagSet.Bool("debug", false, "override config and turn on debug logging")
+	verboseFlag := flagSet.Bool("verbose", false, "log the processor's output, also")
+	flagSet.BoolVar(helpFlag, "h", false, "help!")
+	configFileFlag := flagSet.String("f", DEFAULT_CONFIG_FILENAME, "config file to use")
+	processorConfigFileFlag := flagSet.String("processor", "", "use the [processor] section from this file instead")
+	versionFlag := flagSet.Bool("version", false, "print the version of this tool and exit")
+
+	err := flagSet.Parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s", err)
+		usage(flagSet, os.Stderr)
+		os.Exit(2)
+	}
+
+	if *helpFlag {
+		usage(flagSet, os.Stdout)
+		os.Exit(0)
+	}
+
+	if *versionFlag {
+		fmt.Fprintf(os.Stdout, "%s\n", version.APP_VERSION)
+		os.Exit(0)
+	}
+
+	recordingFilenames := flagSet.Args()
+	if len(recordingFilenames) == 0 {
+		usage(flagSet, os.Stderr)
+		os.Exit(1)
+	}
+
+	defaultConfig := app_config.GetDefaultConfig()
+
+	cfg, err := app_config.LoadConfig(*configFileFlag, defaultConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *processorConfigFileFlag != "" {
+		processorConfig, err := app_config.LoadProcessorConfig(*processorConfigFileFlag, defaultConfig.Processor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Processor = *processorConfig
+	}
+
+	// never touch the real saved stats.
+	cfg.Processor.StatsFilename = ""
+
+	cfg.Logging.Filename = ""
+	if *debugFlag {
+		cfg.Logging.Debug = true
+	}
+
+	logger, err := cfg.Logging.CreateLogger(false, false, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*verboseFlag && !cfg.Logging.Debug {
+		logger.SetLevel(logrus.WarnLevel)
+	}
+
+	nestsDBStore, err := db_store.NewNestsDBStore(cfg.NestsDb, logger)
+	if err != nil {
+		logger.Fatalf("failed to init nests db: %v", err)
+	}
+
+	areasLoader, err := areas.NewAreasLoader(logger, cfg.Areas)
+	if err != nil {
+		logger.Fatalf("failed to create areas loader: %v", err)
+	}
+
+	simClock := clock.NewSimulated(time.Time{})
+
+	processorManager, err := processor.NewNestProcessorManager(processor.NestProcessorManagerConfig{
+		Logger:         logger,
+		NestsDBStore:   nestsDBStore,
+		NestLoader:     nest_loader.NewDBNestLoader(logger, nestsDBStore, simClock),
+		StatsCollector: stats_collector.NewNoopStatsCollector(),
+		WebhookSender:  webhook_sender.NewNoopSender(),
+		AreasLoader:    areasLoader,
+		Clock:          simClock,
+		DryRun:         true,
+	})
+	if err != nil {
+		logger.Fatalf("failed to create processor manager: %v", err)
+	}
+
+	r := &replayer{
+		logger:           logger,
+		clock:            simClock,
+		processorManager: processorManager,
+		processorConfig:  cfg.Processor,
+		ingestConfig:     cfg.Ingest,
+		nesting:          make(map[int64]models.PokemonKey),
+	}
+
+	ctx := context.Background()
+
+	for _, filename := range recordingFilenames {
+		err := webhook_recorder.ReadFile(filename, func(record webhook_recorder.Record) error {
+			return r.processRecord(ctx, record)
+		})
+		if err != nil {
+			logger.Fatalf("failed to replay '%s': %v", filename, err)
+		}
+	}
+
+	r.finish()
+}
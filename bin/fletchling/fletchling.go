@@ -16,12 +16,15 @@ import (
 	"github.com/UnownHash/Fletchling/pyroscope"
 	"github.com/UnownHash/Fletchling/stats_collector"
 	"github.com/UnownHash/Fletchling/version"
+	"github.com/UnownHash/Fletchling/webhook_recorder"
 	"github.com/UnownHash/Fletchling/webhook_sender"
 
 	"github.com/UnownHash/Fletchling/app_config"
+	"github.com/UnownHash/Fletchling/areas"
 	"github.com/UnownHash/Fletchling/db_store"
 	"github.com/UnownHash/Fletchling/httpserver"
 	"github.com/UnownHash/Fletchling/processor"
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/nest_loader"
 )
 
@@ -145,7 +148,7 @@ func main() {
 
 	logger.Debugf("STARTUP: store inited.")
 
-	nestLoader := nest_loader.NewDBNestLoader(logger, nestsDBStore)
+	nestLoader := nest_loader.NewDBNestLoader(logger, nestsDBStore, clock.Real)
 	logger.Debugf("STARTUP: nest loader (db) inited.")
 
 	dbRefresher := filters.NewDBRefresher(
@@ -167,6 +170,11 @@ func main() {
 		webhookSender = webhook_sender.NewNoopSender()
 	}
 
+	areasLoader, err := areas.NewAreasLoader(logger, cfg.Areas)
+	if err != nil {
+		logger.Fatalf("failed to create areas loader: %v", err)
+	}
+
 	processorManagerConfig := processor.NestProcessorManagerConfig{
 		Logger:         logger,
 		NestsDBStore:   nestsDBStore,
@@ -174,6 +182,7 @@ func main() {
 		NestLoader:     nestLoader,
 		StatsCollector: statsCollector,
 		WebhookSender:  webhookSender,
+		AreasLoader:    areasLoader,
 	}
 
 	logger.Debugf("STARTUP: initializing processor.")
@@ -200,6 +209,15 @@ func main() {
 
 	cfg.Filters.Log(logger, "STARTUP: Filters config loaded: ")
 
+	var ingestConfigMutex sync.Mutex
+	ingestConfig := cfg.Ingest
+
+	getIngestConfigFn := func() httpserver.IngestConfig {
+		ingestConfigMutex.Lock()
+		defer ingestConfigMutex.Unlock()
+		return ingestConfig
+	}
+
 	reloadFn := func() error {
 		cfg, err := app_config.LoadConfig(configFilename, defaultConfig)
 		if err != nil {
@@ -209,6 +227,9 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("failed to reload processor manager: %w", err)
 		}
+		ingestConfigMutex.Lock()
+		ingestConfig = cfg.Ingest
+		ingestConfigMutex.Unlock()
 		cfg.Filters.Log(logger, "Filters config reloaded: ")
 		filtersConfigMutex.Lock()
 		defer filtersConfigMutex.Unlock()
@@ -241,13 +262,18 @@ func main() {
 	}()
 	logger.Debugf("STARTUP: installed reload (SIGHUP) handler")
 
+	// the processor is stopped after the http server, so that the
+	// webhooks left in its queue are counted before the stats are saved.
+	processorCtx, processorCancelFn := context.WithCancel(context.Background())
+	defer processorCancelFn()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		// shut down everything else if this bails early
 		defer cancelFn()
 
-		processorManager.Run(ctx)
+		processorManager.Run(processorCtx)
 	}()
 
 	logger.Debugf("STARTUP: processor started.")
@@ -265,14 +291,28 @@ func main() {
 		}()
 	}
 
-	httpServer, err := httpserver.NewHTTPServer(logger, processorManager, statsCollector, dbRefresher, reloadFn, getFiltersConfigFn)
+	var webhookRecorder *webhook_recorder.Recorder
+
+	if cfg.WebhookRecorder.Enabled() {
+		webhookRecorder = webhook_recorder.NewRecorder(logger, cfg.WebhookRecorder)
+		defer webhookRecorder.Close()
+		logger.Infof("STARTUP: recording webhooks to '%s'", cfg.WebhookRecorder.Filename)
+	}
+
+	httpServer, err := httpserver.NewHTTPServer(logger, cfg.HTTP, getIngestConfigFn, processorManager, statsCollector, dbRefresher, reloadFn, getFiltersConfigFn, webhookRecorder)
 	if err != nil {
 		logger.Fatalf("failed to create http server: %v", err)
 	}
 
 	logger.Infof("STARTUP: starting http server (final step)")
 	err = httpServer.Run(ctx, cfg.HTTP.Addr, time.Second*5)
+
+	// the webhook queue is drained, so the processor can save its stats.
+	processorCancelFn()
+
 	if err != nil {
+		cancelFn()
+		wg.Wait()
 		logger.Fatalf("failed to run http server: %v", err)
 	}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"github.com/sirupsen/logrus"
 )
@@ -41,6 +42,41 @@ SELECT COUNT(*) FROM spawnpoint
 	return numSpawnpoints, nil
 }
 
+type Spawnpoint struct {
+	Id  uint64  `db:"id"`
+	Lat float64 `db:"lat"`
+	Lon float64 `db:"lon"`
+}
+
+// IterateSpawnpoints calls fn with every spawnpoint inside 'bbox' that was
+// seen in the last 'maxDays' days.
+func (st *GolbatDBStore) IterateSpawnpoints(ctx context.Context, bbox orb.Bound, maxDays int, fn func(Spawnpoint) error) (err error) {
+	const getSpawnpointsQuery = `
+SELECT id, lat, lon FROM spawnpoint
+    WHERE lat > ? AND lon > ?
+		AND lat < ? AND lon < ?
+		AND last_seen > UNIX_TIMESTAMP(NOW() - INTERVAL ? DAY)`
+
+	rows, err := st.db.QueryxContext(ctx, getSpawnpointsQuery, bbox.Min.Lat(), bbox.Min.Lon(), bbox.Max.Lat(), bbox.Max.Lon(), maxDays)
+	if err != nil {
+		return err
+	}
+
+	defer func() { err = closeRows(rows, err) }()
+
+	for rows.Next() {
+		var spawnpoint Spawnpoint
+		if err := rows.StructScan(&spawnpoint); err != nil {
+			return err
+		}
+		if err := fn(spawnpoint); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func NewGolbatDBStore(config DBConfig, logger *logrus.Logger) (*GolbatDBStore, error) {
 	db, err := sqlx.Connect("mysql", config.AsDSN())
 	if err != nil {
@@ -0,0 +1,122 @@
+package db_store
+
+import (
+	"context"
+
+	"gopkg.in/guregu/null.v4"
+)
+
+type NestHistory struct {
+	Id           int64      `db:"id"`
+	NestId       int64      `db:"nest_id"`
+	PokemonId    int        `db:"pokemon_id"`
+	PokemonForm  int        `db:"pokemon_form"`
+	DetectedAt   int64      `db:"detected_at"`
+	EndedAt      null.Int   `db:"ended_at"`
+	PokemonAvg   null.Float `db:"pokemon_avg"`
+	PokemonRatio null.Float `db:"pokemon_ratio"`
+	PokemonCount null.Float `db:"pokemon_count"`
+
+	// from the nests table, if the nest still exists.
+	Name     null.String `db:"name"`
+	AreaName null.String `db:"area_name"`
+	Lat      null.Float  `db:"lat"`
+	Lon      null.Float  `db:"lon"`
+}
+
+type NestHistoryStats struct {
+	PokemonAvg   null.Float
+	PokemonRatio null.Float
+	PokemonCount null.Float
+}
+
+const nestHistorySelectColumns = "h.id,h.nest_id,h.pokemon_id,h.pokemon_form,h.detected_at,h.ended_at,h.pokemon_avg,h.pokemon_ratio,h.pokemon_count,n.name,n.area_name,n.lat,n.lon"
+
+// StartNestHistory closes any open history for the nest and opens a
+// new one. 'stats' are the final stats for the history being closed.
+func (st *NestsDBStore) StartNestHistory(ctx context.Context, history *NestHistory, stats *NestHistoryStats) error {
+	tx, err := st.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := st.endNestHistory(ctx, tx, history.NestId, history.DetectedAt, stats); err != nil {
+		return err
+	}
+
+	const query = "INSERT INTO nest_history (nest_id,pokemon_id,pokemon_form,detected_at,pokemon_avg,pokemon_ratio,pokemon_count) VALUES (:nest_id,:pokemon_id,:pokemon_form,:detected_at,:pokemon_avg,:pokemon_ratio,:pokemon_count)"
+
+	if _, err := tx.NamedExecContext(ctx, query, history); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *NestsDBStore) endNestHistory(ctx context.Context, queryer dbQueryer, nestId int64, endedAt int64, stats *NestHistoryStats) error {
+	if stats == nil {
+		const query = "UPDATE nest_history SET ended_at=? WHERE nest_id=? AND ended_at IS NULL"
+		_, err := queryer.ExecContext(ctx, query, endedAt, nestId)
+		return err
+	}
+
+	const query = "UPDATE nest_history SET ended_at=?,pokemon_avg=?,pokemon_ratio=?,pokemon_count=? WHERE nest_id=? AND ended_at IS NULL"
+	_, err := queryer.ExecContext(ctx, query, endedAt, stats.PokemonAvg, stats.PokemonRatio, stats.PokemonCount, nestId)
+	return err
+}
+
+// EndNestHistory closes the open history for a nest, if there is one.
+// If 'stats' is not nil, the history's stats are updated with it.
+func (st *NestsDBStore) EndNestHistory(ctx context.Context, nestId int64, endedAt int64, stats *NestHistoryStats) error {
+	return st.endNestHistory(ctx, st.db, nestId, endedAt, stats)
+}
+
+func (st *NestsDBStore) queryNestHistory(ctx context.Context, query string, args ...any) (history []*NestHistory, err error) {
+	rows, err := st.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		err = closeRows(rows, err)
+		if err != nil {
+			history = nil
+		}
+	}()
+
+	history = make([]*NestHistory, 0)
+
+	for rows.Next() {
+		var entry NestHistory
+
+		if err = rows.StructScan(&entry); err != nil {
+			return
+		}
+
+		history = append(history, &entry)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// GetNestHistory returns the history for a nest, newest first.
+func (st *NestsDBStore) GetNestHistory(ctx context.Context, nestId int64, limit int) ([]*NestHistory, error) {
+	const query = "SELECT " + nestHistorySelectColumns + " FROM nest_history h LEFT JOIN nests n ON n.nest_id=h.nest_id WHERE h.nest_id=? ORDER BY h.detected_at DESC, h.id DESC LIMIT ?"
+	return st.queryNestHistory(ctx, query, nestId, limit)
+}
+
+// GetPokemonHistory returns the history for a pokemon that was nesting
+// at some point after 'since' (epoch), newest first. If 'formId' is not
+// nil, only that form is returned.
+func (st *NestsDBStore) GetPokemonHistory(ctx context.Context, pokemonId int, formId *int, since int64, limit int) ([]*NestHistory, error) {
+	const queryPrefix = "SELECT " + nestHistorySelectColumns + " FROM nest_history h LEFT JOIN nests n ON n.nest_id=h.nest_id WHERE h.pokemon_id=? AND (h.ended_at IS NULL OR h.ended_at >= ?) "
+	const querySuffix = "ORDER BY h.detected_at DESC, h.id DESC LIMIT ?"
+
+	if formId != nil {
+		return st.queryNestHistory(ctx, queryPrefix+"AND h.pokemon_form=? "+querySuffix, pokemonId, since, *formId, limit)
+	}
+	return st.queryNestHistory(ctx, queryPrefix+querySuffix, pokemonId, since, limit)
+}
@@ -0,0 +1,260 @@
+package db_store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v4"
+)
+
+// recordingDriver is a database/sql driver that records the statements
+// run against it. Queries return 'rows'.
+type recordingDriver struct {
+	mutex      sync.Mutex
+	statements []recordedStatement
+	committed  bool
+	columns    []string
+	rows       [][]driver.Value
+}
+
+type recordedStatement struct {
+	query string
+	args  []driver.Value
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{c.d, query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+func (c *recordingConn) Commit() error {
+	c.d.mutex.Lock()
+	defer c.d.mutex.Unlock()
+	c.d.committed = true
+	return nil
+}
+
+type recordingStmt struct {
+	d     *recordingDriver
+	query string
+}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) record(args []driver.Value) {
+	s.d.mutex.Lock()
+	defer s.d.mutex.Unlock()
+	s.d.statements = append(s.d.statements, recordedStatement{s.query, args})
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	return &recordingRows{columns: s.d.columns, rows: s.d.rows}, nil
+}
+
+type recordingRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var registerDriverOnce sync.Once
+var testDriver struct {
+	mutex sync.Mutex
+	d     *recordingDriver
+}
+
+// newTestNestsDBStore returns a store whose statements are recorded by
+// the returned driver.
+func newTestNestsDBStore(t *testing.T) (*NestsDBStore, *recordingDriver) {
+	t.Helper()
+
+	registerDriverOnce.Do(func() {
+		sql.Register("fletchling-recording", &recordingDriverProxy{})
+	})
+
+	d := &recordingDriver{}
+	testDriver.mutex.Lock()
+	testDriver.d = d
+	testDriver.mutex.Unlock()
+
+	db, err := sql.Open("fletchling-recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &NestsDBStore{
+		logger: logger,
+		db:     sqlx.NewDb(db, "mysql"),
+	}, d
+}
+
+// recordingDriverProxy hands connections to the driver of the current test.
+type recordingDriverProxy struct{}
+
+func (*recordingDriverProxy) Open(name string) (driver.Conn, error) {
+	testDriver.mutex.Lock()
+	defer testDriver.mutex.Unlock()
+	return testDriver.d.Open(name)
+}
+
+func TestNestsDBStoreStartNestHistory(t *testing.T) {
+	history := &NestHistory{
+		NestId:       5,
+		PokemonId:    25,
+		PokemonForm:  598,
+		DetectedAt:   1714564800,
+		PokemonAvg:   null.FloatFrom(7.5),
+		PokemonRatio: null.FloatFrom(25),
+		PokemonCount: null.FloatFrom(30),
+	}
+
+	tests := []struct {
+		name  string
+		stats *NestHistoryStats
+		want  []recordedStatement
+	}{
+		{
+			name: "without stats",
+			want: []recordedStatement{
+				{
+					query: "UPDATE nest_history SET ended_at=? WHERE nest_id=? AND ended_at IS NULL",
+					args:  []driver.Value{int64(1714564800), int64(5)},
+				},
+			},
+		},
+		{
+			name: "with stats",
+			stats: &NestHistoryStats{
+				PokemonAvg:   null.FloatFrom(2.5),
+				PokemonRatio: null.FloatFrom(10),
+				PokemonCount: null.FloatFrom(12),
+			},
+			want: []recordedStatement{
+				{
+					query: "UPDATE nest_history SET ended_at=?,pokemon_avg=?,pokemon_ratio=?,pokemon_count=? WHERE nest_id=? AND ended_at IS NULL",
+					args:  []driver.Value{int64(1714564800), 2.5, float64(10), float64(12), int64(5)},
+				},
+			},
+		},
+	}
+
+	insert := recordedStatement{
+		query: "INSERT INTO nest_history (nest_id,pokemon_id,pokemon_form,detected_at,pokemon_avg,pokemon_ratio,pokemon_count) VALUES (?,?,?,?,?,?,?)",
+		args:  []driver.Value{int64(5), int64(25), int64(598), int64(1714564800), 7.5, float64(25), float64(30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, d := newTestNestsDBStore(t)
+
+			if err := st.StartNestHistory(context.Background(), history, tt.stats); err != nil {
+				t.Fatal(err)
+			}
+
+			want := append(tt.want, insert)
+			if !reflect.DeepEqual(d.statements, want) {
+				t.Errorf("got statements %v, want %v", d.statements, want)
+			}
+			if !d.committed {
+				t.Errorf("the transaction wasn't committed")
+			}
+		})
+	}
+}
+
+func TestNestsDBStoreGetPokemonHistory(t *testing.T) {
+	formId := 598
+
+	tests := []struct {
+		name   string
+		formId *int
+		want   recordedStatement
+	}{
+		{
+			name: "any form",
+			want: recordedStatement{
+				query: "SELECT " + nestHistorySelectColumns + " FROM nest_history h LEFT JOIN nests n ON n.nest_id=h.nest_id WHERE h.pokemon_id=? AND (h.ended_at IS NULL OR h.ended_at >= ?) ORDER BY h.detected_at DESC, h.id DESC LIMIT ?",
+				args:  []driver.Value{int64(25), int64(1714564800), int64(10)},
+			},
+		},
+		{
+			name:   "one form",
+			formId: &formId,
+			want: recordedStatement{
+				query: "SELECT " + nestHistorySelectColumns + " FROM nest_history h LEFT JOIN nests n ON n.nest_id=h.nest_id WHERE h.pokemon_id=? AND (h.ended_at IS NULL OR h.ended_at >= ?) AND h.pokemon_form=? ORDER BY h.detected_at DESC, h.id DESC LIMIT ?",
+				args:  []driver.Value{int64(25), int64(1714564800), int64(598), int64(10)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, d := newTestNestsDBStore(t)
+			d.columns = []string{"id", "nest_id", "pokemon_id", "pokemon_form", "detected_at", "ended_at", "pokemon_avg", "pokemon_ratio", "pokemon_count", "name", "area_name", "lat", "lon"}
+			d.rows = [][]driver.Value{
+				{int64(2), int64(5), int64(25), int64(598), int64(1714600000), nil, 7.5, float64(25), float64(30), "Park", "London/Chelsea", 51.48, -0.16},
+				// the nest is gone.
+				{int64(1), int64(6), int64(25), int64(598), int64(1714500000), int64(1714564800), nil, nil, nil, nil, nil, nil, nil},
+			}
+
+			history, err := st.GetPokemonHistory(context.Background(), 25, tt.formId, 1714564800, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(d.statements, []recordedStatement{tt.want}) {
+				t.Errorf("got statements %v, want %v", d.statements, []recordedStatement{tt.want})
+			}
+
+			want := []*NestHistory{
+				{
+					Id: 2, NestId: 5, PokemonId: 25, PokemonForm: 598, DetectedAt: 1714600000,
+					PokemonAvg: null.FloatFrom(7.5), PokemonRatio: null.FloatFrom(25), PokemonCount: null.FloatFrom(30),
+					Name: null.StringFrom("Park"), AreaName: null.StringFrom("London/Chelsea"), Lat: null.FloatFrom(51.48), Lon: null.FloatFrom(-0.16),
+				},
+				{
+					Id: 1, NestId: 6, PokemonId: 25, PokemonForm: 598, DetectedAt: 1714500000,
+					EndedAt: null.IntFrom(1714564800),
+				},
+			}
+			if !reflect.DeepEqual(history, want) {
+				t.Errorf("got history %+v, want %+v", history, want)
+			}
+		})
+	}
+}
@@ -38,8 +38,19 @@ type NestPartialUpdate struct {
 	PokemonAvg   *null.Float
 	PokemonRatio *null.Float
 	PokemonCount *null.Float
-	Discarded    *null.String
-	Updated      *null.Int
+	// AdditionalPokemon is the JSON for the additional_pokemon column.
+	AdditionalPokemon *null.String
+	Discarded         *null.String
+	Updated           *null.Int
+}
+
+// AdditionalNestPokemon is an entry in the additional_pokemon column.
+type AdditionalNestPokemon struct {
+	PokemonId    int     `json:"pokemon_id"`
+	PokemonForm  int     `json:"pokemon_form"`
+	PokemonAvg   float64 `json:"pokemon_avg"`
+	PokemonRatio float64 `json:"pokemon_ratio"`
+	PokemonCount float64 `json:"pokemon_count"`
 }
 
 type Nest struct {
@@ -57,8 +68,34 @@ type Nest struct {
 	PokemonAvg   null.Float  `db:"pokemon_avg"`
 	PokemonRatio null.Float  `db:"pokemon_ratio"`
 	PokemonCount null.Float  `db:"pokemon_count"`
-	Discarded    null.String `db:"discarded"`
-	Updated      null.Int    `db:"updated"`
+	// JSON array of AdditionalNestPokemon.
+	AdditionalPokemon null.String `db:"additional_pokemon"`
+	Discarded         null.String `db:"discarded"`
+	Updated           null.Int    `db:"updated"`
+}
+
+// GetAdditionalPokemon decodes the additional_pokemon column.
+func (nest *Nest) GetAdditionalPokemon() ([]AdditionalNestPokemon, error) {
+	if nest.AdditionalPokemon.ValueOrZero() == "" {
+		return nil, nil
+	}
+
+	var additional []AdditionalNestPokemon
+	if err := json.Unmarshal([]byte(nest.AdditionalPokemon.String), &additional); err != nil {
+		return nil, fmt.Errorf("nest '%s' has invalid additional_pokemon: %w", nest.FullName(), err)
+	}
+	return additional, nil
+}
+
+// SetAdditionalPokemon encodes 'additional' into the additional_pokemon
+// column. Empty is stored as NULL.
+func (nest *Nest) SetAdditionalPokemon(additional []AdditionalNestPokemon) {
+	if len(additional) == 0 {
+		nest.AdditionalPokemon = null.String{}
+		return
+	}
+	b, _ := json.Marshal(additional)
+	nest.AdditionalPokemon = null.StringFrom(string(b))
 }
 
 func (nest *Nest) AsFeature() (*geojson.Feature, error) {
@@ -116,7 +153,7 @@ type NestsDBStore struct {
 }
 
 func (st *NestsDBStore) updateNestPartial(ctx context.Context, queryer dbQueryer, nestId int64, nestUpdate *NestPartialUpdate) error {
-	var args [12]any
+	var args [13]any
 	var query bytes.Buffer
 
 	query.WriteString("UPDATE nests SET ")
@@ -159,6 +196,9 @@ func (st *NestsDBStore) updateNestPartial(ctx context.Context, queryer dbQueryer
 	if v := nestUpdate.PokemonCount; v != nil {
 		addValue("pokemon_count=?", *v)
 	}
+	if v := nestUpdate.AdditionalPokemon; v != nil {
+		addValue("additional_pokemon=?", *v)
+	}
 	if v := nestUpdate.Active; v != nil {
 		addValue("active=?", *v)
 	}
@@ -194,16 +234,16 @@ func (st *NestsDBStore) disableOverlappingNests(ctx context.Context, queryer dbQ
 }
 
 const (
-	nestColumns             = "nest_id,lat,lon,name,polygon,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,discarded,updated"
-	nestSelectColumns       = "nest_id,lat,lon,name,ST_AsGeoJSON(polygon) as polygon,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,discarded,updated"
-	nestSelectColumnsNoPoly = "nest_id,lat,lon,name,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,discarded,updated"
+	nestColumns             = "nest_id,lat,lon,name,polygon,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,additional_pokemon,discarded,updated"
+	nestSelectColumns       = "nest_id,lat,lon,name,ST_AsGeoJSON(polygon) as polygon,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,additional_pokemon,discarded,updated"
+	nestSelectColumnsNoPoly = "nest_id,lat,lon,name,area_name,spawnpoints,m2,active,pokemon_id,pokemon_form,pokemon_avg,pokemon_ratio,pokemon_count,additional_pokemon,discarded,updated"
 )
 
 // InsertOrUpdateNest will insert a new nest or update an existing one. If updating,
 // the nesting pokemon and info will be preserved. This is meant for importing into the
 // DB.
 func (st *NestsDBStore) InsertOrUpdateNest(ctx context.Context, nest *Nest) error {
-	const nestBaseInsertQuery = "INSERT into nests (" + nestColumns + ") VALUES (:nest_id,:lat,:lon,:name,ST_GeomFromGeoJSON(:polygon),:area_name,:spawnpoints,:m2,:active,:pokemon_id,:pokemon_form,:pokemon_avg,:pokemon_ratio,:pokemon_count,:discarded,:updated)"
+	const nestBaseInsertQuery = "INSERT into nests (" + nestColumns + ") VALUES (:nest_id,:lat,:lon,:name,ST_GeomFromGeoJSON(:polygon),:area_name,:spawnpoints,:m2,:active,:pokemon_id,:pokemon_form,:pokemon_avg,:pokemon_ratio,:pokemon_count,:additional_pokemon,:discarded,:updated)"
 	const nestInsertUpdateQuery = nestBaseInsertQuery + " ON DUPLICATE KEY UPDATE name=VALUES(name),lat=VALUES(lat),lon=VALUES(lon),polygon=VALUES(polygon),area_name=VALUES(area_name),spawnpoints=VALUES(spawnpoints),m2=VALUES(m2),active=VALUES(active),discarded=VALUES(discarded),updated=VALUES(updated)"
 
 	_, err := st.db.NamedExecContext(ctx, nestInsertUpdateQuery, nest)
@@ -0,0 +1,18 @@
+-- History of nesting pokemon. A row is open (ended_at is NULL)
+-- while the pokemon is nesting. The stats are the last known
+-- stats when the row was closed.
+CREATE TABLE IF NOT EXISTS `nest_history` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `nest_id` bigint(20) NOT NULL,
+  `pokemon_id` int(11) NOT NULL,
+  `pokemon_form` smallint(6) NOT NULL DEFAULT 0,
+  `detected_at` int(10) NOT NULL,
+  `ended_at` int(10) DEFAULT NULL,
+  `pokemon_avg` float DEFAULT NULL,
+  `pokemon_ratio` float DEFAULT NULL,
+  `pokemon_count` float DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ix_nest_history_nest_id` (`nest_id`,`detected_at`),
+  KEY `ix_nest_history_pokemon` (`pokemon_id`,`detected_at`),
+  KEY `ix_nest_history_ended_at` (`ended_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
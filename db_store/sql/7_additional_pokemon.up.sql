@@ -0,0 +1,22 @@
+-- Pokemon nesting in a nest besides pokemon_id, ranked, as a JSON
+-- array of objects with the same keys as the pokemon_* columns.
+ALTER TABLE nests
+    ADD COLUMN `additional_pokemon` text DEFAULT NULL AFTER `pokemon_count`;
+
+-- Also clear additional_pokemon when disabling overlapping nests.
+DROP PROCEDURE IF EXISTS fl_nest_filter_overlap;
+CREATE PROCEDURE fl_nest_filter_overlap (IN maximum_overlap double)
+BEGIN
+  DROP TEMPORARY TABLE IF EXISTS overlapNest;
+  CREATE TEMPORARY TABLE overlapNest AS (
+    SELECT b.nest_id
+    FROM nests a, nests b
+    WHERE a.active = 1 AND b.active = 1 AND
+        a.m2 > b.m2 AND
+        ST_Intersects(a.polygon, b.polygon) AND
+        ST_GeometryType(ST_Intersection(a.polygon, b.polygon)) IN ('Polygon', 'MultiPolygon') AND
+        (100 * ST_Area(ST_Intersection(a.polygon,b.polygon)) / ST_Area(b.polygon)) > maximum_overlap
+  );
+  UPDATE nests a, overlapNest b SET a.active=0,a.discarded='overlap',a.pokemon_id=NULL,a.pokemon_form=NULL,a.pokemon_avg=NULL,a.pokemon_count=NULL,a.pokemon_ratio=NULL,a.additional_pokemon=NULL WHERE a.nest_id=b.nest_id;
+  DROP TEMPORARY TABLE overlapNest;
+END
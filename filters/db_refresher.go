@@ -184,6 +184,8 @@ func (refresher *DBRefresher) refreshNest(ctx context.Context, config RefreshNes
 		partialUpdate.PokemonAvg = &nest.PokemonAvg
 		nest.PokemonCount.Valid = false
 		partialUpdate.PokemonCount = &nest.PokemonCount
+		nest.AdditionalPokemon.Valid = false
+		partialUpdate.AdditionalPokemon = &nest.AdditionalPokemon
 	}
 
 	if partialUpdate == nil {
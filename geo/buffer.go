@@ -0,0 +1,167 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+const (
+	// meters per degree of latitude, and of longitude at the equator.
+	metersPerDegree = 6378137 * math.Pi / 180
+	// vertices at sharp corners move at most this many times the
+	// buffer distance, so that spikes don't shoot off into the distance.
+	bufferMiterLimit = 4
+)
+
+// BufferGeometry grows a Polygon or MultiPolygon by 'meters' on every
+// side, or shrinks it if 'meters' is negative. Each vertex is moved
+// along the bisector of its two edges in a flat projection around the
+// ring, which is good for the few meters needed to absorb GPS jitter
+// but not for large distances. Holes shrink as the polygon grows.
+// Rings that collapse are dropped, and an error is returned if nothing
+// is left.
+func BufferGeometry(geometry orb.Geometry, meters float64) (orb.Geometry, error) {
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		polygon := bufferPolygon(g, meters)
+		if polygon == nil {
+			return nil, errors.New("polygon collapsed")
+		}
+		return polygon, nil
+	case orb.MultiPolygon:
+		var multiPolygon orb.MultiPolygon
+		for _, polygon := range g {
+			if polygon := bufferPolygon(polygon, meters); polygon != nil {
+				multiPolygon = append(multiPolygon, polygon)
+			}
+		}
+		if len(multiPolygon) == 0 {
+			return nil, errors.New("all polygons collapsed")
+		}
+		return multiPolygon, nil
+	default:
+		return nil, fmt.Errorf("GeoJSONType %s is not supported", geometry.GeoJSONType())
+	}
+}
+
+func bufferPolygon(polygon orb.Polygon, meters float64) orb.Polygon {
+	if len(polygon) == 0 {
+		return nil
+	}
+
+	outer := bufferRing(polygon[0], meters)
+	if outer == nil {
+		return nil
+	}
+
+	buffered := orb.Polygon{outer}
+	for _, hole := range polygon[1:] {
+		if hole := bufferRing(hole, -meters); hole != nil {
+			buffered = append(buffered, hole)
+		}
+	}
+	return buffered
+}
+
+// bufferRing moves the ring outwards by 'meters', or inwards if
+// negative, regardless of its winding order. Returns nil if the ring
+// collapses.
+func bufferRing(ring orb.Ring, meters float64) orb.Ring {
+	center := ring.Bound().Center()
+	xScale := metersPerDegree * math.Cos(center.Lat()*math.Pi/180)
+	yScale := metersPerDegree
+
+	// project to meters around the center, dropping repeated points
+	// and the closing point.
+	points := make([]orb.Point, 0, len(ring))
+	for _, p := range ring {
+		projected := orb.Point{(p.Lon() - center.Lon()) * xScale, (p.Lat() - center.Lat()) * yScale}
+		if len(points) > 0 && pointsClose(points[len(points)-1], projected) {
+			continue
+		}
+		points = append(points, projected)
+	}
+	if len(points) > 1 && pointsClose(points[0], points[len(points)-1]) {
+		points = points[:len(points)-1]
+	}
+
+	num := len(points)
+	if num < 3 {
+		return nil
+	}
+
+	area := signedArea(points)
+	if area == 0 {
+		return nil
+	}
+
+	// outward normal of each edge. Counter-clockwise rings have the
+	// outside on the right.
+	sign := 1.0
+	if area < 0 {
+		sign = -1
+	}
+
+	directions := make([]orb.Point, num)
+	normals := make([]orb.Point, num)
+	for idx, p := range points {
+		next := points[(idx+1)%num]
+		dx, dy := next[0]-p[0], next[1]-p[1]
+		length := math.Hypot(dx, dy)
+		directions[idx] = orb.Point{dx / length, dy / length}
+		normals[idx] = orb.Point{sign * dy / length, -sign * dx / length}
+	}
+
+	moved := make([]orb.Point, num)
+	for idx, p := range points {
+		prevNormal, nextNormal := normals[(idx+num-1)%num], normals[idx]
+
+		bx, by := prevNormal[0]+nextNormal[0], prevNormal[1]+nextNormal[1]
+		distance := meters
+		if length := math.Hypot(bx, by); length < 1e-9 {
+			// the ring doubles back on itself here.
+			bx, by = nextNormal[0], nextNormal[1]
+		} else {
+			bx, by = bx/length, by/length
+			cos := bx*nextNormal[0] + by*nextNormal[1]
+			distance = meters / max(cos, 1.0/bufferMiterLimit)
+		}
+
+		moved[idx] = orb.Point{p[0] + bx*distance, p[1] + by*distance}
+	}
+
+	// shrinking past the middle turns edges around or the ring
+	// inside out.
+	for idx, p := range moved {
+		next := moved[(idx+1)%num]
+		if (next[0]-p[0])*directions[idx][0]+(next[1]-p[1])*directions[idx][1] <= 0 {
+			return nil
+		}
+	}
+	if movedArea := signedArea(moved); movedArea == 0 || (movedArea < 0) != (area < 0) {
+		return nil
+	}
+
+	buffered := make(orb.Ring, 0, num+1)
+	for _, p := range moved {
+		buffered = append(buffered, orb.Point{center.Lon() + p[0]/xScale, center.Lat() + p[1]/yScale})
+	}
+	return append(buffered, buffered[0])
+}
+
+func pointsClose(p1, p2 orb.Point) bool {
+	return math.Abs(p1[0]-p2[0]) < 1e-6 && math.Abs(p1[1]-p2[1]) < 1e-6
+}
+
+// signedArea is positive for counter-clockwise points.
+func signedArea(points []orb.Point) float64 {
+	var area float64
+	for idx, p := range points {
+		next := points[(idx+1)%len(points)]
+		area += p[0]*next[1] - next[0]*p[1]
+	}
+	return area / 2
+}
@@ -0,0 +1,182 @@
+package geo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+const testLat = 51.5
+
+// testRing converts points in meters east and north of (testLat, 0)
+// to a closed ring.
+func testRing(points ...[2]float64) orb.Ring {
+	xScale := metersPerDegree * math.Cos(testLat*math.Pi/180)
+	ring := make(orb.Ring, 0, len(points)+1)
+	for _, p := range points {
+		ring = append(ring, orb.Point{p[0] / xScale, testLat + p[1]/metersPerDegree})
+	}
+	return append(ring, ring[0])
+}
+
+func testSquare(x, y, size float64) orb.Ring {
+	return testRing([2]float64{x, y}, [2]float64{x + size, y}, [2]float64{x + size, y + size}, [2]float64{x, y + size})
+}
+
+func reversedRing(ring orb.Ring) orb.Ring {
+	reversed := make(orb.Ring, len(ring))
+	for idx, p := range ring {
+		reversed[len(ring)-1-idx] = p
+	}
+	return reversed
+}
+
+func reversedGeometry(geometry orb.Geometry) orb.Geometry {
+	reversePolygon := func(polygon orb.Polygon) orb.Polygon {
+		reversed := make(orb.Polygon, len(polygon))
+		for idx, ring := range polygon {
+			reversed[idx] = reversedRing(ring)
+		}
+		return reversed
+	}
+
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		return reversePolygon(g)
+	case orb.MultiPolygon:
+		reversed := make(orb.MultiPolygon, len(g))
+		for idx, polygon := range g {
+			reversed[idx] = reversePolygon(polygon)
+		}
+		return reversed
+	default:
+		return geometry
+	}
+}
+
+// testRingAreaM2 is the unsigned area of the ring in square meters.
+func testRingAreaM2(ring orb.Ring) float64 {
+	xScale := metersPerDegree * math.Cos(testLat*math.Pi/180)
+	points := make([]orb.Point, len(ring)-1)
+	for idx := range points {
+		points[idx] = orb.Point{ring[idx].Lon() * xScale, (ring[idx].Lat() - testLat) * metersPerDegree}
+	}
+	return math.Abs(signedArea(points))
+}
+
+// testAreaM2 returns the area of the geometry, minus its holes, and
+// the number of rings in it.
+func testAreaM2(t *testing.T, geometry orb.Geometry) (float64, int) {
+	t.Helper()
+
+	var polygons []orb.Polygon
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		polygons = []orb.Polygon{g}
+	case orb.MultiPolygon:
+		polygons = g
+	default:
+		t.Fatalf("unexpected geometry type %T", geometry)
+	}
+
+	var area float64
+	var numRings int
+	for _, polygon := range polygons {
+		for idx, ring := range polygon {
+			if len(ring) < 4 || !ring[0].Equal(ring[len(ring)-1]) {
+				t.Fatalf("ring is not closed: %v", ring)
+			}
+			if idx == 0 {
+				area += testRingAreaM2(ring)
+			} else {
+				area -= testRingAreaM2(ring)
+			}
+			numRings++
+		}
+	}
+	return area, numRings
+}
+
+func TestBufferGeometry(t *testing.T) {
+	// 100m square with a 20m square hole in the middle.
+	withHole := orb.Polygon{testSquare(0, 0, 100), reversedRing(testSquare(40, 40, 20))}
+	// an L with 40m wide arms, 100m long.
+	lShape := orb.Polygon{testRing(
+		[2]float64{0, 0}, [2]float64{100, 0}, [2]float64{100, 40},
+		[2]float64{40, 40}, [2]float64{40, 100}, [2]float64{0, 100},
+	)}
+	twoSquares := orb.MultiPolygon{{testSquare(0, 0, 100)}, {testSquare(200, 0, 10)}}
+
+	tests := []struct {
+		name      string
+		geometry  orb.Geometry
+		meters    float64
+		wantErr   bool
+		wantArea  float64
+		wantRings int
+	}{
+		{"convex grow", orb.Polygon{testSquare(0, 0, 100)}, 5, false, 110 * 110, 1},
+		{"convex shrink", orb.Polygon{testSquare(0, 0, 100)}, -5, false, 90 * 90, 1},
+		{"convex no change", orb.Polygon{testSquare(0, 0, 100)}, 0, false, 100 * 100, 1},
+		{"convex collapses", orb.Polygon{testSquare(0, 0, 100)}, -60, true, 0, 0},
+		// area + perimeter*d + 4*d^2 for any rectilinear ring.
+		{"concave grow", lShape, 5, false, 6400 + 400*5 + 4*25, 1},
+		{"concave shrink", lShape, -5, false, 6400 - 400*5 + 4*25, 1},
+		{"concave collapses", lShape, -25, true, 0, 0},
+		{"hole shrinks", withHole, 5, false, 110*110 - 10*10, 2},
+		{"hole grows", withHole, -5, false, 90*90 - 30*30, 2},
+		{"hole collapses", withHole, 15, false, 130 * 130, 1},
+		{"one polygon collapses", twoSquares, -10, false, 80 * 80, 1},
+		{"all polygons collapse", twoSquares, -60, true, 0, 0},
+		{"unsupported", orb.Point{0, testLat}, 5, true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		for _, reversed := range []bool{false, true} {
+			geometry := tt.geometry
+			name := tt.name
+			if reversed {
+				geometry = reversedGeometry(geometry)
+				name += " (reversed)"
+			}
+
+			buffered, err := BufferGeometry(geometry, tt.meters)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%s: expected an error, got %v", name, buffered)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+				continue
+			}
+
+			if buffered.GeoJSONType() != geometry.GeoJSONType() {
+				t.Errorf("%s: got a %s, want a %s", name, buffered.GeoJSONType(), geometry.GeoJSONType())
+			}
+
+			area, numRings := testAreaM2(t, buffered)
+			if math.Abs(area-tt.wantArea) > tt.wantArea*0.001 {
+				t.Errorf("%s: got area %0.1f m2, want %0.1f m2", name, area, tt.wantArea)
+			}
+			if numRings != tt.wantRings {
+				t.Errorf("%s: got %d ring(s), want %d", name, numRings, tt.wantRings)
+			}
+		}
+	}
+}
+
+func TestBufferGeometryKeepsWinding(t *testing.T) {
+	for _, ring := range []orb.Ring{testSquare(0, 0, 100), reversedRing(testSquare(0, 0, 100))} {
+		buffered, err := BufferGeometry(orb.Polygon{ring}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufferedRing := buffered.(orb.Polygon)[0]
+		if (ring.Orientation() > 0) != (bufferedRing.Orientation() > 0) {
+			t.Errorf("winding changed from %d to %d", ring.Orientation(), bufferedRing.Orientation())
+		}
+	}
+}
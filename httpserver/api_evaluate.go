@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+type APINestEvaluation struct {
+	NestId       int64                      `json:"nest_id"`
+	Name         string                     `json:"name"`
+	AreaName     *string                    `json:"area_name"`
+	Current      *models.NestingPokemonInfo `json:"current"`
+	Hypothetical *models.NestingPokemonInfo `json:"hypothetical"`
+	Changed      bool                       `json:"changed"`
+}
+
+type evaluateResponse struct {
+	NumNests   int                  `json:"num_nests"`
+	NumChanged int                  `json:"num_changed"`
+	Nests      []*APINestEvaluation `json:"nests"`
+}
+
+// handleEvaluate takes a partial processor config, merges it over the
+// current config, and returns what the nesting pokemon would be with it.
+// ?changed_only=1 limits the nests returned to those that would change.
+func (srv *HTTPServer) handleEvaluate(c *gin.Context) {
+	nestProcessor := srv.nestProcessorManager.GetNestProcessor()
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"failed to read request"})
+		return
+	}
+
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(body, &fields); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"bad request json"})
+		return
+	}
+
+	curConfig := nestProcessor.GetConfig()
+	config := curConfig
+	if _, ok := fields["overrides"]; ok {
+		// replace, don't merge into (and modify) the current ones.
+		config.Overrides = nil
+	}
+	if _, ok := fields["form_rules"]; ok {
+		// same. Evaluate() rejects changes to them.
+		config.FormRules = nil
+	}
+
+	if err := json.Unmarshal(body, &config); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"bad request json"})
+		return
+	}
+
+	// this would read any file the server can.
+	if config.NestingSpeciesFilename != curConfig.NestingSpeciesFilename {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"'nesting_species_filename' can't be evaluated. Change it in the config and reload instead."})
+		return
+	}
+
+	if err := config.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{err.Error()})
+		return
+	}
+
+	evaluations, err := nestProcessor.Evaluate(config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{err.Error()})
+		return
+	}
+
+	changedOnly := c.Query("changed_only") == "1"
+
+	resp := evaluateResponse{
+		NumNests: len(evaluations),
+		Nests:    make([]*APINestEvaluation, 0, len(evaluations)),
+	}
+
+	for _, evaluation := range evaluations {
+		if evaluation.Changed {
+			resp.NumChanged++
+		} else if changedOnly {
+			continue
+		}
+
+		nest := evaluation.Nest
+		resp.Nests = append(resp.Nests, &APINestEvaluation{
+			NestId:       nest.Id,
+			Name:         nest.Name,
+			AreaName:     nest.AreaName.Ptr(),
+			Current:      evaluation.Current,
+			Hypothetical: evaluation.Hypothetical,
+			Changed:      evaluation.Changed,
+		})
+	}
+
+	sort.Slice(resp.Nests, func(i, j int) bool {
+		return resp.Nests[i].NestId < resp.Nests[j].NestId
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
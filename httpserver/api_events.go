@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/UnownHash/Fletchling/processor"
+)
+
+type getEventsResponse struct {
+	Events []processor.Event `json:"events"`
+}
+
+type getOneEventResponse struct {
+	Event processor.Event `json:"event"`
+}
+
+func (srv *HTTPServer) handleGetEvents(c *gin.Context) {
+	calendar := srv.nestProcessorManager.GetNestProcessor().GetEventCalendar()
+	c.JSON(http.StatusOK, getEventsResponse{calendar.GetEvents()})
+}
+
+func (srv *HTTPServer) handleAddEvent(c *gin.Context) {
+	var event processor.Event
+
+	if err := c.BindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"bad request json"})
+		return
+	}
+
+	if err := event.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{err.Error()})
+		return
+	}
+
+	calendar := srv.nestProcessorManager.GetNestProcessor().GetEventCalendar()
+	event, err := calendar.AddEvent(event)
+	if err != nil {
+		srv.logger.Errorf("AddEvent: failed to save events: %v", err)
+		c.JSON(http.StatusInternalServerError, &APIErrorResponse{"event added, but it could not be saved: check the logs"})
+		return
+	}
+
+	srv.logger.Infof("AddEvent: added event %d '%s' (%s to %s)", event.Id, event.Name, event.StartTime, event.EndTime)
+
+	c.JSON(http.StatusOK, getOneEventResponse{event})
+}
+
+func (srv *HTTPServer) handleUpdateEvent(c *gin.Context) {
+	eventId, err := strconv.ParseInt(c.Param("event_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"malformed event ID"})
+		return
+	}
+
+	var event processor.Event
+
+	if err := c.BindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"bad request json"})
+		return
+	}
+
+	if err := event.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{err.Error()})
+		return
+	}
+
+	event.Id = eventId
+
+	calendar := srv.nestProcessorManager.GetNestProcessor().GetEventCalendar()
+	found, err := calendar.UpdateEvent(event)
+	if !found {
+		c.JSON(http.StatusNotFound, &APIErrorResponse{"Event not found"})
+		return
+	}
+	if err != nil {
+		srv.logger.Errorf("UpdateEvent: failed to save events: %v", err)
+		c.JSON(http.StatusInternalServerError, &APIErrorResponse{"event updated, but it could not be saved: check the logs"})
+		return
+	}
+
+	srv.logger.Infof("UpdateEvent: updated event %d '%s' (%s to %s)", event.Id, event.Name, event.StartTime, event.EndTime)
+
+	c.JSON(http.StatusOK, getOneEventResponse{event})
+}
+
+func (srv *HTTPServer) handleDeleteEvent(c *gin.Context) {
+	eventId, err := strconv.ParseInt(c.Param("event_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"malformed event ID"})
+		return
+	}
+
+	calendar := srv.nestProcessorManager.GetNestProcessor().GetEventCalendar()
+	found, err := calendar.RemoveEvent(eventId)
+	if !found {
+		c.JSON(http.StatusNotFound, &APIErrorResponse{"Event not found"})
+		return
+	}
+	if err != nil {
+		srv.logger.Errorf("DeleteEvent: failed to save events: %v", err)
+		c.JSON(http.StatusInternalServerError, &APIErrorResponse{"event removed, but the change could not be saved: check the logs"})
+		return
+	}
+
+	srv.logger.Infof("DeleteEvent: removed event %d", eventId)
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/UnownHash/Fletchling/db_store"
+)
+
+const (
+	DEFAULT_HISTORY_LIMIT = 100
+	MAX_HISTORY_LIMIT     = 1000
+)
+
+type APINestHistory struct {
+	NestId       int64      `json:"nest_id"`
+	Name         *string    `json:"name"`
+	AreaName     *string    `json:"area_name"`
+	Lat          *float64   `json:"lat"`
+	Lon          *float64   `json:"lon"`
+	PokemonId    int        `json:"pokemon_id"`
+	Form         int        `json:"form"`
+	DetectedAt   time.Time  `json:"detected_at"`
+	EndedAt      *time.Time `json:"ended_at"`
+	PokemonAvg   *float64   `json:"pokemon_avg"`
+	PokemonRatio *float64   `json:"pokemon_ratio"`
+	PokemonCount *float64   `json:"pokemon_count"`
+}
+
+type getHistoryResponse struct {
+	History []*APINestHistory `json:"history"`
+}
+
+func nestHistoryToAPINestHistory(history *db_store.NestHistory) *APINestHistory {
+	var endedAt *time.Time
+
+	if history.EndedAt.Valid {
+		t := time.Unix(history.EndedAt.Int64, 0)
+		endedAt = &t
+	}
+
+	return &APINestHistory{
+		NestId:       history.NestId,
+		Name:         history.Name.Ptr(),
+		AreaName:     history.AreaName.Ptr(),
+		Lat:          history.Lat.Ptr(),
+		Lon:          history.Lon.Ptr(),
+		PokemonId:    history.PokemonId,
+		Form:         history.PokemonForm,
+		DetectedAt:   time.Unix(history.DetectedAt, 0),
+		EndedAt:      endedAt,
+		PokemonAvg:   history.PokemonAvg.Ptr(),
+		PokemonRatio: history.PokemonRatio.Ptr(),
+		PokemonCount: history.PokemonCount.Ptr(),
+	}
+}
+
+func historyToResponse(history []*db_store.NestHistory) getHistoryResponse {
+	apiHistory := make([]*APINestHistory, len(history))
+	for idx, entry := range history {
+		apiHistory[idx] = nestHistoryToAPINestHistory(entry)
+	}
+	return getHistoryResponse{apiHistory}
+}
+
+// historyLimit returns the 'limit' query param or the default.
+func historyLimit(c *gin.Context) (int, bool) {
+	limitStr := c.Query("limit")
+	if limitStr == "" {
+		return DEFAULT_HISTORY_LIMIT, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > MAX_HISTORY_LIMIT {
+		return 0, false
+	}
+	return limit, true
+}
+
+func (srv *HTTPServer) handleGetNestHistory(c *gin.Context) {
+	nestId, err := strconv.ParseInt(c.Param("nest_id"), 10, 64)
+	if err != nil {
+		srv.logger.Warnf("GetNestHistory: bad nest id: %v", err)
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{
+			Error: "malformed nest ID",
+		})
+		return
+	}
+
+	limit, ok := historyLimit(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"limit should be > 0 and <= " + strconv.Itoa(MAX_HISTORY_LIMIT)})
+		return
+	}
+
+	history, err := srv.nestProcessorManager.GetNestProcessor().GetNestHistory(c.Request.Context(), nestId, limit)
+	if err != nil {
+		srv.logger.Errorf("GetNestHistory: failed to query history for nest %d: %v", nestId, err)
+		c.JSON(http.StatusInternalServerError, &APIErrorResponse{"failed to query history: check the logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, historyToResponse(history))
+}
+
+func (srv *HTTPServer) handleGetPokemonHistory(c *gin.Context) {
+	pokemonId, err := strconv.Atoi(c.Query("pokemon_id"))
+	if err != nil || pokemonId <= 0 {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"pokemon_id is required"})
+		return
+	}
+
+	var formId *int
+
+	if formStr := c.Query("form"); formStr != "" {
+		form, err := strconv.Atoi(formStr)
+		if err != nil || form < 0 {
+			c.JSON(http.StatusBadRequest, &APIErrorResponse{"malformed form"})
+			return
+		}
+		formId = &form
+	}
+
+	var since time.Time
+
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		epoch, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || epoch < 0 {
+			c.JSON(http.StatusBadRequest, &APIErrorResponse{"since should be an epoch timestamp"})
+			return
+		}
+		since = time.Unix(epoch, 0)
+	}
+
+	limit, ok := historyLimit(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, &APIErrorResponse{"limit should be > 0 and <= " + strconv.Itoa(MAX_HISTORY_LIMIT)})
+		return
+	}
+
+	history, err := srv.nestProcessorManager.GetNestProcessor().GetPokemonHistory(c.Request.Context(), pokemonId, formId, since, limit)
+	if err != nil {
+		srv.logger.Errorf("GetPokemonHistory: failed to query history for pokemon %d: %v", pokemonId, err)
+		c.JSON(http.StatusInternalServerError, &APIErrorResponse{"failed to query history: check the logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, historyToResponse(history))
+}
@@ -25,6 +25,18 @@ type APINest struct {
 	Discarded      *string                    `json:"inactive_reason,omitempty"`
 	UpdatedAt      time.Time                  `json:"updated_at"`
 	NestingPokemon *models.NestingPokemonInfo `json:"nesting_pokemon"`
+	// PendingChange is a pokemon that may soon replace NestingPokemon.
+	PendingChange *models.PendingNestChange `json:"pending_change,omitempty"`
+	// Coverage is from the last evaluation.
+	Coverage *models.NestCoverage `json:"coverage,omitempty"`
+	// MatchGeometry is the geometry pokemon are matched against, when
+	// it differs from Geometry because of nest_buffer_meters. Like
+	// Geometry, it is only included for a single nest.
+	BufferMeters  float64           `json:"buffer_meters,omitempty"`
+	MatchGeometry *geojson.Geometry `json:"match_geometry,omitempty"`
+	// Candidates are from the last evaluation, even if there was
+	// no nesting pokemon.
+	Candidates []models.NestingCandidate `json:"candidates,omitempty"`
 }
 
 type getNestsResponse struct {
@@ -57,10 +69,15 @@ func nestToAPINest(nest *models.Nest, includeGeometry bool) *APINest {
 		Discarded:      discarded,
 		UpdatedAt:      updatedAt,
 		NestingPokemon: ni,
+		PendingChange:  nest.GetPendingChange(),
+		Coverage:       nest.GetCoverage(),
+		BufferMeters:   nest.BufferMeters,
 	}
 
 	if includeGeometry {
 		apiNest.Geometry = nest.Geometry
+		apiNest.MatchGeometry = nest.MatchGeometry
+		apiNest.Candidates = nest.GetNestingCandidates()
 	}
 
 	return apiNest
@@ -132,12 +149,17 @@ func (srv *HTTPServer) handleGetNestStats(c *gin.Context) {
 	}
 
 	stats := nestProcessor.GetStatsSnapshot()
+	minCoveragePct := nestProcessor.GetConfig().MinNestCoveragePct
+	now := time.Now()
 
 	type APIStatsTimePeriod struct {
 		StartTime       time.Time                  `json:"start_time"`
 		EndTime         time.Time                  `json:"end_time"`
 		DurationSeconds uint64                     `json:"duration_seconds"`
+		SkippedRanges   []processor.SkippedRange   `json:"skipped_ranges,omitempty"`
 		PokemonCounts   *processor.CountsByPokemon `json:"pokemon_counts"`
+		// only for nests.
+		Coverage *models.NestCoverage `json:"coverage,omitempty"`
 	}
 
 	type APINestStatsTimePeriods struct {
@@ -150,6 +172,11 @@ func (srv *HTTPServer) handleGetNestStats(c *gin.Context) {
 		NestStat        *APINestStatsTimePeriods   `json:"nest_stats,omitempty"`
 		NestStats       []*APINestStatsTimePeriods `json:"nests_stats,omitempty"`
 		GlobalStats     []*APIStatsTimePeriod      `json:"global_time_periods"`
+		SkippedPeriods  []processor.SkippedRange   `json:"skipped_time_periods"`
+		// how pokemon in overlapping nests are counted. The policy
+		// is applied when counting, so the nest counts above already
+		// have it applied.
+		NestMatchingPolicy string `json:"nest_matching_policy"`
 	}
 
 	type APINestStatsResponse struct {
@@ -169,17 +196,21 @@ func (srv *HTTPServer) handleGetNestStats(c *gin.Context) {
 			if nestEntry == nil {
 				nestEntry = processor.NewCountsByPokemon()
 			}
+			coverage := models.NewNestCoverage(nest, nestEntry.TotalSpawnpoints(), nestEntry.Total, tpCounts.Duration(now), minCoveragePct)
 			timePeriods[idx] = &APIStatsTimePeriod{
 				StartTime:       tpCounts.StartTime,
 				EndTime:         tpCounts.EndTime,
 				DurationSeconds: durationSec,
+				SkippedRanges:   tpCounts.SkippedRanges,
 				PokemonCounts:   nestEntry,
+				Coverage:        &coverage,
 			}
 			if globalPeriods[idx] == nil {
 				globalPeriods[idx] = &APIStatsTimePeriod{
 					StartTime:       tpCounts.StartTime,
 					EndTime:         tpCounts.EndTime,
 					DurationSeconds: durationSec,
+					SkippedRanges:   tpCounts.SkippedRanges,
 					PokemonCounts:   tpCounts.GlobalCounts,
 				}
 			}
@@ -195,6 +226,9 @@ func (srv *HTTPServer) handleGetNestStats(c *gin.Context) {
 		Stats: APINestStats{
 			DurationSeconds: uint64(stats.Duration / time.Second),
 			GlobalStats:     globalPeriods,
+			SkippedPeriods:  stats.SkippedPeriods,
+
+			NestMatchingPolicy: nestProcessor.GetConfig().NestMatchingPolicy,
 		},
 	}
 
@@ -1,14 +1,52 @@
 package httpserver
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// wait for room in the queue. Golbat waits, too.
+	QUEUE_FULL_POLICY_BLOCK = "block"
+	// throw the webhook away and tell the sender it was fine.
+	QUEUE_FULL_POLICY_SHED = "shed"
+	// throw the webhook away and respond with a 503.
+	QUEUE_FULL_POLICY_REJECT = "reject"
+
+	DEFAULT_WEBHOOK_WORKERS           = 4
+	DEFAULT_WEBHOOK_QUEUE_SIZE        = 100
+	DEFAULT_WEBHOOK_QUEUE_FULL_POLICY = QUEUE_FULL_POLICY_BLOCK
+)
 
 type Config struct {
 	Addr string `koanf:"addr"`
+	// Number of workers processing webhooks.
+	WebhookWorkers int `koanf:"webhook_workers"`
+	// Number of webhooks waiting to be processed before
+	// WebhookQueueFullPolicy kicks in.
+	WebhookQueueSize       int    `koanf:"webhook_queue_size"`
+	WebhookQueueFullPolicy string `koanf:"webhook_queue_full_policy"`
 }
 
 func (cfg *Config) Validate() error {
 	if cfg.Addr == "" {
 		return errors.New("no http addr configured")
 	}
+	if cfg.WebhookWorkers < 1 {
+		return fmt.Errorf("http webhook_workers should be at least 1, not %d", cfg.WebhookWorkers)
+	}
+	if cfg.WebhookQueueSize < 0 {
+		return fmt.Errorf("http webhook_queue_size should not be negative (%d)", cfg.WebhookQueueSize)
+	}
+	switch cfg.WebhookQueueFullPolicy {
+	case QUEUE_FULL_POLICY_BLOCK, QUEUE_FULL_POLICY_SHED, QUEUE_FULL_POLICY_REJECT:
+	default:
+		return fmt.Errorf("http webhook_queue_full_policy should be one of '%s', '%s', or '%s', not '%s'",
+			QUEUE_FULL_POLICY_BLOCK,
+			QUEUE_FULL_POLICY_SHED,
+			QUEUE_FULL_POLICY_REJECT,
+			cfg.WebhookQueueFullPolicy,
+		)
+	}
 	return nil
 }
@@ -0,0 +1,80 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// seen_type values sent by Golbat.
+const (
+	SEEN_TYPE_WILD           = "wild"
+	SEEN_TYPE_ENCOUNTER      = "encounter"
+	SEEN_TYPE_NEARBY_STOP    = "nearby_stop"
+	SEEN_TYPE_NEARBY_CELL    = "nearby_cell"
+	SEEN_TYPE_LURE_WILD      = "lure_wild"
+	SEEN_TYPE_LURE_ENCOUNTER = "lure_encounter"
+)
+
+// Reasons a pokemon was ignored by the ingest rules. These are also
+// the labels used for the ignored pokemon counters.
+const (
+	IGNORED_SEEN_TYPE     = "seen_type"
+	IGNORED_POKESTOP      = "pokestop"
+	IGNORED_EVENT         = "event"
+	IGNORED_NO_SPAWNPOINT = "no_spawnpoint"
+)
+
+var knownSeenTypes = []string{
+	SEEN_TYPE_WILD,
+	SEEN_TYPE_ENCOUNTER,
+	SEEN_TYPE_NEARBY_STOP,
+	SEEN_TYPE_NEARBY_CELL,
+	SEEN_TYPE_LURE_WILD,
+	SEEN_TYPE_LURE_ENCOUNTER,
+}
+
+// IngestConfig decides which pokemon from webhooks are counted.
+type IngestConfig struct {
+	// Only pokemon seen in these ways are counted.
+	SeenTypes []string `koanf:"seen_types"`
+	// Ignore pokemon at pokestops, which are likely lured.
+	IgnorePokestopSpawns bool `koanf:"ignore_pokestop_spawns"`
+	// Ignore pokemon Golbat flags as event spawns.
+	IgnoreEventSpawns bool `koanf:"ignore_event_spawns"`
+	// Ignore pokemon without a spawnpoint.
+	RequireSpawnpoint bool `koanf:"require_spawnpoint"`
+}
+
+func (cfg *IngestConfig) Validate() error {
+	if len(cfg.SeenTypes) == 0 {
+		return errors.New("ingest seen_types must not be empty")
+	}
+	for _, seenType := range cfg.SeenTypes {
+		if !containsString(knownSeenTypes, seenType) {
+			return fmt.Errorf("ingest seen_types: unknown seen type '%s': must be one of '%s'", seenType, strings.Join(knownSeenTypes, "', '"))
+		}
+	}
+	return nil
+}
+
+func (cfg *IngestConfig) acceptsSeenType(seenType string) bool {
+	return containsString(cfg.SeenTypes, seenType)
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDefaultIngestConfig returns the rules that only count encounters,
+// which is all that was counted before there were rules.
+func GetDefaultIngestConfig() IngestConfig {
+	return IngestConfig{
+		SeenTypes: []string{SEEN_TYPE_ENCOUNTER, SEEN_TYPE_LURE_ENCOUNTER},
+	}
+}
@@ -40,6 +40,16 @@ func (srv *HTTPServer) setupRoutes() {
 	nestsGroup.GET("/_/stats", srv.handleGetNestStats)
 	nestsGroup.GET("/:nest_id", srv.handleGetNest)
 	nestsGroup.GET("/:nest_id/stats", srv.handleGetNestStats)
+	nestsGroup.GET("/:nest_id/history", srv.handleGetNestHistory)
+
+	apiGroup.GET("/history", srv.handleGetPokemonHistory)
+	apiGroup.POST("/evaluate", srv.handleEvaluate)
+
+	eventsGroup := apiGroup.Group("/events")
+	eventsGroup.GET("", srv.handleGetEvents)
+	eventsGroup.POST("", srv.handleAddEvent)
+	eventsGroup.PUT("/:event_id", srv.handleUpdateEvent)
+	eventsGroup.DELETE("/:event_id", srv.handleDeleteEvent)
 
 	statsGroup := apiGroup.Group("/stats/")
 	statsGroup.PUT("/purge/all", srv.handlePurgeAllStats)
@@ -13,6 +13,7 @@ import (
 	"github.com/UnownHash/Fletchling/filters"
 	"github.com/UnownHash/Fletchling/processor"
 	"github.com/UnownHash/Fletchling/stats_collector"
+	"github.com/UnownHash/Fletchling/webhook_recorder"
 )
 
 func init() {
@@ -27,15 +28,25 @@ type HTTPServer struct {
 	dbRefresher          *filters.DBRefresher
 	reloadFn             func() error
 	filtersConfigFn      func() filters.FiltersConfig
+	webhookRecorder      *webhook_recorder.Recorder
+	webhookQueue         *webhookQueue
+	ingestConfigFn       func() IngestConfig
 }
 
 // Run starts and runs the HTTP server until 'ctx' is cancelled or the server fails to start.
+// Webhooks that were queued have been processed by the time it returns.
 func (srv *HTTPServer) Run(ctx context.Context, address string, shutdownWaitTimeout time.Duration) error {
 	httpServer := &http.Server{
 		Addr:    address,
 		Handler: srv.ginRouter,
 	}
 
+	srv.webhookQueue.Start()
+	// the http server is shut down before this. Handlers still
+	// running if the shutdown timed out have their webhooks dropped.
+	// What was queued is processed before this returns.
+	defer srv.webhookQueue.Stop()
+
 	doneCh := make(chan error, 1)
 
 	go func() {
@@ -70,7 +81,7 @@ func (srv *HTTPServer) Run(ctx context.Context, address string, shutdownWaitTime
 	}
 }
 
-func NewHTTPServer(logger *logrus.Logger, nestProcessorManager *processor.NestProcessorManager, statsCollector stats_collector.StatsCollector, dbRefresher *filters.DBRefresher, reloadFn func() error, filtersConfigFn func() filters.FiltersConfig) (*HTTPServer, error) {
+func NewHTTPServer(logger *logrus.Logger, config Config, ingestConfigFn func() IngestConfig, nestProcessorManager *processor.NestProcessorManager, statsCollector stats_collector.StatsCollector, dbRefresher *filters.DBRefresher, reloadFn func() error, filtersConfigFn func() filters.FiltersConfig, webhookRecorder *webhook_recorder.Recorder) (*HTTPServer, error) {
 	// Create the web server.
 	r := gin.New()
 	r.Use(gin.RecoveryWithWriter(logger.Writer()))
@@ -84,7 +95,10 @@ func NewHTTPServer(logger *logrus.Logger, nestProcessorManager *processor.NestPr
 		reloadFn:             reloadFn,
 		dbRefresher:          dbRefresher,
 		filtersConfigFn:      filtersConfigFn,
+		webhookRecorder:      webhookRecorder,
+		ingestConfigFn:       ingestConfigFn,
 	}
+	srv.webhookQueue = newWebhookQueue(statsCollector, config, srv.processMessages)
 
 	srv.setupRoutes()
 	return srv, nil
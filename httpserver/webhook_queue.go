@@ -0,0 +1,113 @@
+package httpserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/UnownHash/Fletchling/stats_collector"
+)
+
+type webhookJob struct {
+	msgs       []WebhookMessage
+	receivedAt time.Time
+}
+
+// webhookQueue sits between the webhook handler and the processor so
+// that a slow processor can't pile up goroutines without bound.
+type webhookQueue struct {
+	statsCollector stats_collector.StatsCollector
+	fullPolicy     string
+	numWorkers     int
+	processFn      func([]WebhookMessage)
+
+	ch chan webhookJob
+	wg sync.WaitGroup
+
+	// closedMutex is read locked while enqueueing, so that Stop() can't
+	// close 'ch' while something is being sent on it. stopCh wakes up
+	// anything blocked on a full queue.
+	closedMutex sync.RWMutex
+	closed      bool
+	stopCh      chan struct{}
+}
+
+// Enqueue queues the webhook for the workers. Returns false if it was
+// thrown away due to the queue being full (or the request going away
+// while waiting, or the queue being stopped).
+func (queue *webhookQueue) Enqueue(ctx context.Context, job webhookJob) bool {
+	queue.closedMutex.RLock()
+	defer queue.closedMutex.RUnlock()
+
+	var queued bool
+
+	if queue.closed {
+		queue.statsCollector.AddWebhooksDropped(1)
+		return false
+	}
+
+	select {
+	case queue.ch <- job:
+		queued = true
+	default:
+		if queue.fullPolicy == QUEUE_FULL_POLICY_BLOCK {
+			select {
+			case queue.ch <- job:
+				queued = true
+			case <-ctx.Done():
+			case <-queue.stopCh:
+			}
+		}
+	}
+
+	queue.statsCollector.SetWebhookQueueDepth(len(queue.ch))
+
+	if !queued {
+		queue.statsCollector.AddWebhooksDropped(1)
+	}
+
+	return queued
+}
+
+func (queue *webhookQueue) worker() {
+	defer queue.wg.Done()
+
+	for job := range queue.ch {
+		queue.statsCollector.SetWebhookQueueDepth(len(queue.ch))
+		queue.processFn(job.msgs)
+		queue.statsCollector.ObserveWebhookLatency(time.Since(job.receivedAt))
+	}
+}
+
+// Start starts the workers.
+func (queue *webhookQueue) Start() {
+	queue.wg.Add(queue.numWorkers)
+	for i := 0; i < queue.numWorkers; i++ {
+		go queue.worker()
+	}
+}
+
+// Stop processes what is left in the queue and waits for the workers to
+// exit. Anything enqueued after this is called is dropped. This includes
+// anything blocked waiting for room in the queue.
+func (queue *webhookQueue) Stop() {
+	close(queue.stopCh)
+
+	queue.closedMutex.Lock()
+	queue.closed = true
+	close(queue.ch)
+	queue.closedMutex.Unlock()
+
+	queue.wg.Wait()
+}
+
+func newWebhookQueue(statsCollector stats_collector.StatsCollector, cfg Config, processFn func([]WebhookMessage)) *webhookQueue {
+	return &webhookQueue{
+		statsCollector: statsCollector,
+		fullPolicy:     cfg.WebhookQueueFullPolicy,
+		numWorkers:     cfg.WebhookWorkers,
+		processFn:      processFn,
+		ch:             make(chan webhookJob, cfg.WebhookQueueSize),
+		stopCh:         make(chan struct{}),
+	}
+}
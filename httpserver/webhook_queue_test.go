@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UnownHash/Fletchling/stats_collector"
+)
+
+func TestWebhookQueueStopWithBlockedEnqueue(t *testing.T) {
+	startedCh := make(chan struct{}, 2)
+	releaseCh := make(chan struct{})
+	var numProcessed atomic.Int32
+
+	cfg := Config{
+		WebhookWorkers:         1,
+		WebhookQueueSize:       1,
+		WebhookQueueFullPolicy: QUEUE_FULL_POLICY_BLOCK,
+	}
+	queue := newWebhookQueue(stats_collector.NewNoopStatsCollector(), cfg, func([]WebhookMessage) {
+		startedCh <- struct{}{}
+		<-releaseCh
+		numProcessed.Add(1)
+	})
+	queue.Start()
+
+	ctx := context.Background()
+
+	// one being processed and one queued.
+	if !queue.Enqueue(ctx, webhookJob{}) {
+		t.Fatal("first webhook was dropped")
+	}
+	<-startedCh
+	if !queue.Enqueue(ctx, webhookJob{}) {
+		t.Fatal("second webhook was dropped")
+	}
+
+	// this one waits for room.
+	blockedCh := make(chan bool)
+	go func() {
+		blockedCh <- queue.Enqueue(ctx, webhookJob{})
+	}()
+
+	stoppedCh := make(chan struct{})
+	go func() {
+		queue.Stop()
+		close(stoppedCh)
+	}()
+
+	select {
+	case queued := <-blockedCh:
+		if queued {
+			t.Error("blocked webhook was queued after stopping")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked webhook was not dropped when stopping")
+	}
+
+	if queue.Enqueue(ctx, webhookJob{}) {
+		t.Error("webhook was queued after stopping")
+	}
+
+	// what was queued is still processed.
+	close(releaseCh)
+
+	select {
+	case <-stoppedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping did not finish")
+	}
+
+	if n := numProcessed.Load(); n != 2 {
+		t.Errorf("processed %d webhook(s), want 2", n)
+	}
+}
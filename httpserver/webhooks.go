@@ -2,6 +2,8 @@ package httpserver
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -90,47 +92,111 @@ func (wh *PokemonWebhook) EncounterIdAsInt() (uint64, error) {
 	return strconv.ParseUint(wh.EncounterId, 0, 64)
 }
 
+// seenType returns how the pokemon was seen. Older Golbats don't send
+// seen_type, so guess from whether it has IVs.
+func (wh *PokemonWebhook) seenType() string {
+	if seenType := wh.SeenType.ValueOrZero(); seenType != "" {
+		return seenType
+	}
+	if wh.IndividualAttack.Valid {
+		return SEEN_TYPE_ENCOUNTER
+	}
+	return SEEN_TYPE_WILD
+}
+
+func (wh *PokemonWebhook) atPokestop() bool {
+	return wh.PokestopId != "" && wh.PokestopId != "None"
+}
+
+// ToPokemon converts the webhook into a pokemon for the processor. Returns
+// nil with no error if the webhook should be silently ignored due to the
+// ingest rules. The IGNORED_* reason is returned in that case.
+func (msg *WebhookMessage) ToPokemon(ingestConfig *IngestConfig) (*models.Pokemon, string, error) {
+	pokemon := msg.Pokemon
+	if pokemon == nil {
+		return nil, "", fmt.Errorf("ignoring webhook for type '%s': please only send me pokemon!", msg.Type)
+	}
+
+	if pokemon.PokemonId <= 0 {
+		return nil, "", fmt.Errorf("ignoring pokemon webhook with bad pokemon id (%#v)", *pokemon)
+	}
+
+	spawnpointId, err := pokemon.SpawnpointIdAsInt()
+	if err != nil {
+		if pokemon.SpawnpointId != "None" && pokemon.SpawnpointId != "" {
+			return nil, "", fmt.Errorf("ignoring pokemon webhook with no or bad spawnpoint id: %s (%#v)", err, *pokemon)
+		}
+		// lured pokemon, likely. Will match by area.
+		spawnpointId = 0
+	}
+
+	if !ingestConfig.acceptsSeenType(pokemon.seenType()) {
+		return nil, IGNORED_SEEN_TYPE, nil
+	}
+
+	if ingestConfig.IgnorePokestopSpawns && pokemon.atPokestop() {
+		return nil, IGNORED_POKESTOP, nil
+	}
+
+	if ingestConfig.IgnoreEventSpawns && pokemon.IsEvent != 0 {
+		return nil, IGNORED_EVENT, nil
+	}
+
+	if ingestConfig.RequireSpawnpoint && spawnpointId == 0 {
+		return nil, IGNORED_NO_SPAWNPOINT, nil
+	}
+
+	// 0 if missing or bad, which disables duplicate checking.
+	encounterId, _ := pokemon.EncounterIdAsInt()
+
+	var disappearTime time.Time
+	if pokemon.DisappearTime > 0 {
+		disappearTime = time.Unix(pokemon.DisappearTime, 0)
+	}
+
+	pokemonId := pokemon.PokemonId
+	formId := int(pokemon.Form.ValueOrZero())
+
+	// What spawned here is what the Ditto is disguised as. The form is
+	// Ditto's, so the disguise's form is unknown. It is always counted
+	// as form 0, which the form rules then apply to like to any other
+	// pokemon, so that the same webhooks always give the same counts.
+	if displayId := int(pokemon.DisplayPokemonId.ValueOrZero()); pokemonId == models.DITTO_POKEMON_ID && displayId > 0 {
+		pokemonId = displayId
+		formId = 0
+	}
+
+	return &models.Pokemon{
+		EncounterId:   encounterId,
+		PokemonId:     pokemonId,
+		FormId:        formId,
+		SpawnpointId:  spawnpointId,
+		Lat:           pokemon.Latitude,
+		Lon:           pokemon.Longitude,
+		DisappearTime: disappearTime,
+	}, "", nil
+}
+
 func (srv *HTTPServer) processMessages(msgs []WebhookMessage) {
 	var numProcessed uint64
 
 	now := time.Now()
 
-	for _, msg := range msgs {
-		pokemon := msg.Pokemon
-		if pokemon == nil {
-			srv.logger.Warnf("ignoring webhook for type '%s': please only send me pokemon!", msg.Type)
-			continue
-		}
+	// the ingest rules can change on reload.
+	ingestConfig := srv.ingestConfigFn()
 
-		if pokemon.PokemonId <= 0 {
-			srv.logger.Warnf("ignoring pokemon webhook with bad pokemon id (%#v)", *pokemon)
-			continue
-		}
-
-		spawnpointId, err := pokemon.SpawnpointIdAsInt()
+	for _, msg := range msgs {
+		npPokemon, ignoredReason, err := msg.ToPokemon(&ingestConfig)
 		if err != nil {
-			if pokemon.SpawnpointId != "None" && pokemon.SpawnpointId != "" {
-				srv.logger.Warnf("ignoring pokemon webhook with no or bad spawnpoint id: %s (%#v)", err, *pokemon)
-				continue
-			}
-			// lured pokemon, likely. Will match by area.
-			spawnpointId = 0
-		}
-
-		if !pokemon.IndividualAttack.Valid {
-			// only look at encounters.
+			srv.logger.Warn(err)
 			continue
 		}
-
-		npPokemon := models.Pokemon{
-			PokemonId:    pokemon.PokemonId,
-			FormId:       int(pokemon.Form.ValueOrZero()),
-			SpawnpointId: spawnpointId,
-			Lat:          pokemon.Latitude,
-			Lon:          pokemon.Longitude,
+		if npPokemon == nil {
+			srv.statsCollector.AddPokemonIgnored(ignoredReason, 1)
+			continue
 		}
 
-		srv.nestProcessorManager.ProcessPokemon(&npPokemon)
+		srv.nestProcessorManager.ProcessPokemon(npPokemon)
 		numProcessed++
 	}
 
@@ -141,8 +207,20 @@ func (srv *HTTPServer) processMessages(msgs []WebhookMessage) {
 func (srv *HTTPServer) handleWebhook(c *gin.Context) {
 	var msgs []WebhookMessage
 
-	decoder := json.NewDecoder(c.Request.Body)
-	err := decoder.Decode(&msgs)
+	receivedAt := time.Now()
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		srv.logger.Warnf("failed to read webhook: %s", err)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	if srv.webhookRecorder != nil {
+		srv.webhookRecorder.Record(receivedAt, body)
+	}
+
+	err = json.Unmarshal(body, &msgs)
 	if err != nil {
 		srv.logger.Warnf("received unprocessable webhook: %s", err)
 		// Bad format? I guess treat as success so caller doesn't
@@ -151,7 +229,18 @@ func (srv *HTTPServer) handleWebhook(c *gin.Context) {
 		return
 	}
 
-	go srv.processMessages(msgs)
+	job := webhookJob{
+		msgs:       msgs,
+		receivedAt: receivedAt,
+	}
+
+	if !srv.webhookQueue.Enqueue(c.Request.Context(), job) {
+		srv.logger.Debugf("webhook queue is full: dropped webhook with %d message(s)", len(msgs))
+		if srv.webhookQueue.fullPolicy == QUEUE_FULL_POLICY_REJECT {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+	}
 
 	c.Status(http.StatusOK)
 }
@@ -0,0 +1,166 @@
+package httpserver
+
+import (
+	"testing"
+
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestWebhookMessageToPokemonDitto(t *testing.T) {
+	msg := WebhookMessage{
+		Type: "pokemon",
+		Pokemon: &PokemonWebhook{
+			PokemonId:        models.DITTO_POKEMON_ID,
+			Form:             null.IntFrom(2678),
+			DisplayPokemonId: null.IntFrom(19),
+			SpawnpointId:     "1a2b",
+		},
+	}
+
+	pokemon, _, err := msg.ToPokemon(&IngestConfig{SeenTypes: []string{SEEN_TYPE_WILD}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pokemon.PokemonId != 19 || pokemon.FormId != 0 {
+		t.Errorf("got %+v, want rattata with form 0", pokemon)
+	}
+}
+
+func TestWebhookMessageToPokemonIngestRules(t *testing.T) {
+	// the defaults, plus wild sightings.
+	baseConfig := IngestConfig{SeenTypes: []string{SEEN_TYPE_ENCOUNTER, SEEN_TYPE_LURE_ENCOUNTER, SEEN_TYPE_WILD}}
+
+	tests := []struct {
+		name    string
+		config  func(*IngestConfig)
+		webhook func(*PokemonWebhook)
+		// "" if counted.
+		wantIgnored string
+	}{
+		{
+			name: "encounter",
+		},
+		{
+			name:    "wild",
+			webhook: func(wh *PokemonWebhook) { wh.SeenType = null.StringFrom(SEEN_TYPE_WILD) },
+		},
+		{
+			name:        "nearby cell",
+			webhook:     func(wh *PokemonWebhook) { wh.SeenType = null.StringFrom(SEEN_TYPE_NEARBY_CELL) },
+			wantIgnored: IGNORED_SEEN_TYPE,
+		},
+		{
+			name: "no seen type with IVs is an encounter",
+			config: func(cfg *IngestConfig) {
+				cfg.SeenTypes = []string{SEEN_TYPE_ENCOUNTER}
+			},
+			webhook: func(wh *PokemonWebhook) { wh.SeenType = null.String{} },
+		},
+		{
+			name: "no seen type without IVs is wild",
+			config: func(cfg *IngestConfig) {
+				cfg.SeenTypes = []string{SEEN_TYPE_ENCOUNTER}
+			},
+			webhook: func(wh *PokemonWebhook) {
+				wh.SeenType = null.String{}
+				wh.IndividualAttack = null.Int{}
+			},
+			wantIgnored: IGNORED_SEEN_TYPE,
+		},
+		{
+			name:    "pokestop allowed",
+			webhook: func(wh *PokemonWebhook) { wh.PokestopId = "stop1" },
+		},
+		{
+			name:        "pokestop ignored",
+			config:      func(cfg *IngestConfig) { cfg.IgnorePokestopSpawns = true },
+			webhook:     func(wh *PokemonWebhook) { wh.PokestopId = "stop1" },
+			wantIgnored: IGNORED_POKESTOP,
+		},
+		{
+			name:    "pokestop 'None' is no pokestop",
+			config:  func(cfg *IngestConfig) { cfg.IgnorePokestopSpawns = true },
+			webhook: func(wh *PokemonWebhook) { wh.PokestopId = "None" },
+		},
+		{
+			name:    "event allowed",
+			webhook: func(wh *PokemonWebhook) { wh.IsEvent = 1 },
+		},
+		{
+			name:        "event ignored",
+			config:      func(cfg *IngestConfig) { cfg.IgnoreEventSpawns = true },
+			webhook:     func(wh *PokemonWebhook) { wh.IsEvent = 1 },
+			wantIgnored: IGNORED_EVENT,
+		},
+		{
+			name:    "no spawnpoint allowed",
+			webhook: func(wh *PokemonWebhook) { wh.SpawnpointId = "None" },
+		},
+		{
+			name:        "no spawnpoint ignored",
+			config:      func(cfg *IngestConfig) { cfg.RequireSpawnpoint = true },
+			webhook:     func(wh *PokemonWebhook) { wh.SpawnpointId = "" },
+			wantIgnored: IGNORED_NO_SPAWNPOINT,
+		},
+		{
+			// the seen type is checked first.
+			name: "seen type before the other rules",
+			config: func(cfg *IngestConfig) {
+				cfg.SeenTypes = []string{SEEN_TYPE_ENCOUNTER}
+				cfg.IgnorePokestopSpawns = true
+				cfg.RequireSpawnpoint = true
+			},
+			webhook: func(wh *PokemonWebhook) {
+				wh.SeenType = null.StringFrom(SEEN_TYPE_LURE_WILD)
+				wh.PokestopId = "stop1"
+				wh.SpawnpointId = "None"
+			},
+			wantIgnored: IGNORED_SEEN_TYPE,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := baseConfig
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			webhook := &PokemonWebhook{
+				PokemonId:        25,
+				SpawnpointId:     "1a2b",
+				EncounterId:      "12345",
+				Latitude:         10,
+				Longitude:        20,
+				IndividualAttack: null.IntFrom(15),
+				SeenType:         null.StringFrom(SEEN_TYPE_ENCOUNTER),
+			}
+			if tt.webhook != nil {
+				tt.webhook(webhook)
+			}
+			msg := WebhookMessage{Type: "pokemon", Pokemon: webhook}
+
+			pokemon, ignoredReason, err := msg.ToPokemon(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ignoredReason != tt.wantIgnored {
+				t.Fatalf("got ignored reason '%s', want '%s'", ignoredReason, tt.wantIgnored)
+			}
+			if tt.wantIgnored != "" {
+				if pokemon != nil {
+					t.Errorf("got %+v for an ignored pokemon", pokemon)
+				}
+				return
+			}
+			if pokemon == nil || pokemon.PokemonId != 25 || pokemon.EncounterId != 12345 || pokemon.Lat != 10 || pokemon.Lon != 20 {
+				t.Errorf("got %+v, want pikachu", pokemon)
+			}
+		})
+	}
+}
@@ -81,6 +81,7 @@ func (importer *DBImporter) ImportFeatures(ctx context.Context, features []*geoj
 				nest.PokemonAvg = existingNest.PokemonAvg
 				nest.PokemonRatio = existingNest.PokemonRatio
 				nest.PokemonCount = existingNest.PokemonCount
+				nest.AdditionalPokemon = existingNest.AdditionalPokemon
 			}
 
 			// prefer new areaName over DB
@@ -0,0 +1,51 @@
+// Package clock is where the processor gets the current time and its
+// timers from, so that time can be simulated. For example, when replaying
+// recorded webhooks.
+package clock
+
+import (
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	// NewTimer works like time.NewTimer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer works like a *time.Timer, with C() instead of the C field.
+// Use the same Stop and drain pattern as with a *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (timer *realTimer) C() <-chan time.Time {
+	return timer.Timer.C
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+// OrReal returns 'clk' or, if it is nil, the wall clock.
+func OrReal(clk Clock) Clock {
+	if clk == nil {
+		return Real
+	}
+	return clk
+}
@@ -0,0 +1,114 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Simulated is a Clock that only moves when it is told to. Timers
+// fire when the clock is moved to or past their deadline.
+type Simulated struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers map[*simulatedTimer]struct{}
+}
+
+func (clk *Simulated) Now() time.Time {
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	return clk.now
+}
+
+// Set moves the clock to 't', firing any timers that are due. The
+// clock may be moved backwards, but timers never fire early.
+func (clk *Simulated) Set(t time.Time) {
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	clk.now = t
+	clk.fireTimers()
+}
+
+// Advance moves the clock forward by 'd', firing any timers that are due.
+func (clk *Simulated) Advance(d time.Duration) {
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	clk.now = clk.now.Add(d)
+	clk.fireTimers()
+}
+
+// NumTimers returns the number of timers waiting to fire.
+func (clk *Simulated) NumTimers() int {
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	return len(clk.timers)
+}
+
+// requires clk.mutex be locked.
+func (clk *Simulated) fireTimers() {
+	for timer := range clk.timers {
+		if timer.deadline.After(clk.now) {
+			continue
+		}
+		delete(clk.timers, timer)
+		// like a time.Timer, never block. a value is only left
+		// in the channel if the last one wasn't drained.
+		select {
+		case timer.ch <- clk.now:
+		default:
+		}
+	}
+}
+
+func (clk *Simulated) NewTimer(d time.Duration) Timer {
+	timer := &simulatedTimer{
+		clock: clk,
+		ch:    make(chan time.Time, 1),
+	}
+	timer.Reset(d)
+	return timer
+}
+
+type simulatedTimer struct {
+	clock    *Simulated
+	ch       chan time.Time
+	deadline time.Time
+}
+
+func (timer *simulatedTimer) C() <-chan time.Time {
+	return timer.ch
+}
+
+func (timer *simulatedTimer) Stop() bool {
+	clk := timer.clock
+
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	_, active := clk.timers[timer]
+	delete(clk.timers, timer)
+	return active
+}
+
+func (timer *simulatedTimer) Reset(d time.Duration) bool {
+	clk := timer.clock
+
+	clk.mutex.Lock()
+	defer clk.mutex.Unlock()
+
+	_, active := clk.timers[timer]
+	timer.deadline = clk.now.Add(d)
+	clk.timers[timer] = struct{}{}
+	clk.fireTimers()
+	return active
+}
+
+func NewSimulated(now time.Time) *Simulated {
+	return &Simulated{
+		now:    now,
+		timers: make(map[*simulatedTimer]struct{}),
+	}
+}
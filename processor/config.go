@@ -15,20 +15,61 @@ const (
 	DEFAULT_MIN_TOTAL_POKEMON                = 12
 	DEFAULT_MAX_GLOBAL_SPAWN_PCT             = 15
 	DEFAULT_MIN_NEST_PCT_TO_GLOBAL_PCT_RATIO = float64(8)
+	DEFAULT_MIN_NEST_SPAWNPOINT_PCT          = float64(0)
+	DEFAULT_MIN_NEST_COVERAGE_PCT            = float64(0)
+	DEFAULT_NEST_MATCHING_POLICY             = NEST_MATCHING_POLICY_ALL
+	DEFAULT_NEST_BUFFER_METERS               = float64(0)
+	DEFAULT_SPAWNPOINT_INDEX                 = false
+	DEFAULT_SPAWNPOINT_INDEX_REFRESH_MINUTES = 60
+	DEFAULT_SPAWNPOINT_INDEX_MAX_AGE_DAYS    = 7
+	DEFAULT_SKIP_SPAWNPOINTS_OUTSIDE_NESTS   = false
+	DEFAULT_SKIP_LOW_COVERAGE                = false
 	DEFAULT_SKIP_PERIOD_MIN_GLOBAL_SPAWN_PCT = float64(40)
 	DEFAULT_LOG_LAST_STATS_PERIOD            = false
 	DEFAULT_NO_NESTING_POKEMON_AGE_HOURS     = 12
+	DEFAULT_STATS_SAVE_INTERVAL_MINUTES      = 5
+	DEFAULT_DEDUP_MAX_ENCOUNTERS             = 250000
+	DEFAULT_DEDUP_DEFAULT_TTL_MINUTES        = 60
+	DEFAULT_NESTING_STRATEGY                 = NESTING_STRATEGY_THRESHOLD
+	DEFAULT_MIN_NESTING_CONFIDENCE           = float64(0.5)
+	DEFAULT_MAX_NESTING_CANDIDATES           = 5
+	DEFAULT_MAX_NESTING_POKEMON              = 1
+	DEFAULT_MIGRATION_INTERVAL_DAYS          = 14
+	DEFAULT_MIGRATION_CLEAR_NESTING_POKEMON  = false
+	DEFAULT_AREA_BASELINES                   = false
+	DEFAULT_AREA_BASELINE_MIN_POKEMON        = 5000
+	DEFAULT_DOWNSAMPLE_AFTER_HOURS           = 0
+	DEFAULT_ALIGN_ROTATION                   = false
+	DEFAULT_DOWNSAMPLE_BUCKET_MINUTES        = 60
+	DEFAULT_NEST_CHANGE_MIN_WINS             = 1
+	DEFAULT_NEST_CHANGE_MIN_MARGIN           = float64(0)
 )
 
 type Config struct {
 	// Whether to log the last stats period when processing
 	LogLastStatsPeriod bool `koanf:"log_last_stats_period" json:"log_last_stats_period"`
+	// How to decide which pokemon is nesting: "threshold" or "score".
+	NestingStrategy string `koanf:"nesting_strategy" json:"nesting_strategy"`
+	// "score" strategy only: the winner's minimum share (0..1) of all of the scores.
+	MinNestingConfidence float64 `koanf:"min_nesting_confidence" json:"min_nesting_confidence"`
+	// Keep this many of the top candidates from each nest evaluation for the API and webhooks.
+	MaxNestingCandidates int `koanf:"max_nesting_candidates" json:"max_nesting_candidates"`
+	// How many different pokemon can nest in a nest at once.
+	MaxNestingPokemon int `koanf:"max_nesting_pokemon" json:"max_nesting_pokemon"`
 	// how often to rotate stats
 	RotationIntervalMinutes int `koanf:"rotation_interval_minutes" json:"rotation_interval_minutes"`
+	// Rotate on wall-clock boundaries (:00, :15, ...) instead of every interval from startup.
+	AlignRotation bool `koanf:"align_rotation" json:"align_rotation"`
+	// Timezone for aligned rotation boundaries, like 'America/New_York'. Empty is the local timezone.
+	RotationTimezone string `koanf:"rotation_timezone" json:"rotation_timezone"`
 	// Require this many horus of stats in order to produce the nesting pokemon and update the DB.
 	MinHistoryDurationHours int `koanf:"min_history_duration_hours" json:"min_history_duration_hours"`
 	// Hold stats covering at most this many hours.
 	MaxHistoryDurationHours int `koanf:"max_history_duration_hours" json:"max_history_duration_hours"`
+	// Merge time periods older than this many hours into coarser buckets to save memory. 0 disables.
+	DownsampleAfterHours int `koanf:"downsample_after_hours" json:"downsample_after_hours"`
+	// Size of the buckets that old time periods are merged into.
+	DownsampleBucketMinutes int `koanf:"downsample_bucket_minutes" json:"downsample_bucket_minutes"`
 	// number of a particular pokemon type seen needed to count as nesting.
 	MinNestPokemon int `koanf:"min_nest_pokemon" json:"min_nest_pokemon"`
 	// pct of particular pokemon type seen needed to count as nesting.
@@ -39,24 +80,133 @@ type Config struct {
 	MaxGlobalSpawnPct float64 `koanf:"max_global_spawn_pct" json:"max_global_spawn_pct"`
 	// Mininium required pokemon NestPct/GlobalPct ratio.
 	MinNestPctToGlobalPctRatio float64 `koanf:"min_nest_pct_to_global_pct_ratio" json:"min_nest_pct_to_global_pct_ratio"`
+	// pct of the nest's spawnpoints a pokemon must be seen on to count as nesting. 0 disables.
+	MinNestSpawnpointPct float64 `koanf:"min_nest_spawnpoint_pct" json:"min_nest_spawnpoint_pct"`
+	// pct of the nest's spawnpoints in the DB that must be seen for good coverage. 0 disables.
+	MinNestCoveragePct float64 `koanf:"min_nest_coverage_pct" json:"min_nest_coverage_pct"`
+	// Don't evaluate nests with low coverage, instead of only flagging them.
+	SkipLowCoverage bool `koanf:"skip_low_coverage" json:"skip_low_coverage"`
+	// Which nests a pokemon is counted in when nests overlap.
+	NestMatchingPolicy string `koanf:"nest_matching_policy" json:"nest_matching_policy"`
+	// Grow (or shrink, if negative) each nest by this many meters when matching pokemon.
+	NestBufferMeters float64 `koanf:"nest_buffer_meters" json:"nest_buffer_meters"`
+	// Match pokemon to nests by their spawnpoint, using spawnpoints from the golbat DB.
+	SpawnpointIndex bool `koanf:"spawnpoint_index" json:"spawnpoint_index"`
+	// How often to reload the spawnpoints for the spawnpoint index.
+	SpawnpointIndexRefreshMinutes int `koanf:"spawnpoint_index_refresh_minutes" json:"spawnpoint_index_refresh_minutes"`
+	// Only index spawnpoints seen in this many days.
+	SpawnpointIndexMaxAgeDays int `koanf:"spawnpoint_index_max_age_days" json:"spawnpoint_index_max_age_days"`
+	// Don't count pokemon that are in no nest at all, not even globally, while there is a spawnpoint index.
+	SkipSpawnpointsOutsideNests bool `koanf:"skip_spawnpoints_outside_nests" json:"skip_spawnpoints_outside_nests"`
+	// Compare nests against the spawns in their own area instead of the spawns everywhere.
+	AreaBaselines bool `koanf:"area_baselines" json:"area_baselines"`
+	// An area needs at least this many pokemon seen to be used as a baseline. Else global is used.
+	AreaBaselineMinPokemon int `koanf:"area_baseline_min_pokemon" json:"area_baseline_min_pokemon"`
 	// Throw out a whole time period if a single mon spawns at more than this percent globally.
 	SkipPeriodMinGlobalSpawnPct float64 `koanf:"skip_period_min_global_spawn_pct" json:"skip_period_min_global_spawn_pct"`
+	// A new pokemon must win this many evaluations in a row to replace the nesting pokemon.
+	NestChangeMinWins int `koanf:"nest_change_min_wins" json:"nest_change_min_wins"`
+	// ..unless it beats the nesting pokemon by this many percentage points of the nest. 0 disables.
+	NestChangeMinMargin float64 `koanf:"nest_change_min_margin" json:"nest_change_min_margin"`
 	// How many hours without seeing a nesting pokemon before we unset it in DB.
 	NoNestingPokemonAgeHours int `koanf:"no_nesting_pokemon_age_hours" json:"no_nesting_pokemon_age_hours"`
+	// File holding the event calendar. Events can also be managed via the API.
+	EventsFilename string `koanf:"events_filename" json:"events_filename"`
+	// If set, stats are saved to this file periodically and on shutdown and restored on startup.
+	StatsFilename string `koanf:"stats_filename" json:"stats_filename"`
+	// How often to save stats to StatsFilename.
+	StatsSaveIntervalMinutes int `koanf:"stats_save_interval_minutes" json:"stats_save_interval_minutes"`
+	// A time (RFC3339) of any past nest migration. Empty disables migration handling.
+	MigrationAnchor string `koanf:"migration_anchor" json:"migration_anchor"`
+	// Nest migrations happen this many days apart, starting from MigrationAnchor.
+	MigrationIntervalDays int `koanf:"migration_interval_days" json:"migration_interval_days"`
+	// Whether to clear the nesting pokemon in the DB at migration time.
+	MigrationClearNestingPokemon bool `koanf:"migration_clear_nesting_pokemon" json:"migration_clear_nesting_pokemon"`
+	// Remember at most this many encounter ids to ignore re-sent encounters. 0 disables.
+	DedupMaxEncounters int `koanf:"dedup_max_encounters" json:"dedup_max_encounters"`
+	// How long to remember an encounter id when its despawn time is unknown.
+	DedupDefaultTTLMinutes int `koanf:"dedup_default_ttl_minutes" json:"dedup_default_ttl_minutes"`
+	// Only these pokemon ids can be nesting. Empty allows any.
+	NestingSpecies []int `koanf:"nesting_species" json:"nesting_species"`
+	// These pokemon ids can never be nesting.
+	NonNestingSpecies []int `koanf:"non_nesting_species" json:"non_nesting_species"`
+	// JSON file with more nesting_species and non_nesting_species.
+	NestingSpeciesFilename string `koanf:"nesting_species_filename" json:"nesting_species_filename"`
+	// Rules for counting forms that don't matter for nesting as another form.
+	FormRules []FormRule `koanf:"form_rules" json:"form_rules"`
+	// Threshold overrides for certain areas or nests.
+	Overrides []ConfigOverride `koanf:"overrides" json:"overrides"`
 }
 
 func (cfg *Config) writeConfiguration(buf *bytes.Buffer) {
 	buf.WriteString(fmt.Sprintf("log_last_stats_period: %t, ", cfg.LogLastStatsPeriod))
+	buf.WriteString(fmt.Sprintf("nesting_strategy: %s, ", cfg.NestingStrategy))
+	buf.WriteString(fmt.Sprintf("min_nesting_confidence: %0.3f, ", cfg.MinNestingConfidence))
+	buf.WriteString(fmt.Sprintf("max_nesting_candidates: %d, ", cfg.MaxNestingCandidates))
+	buf.WriteString(fmt.Sprintf("max_nesting_pokemon: %d, ", cfg.MaxNestingPokemon))
 	buf.WriteString(fmt.Sprintf("rotation_interval_minutes: %d(%s), ", cfg.RotationIntervalMinutes, cfg.RotationInterval()))
+	buf.WriteString(fmt.Sprintf("align_rotation: %t, ", cfg.AlignRotation))
+	buf.WriteString(fmt.Sprintf("rotation_timezone: '%s', ", cfg.RotationTimezone))
 	buf.WriteString(fmt.Sprintf("min_history_duration_hours: %d(%s), ", cfg.MinHistoryDurationHours, cfg.MinHistoryDuration()))
 	buf.WriteString(fmt.Sprintf("max_history_duration_hours: %d(%s), ", cfg.MaxHistoryDurationHours, cfg.MaxHistoryDuration()))
+	buf.WriteString(fmt.Sprintf("downsample_after_hours: %d(%s), ", cfg.DownsampleAfterHours, cfg.DownsampleAfter()))
+	buf.WriteString(fmt.Sprintf("downsample_bucket_minutes: %d(%s), ", cfg.DownsampleBucketMinutes, cfg.DownsampleBucketDuration()))
 	buf.WriteString(fmt.Sprintf("min_nest_pokemon: %d, ", cfg.MinNestPokemon))
 	buf.WriteString(fmt.Sprintf("min_nest_pokemon_pct: %0.3f, ", cfg.MinNestPokemonPct))
 	buf.WriteString(fmt.Sprintf("min_total_pokemon: %d, ", cfg.MinTotalPokemon))
 	buf.WriteString(fmt.Sprintf("max_global_spawn_pct: %0.3f, ", cfg.MaxGlobalSpawnPct))
 	buf.WriteString(fmt.Sprintf("min_nest_pct_to_global_pct_ratio: %0.3f, ", cfg.MinNestPctToGlobalPctRatio))
+	buf.WriteString(fmt.Sprintf("min_nest_spawnpoint_pct: %0.3f, ", cfg.MinNestSpawnpointPct))
+	buf.WriteString(fmt.Sprintf("min_nest_coverage_pct: %0.3f, ", cfg.MinNestCoveragePct))
+	buf.WriteString(fmt.Sprintf("skip_low_coverage: %t, ", cfg.SkipLowCoverage))
+	buf.WriteString(fmt.Sprintf("nest_matching_policy: '%s', ", cfg.NestMatchingPolicy))
+	buf.WriteString(fmt.Sprintf("nest_buffer_meters: %0.1f, ", cfg.NestBufferMeters))
+	buf.WriteString(fmt.Sprintf("spawnpoint_index: %t, ", cfg.SpawnpointIndex))
+	buf.WriteString(fmt.Sprintf("spawnpoint_index_refresh_minutes: %d(%s), ", cfg.SpawnpointIndexRefreshMinutes, cfg.SpawnpointIndexRefreshInterval()))
+	buf.WriteString(fmt.Sprintf("spawnpoint_index_max_age_days: %d, ", cfg.SpawnpointIndexMaxAgeDays))
+	buf.WriteString(fmt.Sprintf("skip_spawnpoints_outside_nests: %t, ", cfg.SkipSpawnpointsOutsideNests))
+	buf.WriteString(fmt.Sprintf("area_baselines: %t, ", cfg.AreaBaselines))
+	buf.WriteString(fmt.Sprintf("area_baseline_min_pokemon: %d, ", cfg.AreaBaselineMinPokemon))
 	buf.WriteString(fmt.Sprintf("skip_period_min_global_spawn_pct: %0.3f, ", cfg.SkipPeriodMinGlobalSpawnPct))
-	buf.WriteString(fmt.Sprintf("no_nesting_pokemon_age_hours: %0.3f", cfg.SkipPeriodMinGlobalSpawnPct))
+	buf.WriteString(fmt.Sprintf("nest_change_min_wins: %d, ", cfg.NestChangeMinWins))
+	buf.WriteString(fmt.Sprintf("nest_change_min_margin: %0.3f, ", cfg.NestChangeMinMargin))
+	buf.WriteString(fmt.Sprintf("no_nesting_pokemon_age_hours: %d, ", cfg.NoNestingPokemonAgeHours))
+	buf.WriteString(fmt.Sprintf("events_filename: '%s', ", cfg.EventsFilename))
+	buf.WriteString(fmt.Sprintf("stats_filename: '%s', ", cfg.StatsFilename))
+	buf.WriteString(fmt.Sprintf("stats_save_interval_minutes: %d(%s), ", cfg.StatsSaveIntervalMinutes, cfg.StatsSaveInterval()))
+	buf.WriteString(fmt.Sprintf("migration_anchor: '%s', ", cfg.MigrationAnchor))
+	buf.WriteString(fmt.Sprintf("migration_interval_days: %d, ", cfg.MigrationIntervalDays))
+	buf.WriteString(fmt.Sprintf("migration_clear_nesting_pokemon: %t, ", cfg.MigrationClearNestingPokemon))
+	buf.WriteString(fmt.Sprintf("dedup_max_encounters: %d, ", cfg.DedupMaxEncounters))
+	buf.WriteString(fmt.Sprintf("dedup_default_ttl_minutes: %d(%s), ", cfg.DedupDefaultTTLMinutes, cfg.DedupDefaultTTL()))
+	buf.WriteString(fmt.Sprintf("nesting_species: %v, ", cfg.NestingSpecies))
+	buf.WriteString(fmt.Sprintf("non_nesting_species: %v, ", cfg.NonNestingSpecies))
+	buf.WriteString(fmt.Sprintf("nesting_species_filename: '%s', ", cfg.NestingSpeciesFilename))
+	buf.WriteString("form_rules: [")
+	for idx := range cfg.FormRules {
+		if idx > 0 {
+			buf.WriteString(", ")
+		}
+		cfg.FormRules[idx].writeConfiguration(buf)
+	}
+	buf.WriteString("], ")
+	buf.WriteString("overrides: [")
+	for idx := range cfg.Overrides {
+		if idx > 0 {
+			buf.WriteString(", ")
+		}
+		cfg.Overrides[idx].writeConfiguration(buf)
+	}
+	buf.WriteString("]")
+}
+
+// areaBaselineMinPokemon returns the minimum number of pokemon an area
+// needs to be used as a baseline or 0 if area baselines are disabled.
+func (cfg *Config) areaBaselineMinPokemon() uint64 {
+	if !cfg.AreaBaselines {
+		return 0
+	}
+	return uint64(cfg.AreaBaselineMinPokemon)
 }
 
 func (cfg *Config) MinHistoryDuration() time.Duration {
@@ -67,6 +217,14 @@ func (cfg *Config) MaxHistoryDuration() time.Duration {
 	return time.Hour * time.Duration(cfg.MaxHistoryDurationHours)
 }
 
+func (cfg *Config) DownsampleAfter() time.Duration {
+	return time.Hour * time.Duration(cfg.DownsampleAfterHours)
+}
+
+func (cfg *Config) DownsampleBucketDuration() time.Duration {
+	return time.Minute * time.Duration(cfg.DownsampleBucketMinutes)
+}
+
 func (cfg *Config) RotationInterval() time.Duration {
 	return time.Minute * time.Duration(cfg.RotationIntervalMinutes)
 }
@@ -75,27 +233,228 @@ func (cfg *Config) NoNestingPokemonAge() time.Duration {
 	return time.Hour * time.Duration(cfg.NoNestingPokemonAgeHours)
 }
 
+func (cfg *Config) StatsSaveInterval() time.Duration {
+	return time.Minute * time.Duration(cfg.StatsSaveIntervalMinutes)
+}
+
+func (cfg *Config) SpawnpointIndexRefreshInterval() time.Duration {
+	return time.Minute * time.Duration(cfg.SpawnpointIndexRefreshMinutes)
+}
+
+func (cfg *Config) DedupDefaultTTL() time.Duration {
+	return time.Minute * time.Duration(cfg.DedupDefaultTTLMinutes)
+}
+
+func (cfg *Config) MigrationInterval() time.Duration {
+	return 24 * time.Hour * time.Duration(cfg.MigrationIntervalDays)
+}
+
+// rotationSchedule describes when rotations happen, for noticing
+// changes on reload.
+func (cfg *Config) rotationSchedule() string {
+	if !cfg.AlignRotation {
+		return cfg.RotationInterval().String()
+	}
+	return fmt.Sprintf("%s aligned in '%s'", cfg.RotationInterval(), cfg.rotationLocation())
+}
+
+// rotationLocation returns the timezone for aligned rotations.
+func (cfg *Config) rotationLocation() *time.Location {
+	if cfg.RotationTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(cfg.RotationTimezone)
+	if err != nil {
+		// Validate() catches this.
+		return time.Local
+	}
+	return loc
+}
+
+// NextRotationAfter returns when stats should be rotated next, given
+// that they were last rotated at 't'. Without alignment, that is one
+// rotation interval after 't'. With alignment, it is the first
+// wall-clock boundary after 't', counting intervals from midnight. On
+// days with DST changes, the time periods around the change are shorter
+// or longer, but the boundaries stay on the wall clock.
+func (cfg *Config) NextRotationAfter(t time.Time) time.Time {
+	if !cfg.AlignRotation {
+		return t.Add(cfg.RotationInterval())
+	}
+
+	interval := cfg.RotationInterval()
+	lt := t.In(cfg.rotationLocation())
+
+	// how far we are into the day on the wall clock.
+	sinceMidnight := time.Duration(lt.Hour())*time.Hour +
+		time.Duration(lt.Minute())*time.Minute +
+		time.Duration(lt.Second())*time.Second +
+		time.Duration(lt.Nanosecond())
+
+	// a boundary that exists twice, because clocks were set back, may
+	// come out at or before 't', and then the next one is used.
+	for num := sinceMidnight/interval + 1; ; num++ {
+		if next := wallClockTime(lt, num*interval); next.After(t) {
+			return next
+		}
+	}
+}
+
+// wallClockTime returns the time 'sinceMidnight' into the day of 't' on
+// the wall clock of 't's location. A time that doesn't exist, because
+// clocks were set forward, is moved to after the change, the same way
+// the clocks were.
+func wallClockTime(t time.Time, sinceMidnight time.Duration) time.Time {
+	year, month, day := t.Date()
+	hour := int(sinceMidnight / time.Hour)
+	minute := int(sinceMidnight % time.Hour / time.Minute)
+	sec := int(sinceMidnight % time.Minute / time.Second)
+	nsec := int(sinceMidnight % time.Second)
+
+	wallTime := time.Date(year, month, day, hour, minute, sec, nsec, t.Location())
+
+	// time.Date() may move a time that doesn't exist back instead.
+	want := time.Date(year, month, day, hour, minute, sec, nsec, time.UTC)
+	got := time.Date(wallTime.Year(), wallTime.Month(), wallTime.Day(),
+		wallTime.Hour(), wallTime.Minute(), wallTime.Second(), wallTime.Nanosecond(), time.UTC)
+	if diff := want.Sub(got); diff > 0 {
+		wallTime = wallTime.Add(diff)
+	}
+	return wallTime
+}
+
+// NextMigrationAfter returns the first nest migration time after 't' or
+// the zero time if no migration schedule is configured.
+func (cfg *Config) NextMigrationAfter(t time.Time) time.Time {
+	if cfg.MigrationAnchor == "" {
+		return time.Time{}
+	}
+
+	anchor, err := time.Parse(time.RFC3339, cfg.MigrationAnchor)
+	if err != nil {
+		// Validate() catches this.
+		return time.Time{}
+	}
+
+	interval := cfg.MigrationInterval()
+	if interval <= 0 {
+		return time.Time{}
+	}
+
+	if anchor.After(t) {
+		// step backwards to the earliest migration after t. The -1
+		// keeps us after t when t is exactly on a migration.
+		num := (anchor.Sub(t) - 1) / interval
+		return anchor.Add(-num * interval)
+	}
+
+	num := t.Sub(anchor)/interval + 1
+	return anchor.Add(num * interval)
+}
+
 func GetDefaultConfig() Config {
 	return Config{
-		LogLastStatsPeriod:          DEFAULT_LOG_LAST_STATS_PERIOD,
-		RotationIntervalMinutes:     DEFAULT_ROTATION_INTERVAL_MINUTES,
-		MinHistoryDurationHours:     DEFAULT_MIN_HISTORY_DURATION_HOURS,
-		MaxHistoryDurationHours:     DEFAULT_MAX_HISTORY_DURATION_HOURS,
-		MinNestPokemon:              DEFAULT_MIN_NEST_POKEMON,
-		MinNestPokemonPct:           DEFAULT_MIN_NEST_POKEMON_PCT,
-		MinTotalPokemon:             DEFAULT_MIN_TOTAL_POKEMON,
-		MaxGlobalSpawnPct:           DEFAULT_MAX_GLOBAL_SPAWN_PCT,
-		MinNestPctToGlobalPctRatio:  DEFAULT_MIN_NEST_PCT_TO_GLOBAL_PCT_RATIO,
-		SkipPeriodMinGlobalSpawnPct: DEFAULT_SKIP_PERIOD_MIN_GLOBAL_SPAWN_PCT,
-		NoNestingPokemonAgeHours:    DEFAULT_NO_NESTING_POKEMON_AGE_HOURS,
+		LogLastStatsPeriod:           DEFAULT_LOG_LAST_STATS_PERIOD,
+		NestingStrategy:              DEFAULT_NESTING_STRATEGY,
+		MinNestingConfidence:         DEFAULT_MIN_NESTING_CONFIDENCE,
+		MaxNestingCandidates:         DEFAULT_MAX_NESTING_CANDIDATES,
+		MaxNestingPokemon:            DEFAULT_MAX_NESTING_POKEMON,
+		RotationIntervalMinutes:      DEFAULT_ROTATION_INTERVAL_MINUTES,
+		AlignRotation:                DEFAULT_ALIGN_ROTATION,
+		MinHistoryDurationHours:      DEFAULT_MIN_HISTORY_DURATION_HOURS,
+		MaxHistoryDurationHours:      DEFAULT_MAX_HISTORY_DURATION_HOURS,
+		DownsampleAfterHours:         DEFAULT_DOWNSAMPLE_AFTER_HOURS,
+		DownsampleBucketMinutes:      DEFAULT_DOWNSAMPLE_BUCKET_MINUTES,
+		MinNestPokemon:               DEFAULT_MIN_NEST_POKEMON,
+		MinNestPokemonPct:            DEFAULT_MIN_NEST_POKEMON_PCT,
+		MinNestSpawnpointPct:         DEFAULT_MIN_NEST_SPAWNPOINT_PCT,
+		MinNestCoveragePct:           DEFAULT_MIN_NEST_COVERAGE_PCT,
+		SkipLowCoverage:              DEFAULT_SKIP_LOW_COVERAGE,
+		NestMatchingPolicy:           DEFAULT_NEST_MATCHING_POLICY,
+		NestBufferMeters:             DEFAULT_NEST_BUFFER_METERS,
+		MinTotalPokemon:              DEFAULT_MIN_TOTAL_POKEMON,
+		MaxGlobalSpawnPct:            DEFAULT_MAX_GLOBAL_SPAWN_PCT,
+		MinNestPctToGlobalPctRatio:   DEFAULT_MIN_NEST_PCT_TO_GLOBAL_PCT_RATIO,
+		AreaBaselines:                DEFAULT_AREA_BASELINES,
+		AreaBaselineMinPokemon:       DEFAULT_AREA_BASELINE_MIN_POKEMON,
+		SkipPeriodMinGlobalSpawnPct:  DEFAULT_SKIP_PERIOD_MIN_GLOBAL_SPAWN_PCT,
+		NestChangeMinWins:            DEFAULT_NEST_CHANGE_MIN_WINS,
+		NestChangeMinMargin:          DEFAULT_NEST_CHANGE_MIN_MARGIN,
+		NoNestingPokemonAgeHours:     DEFAULT_NO_NESTING_POKEMON_AGE_HOURS,
+		StatsSaveIntervalMinutes:     DEFAULT_STATS_SAVE_INTERVAL_MINUTES,
+		MigrationIntervalDays:        DEFAULT_MIGRATION_INTERVAL_DAYS,
+		MigrationClearNestingPokemon: DEFAULT_MIGRATION_CLEAR_NESTING_POKEMON,
+		DedupMaxEncounters:           DEFAULT_DEDUP_MAX_ENCOUNTERS,
+		DedupDefaultTTLMinutes:       DEFAULT_DEDUP_DEFAULT_TTL_MINUTES,
+
+		SpawnpointIndex:               DEFAULT_SPAWNPOINT_INDEX,
+		SpawnpointIndexRefreshMinutes: DEFAULT_SPAWNPOINT_INDEX_REFRESH_MINUTES,
+		SpawnpointIndexMaxAgeDays:     DEFAULT_SPAWNPOINT_INDEX_MAX_AGE_DAYS,
+		SkipSpawnpointsOutsideNests:   DEFAULT_SKIP_SPAWNPOINTS_OUTSIDE_NESTS,
 	}
 }
 
 func (cfg *Config) Validate() error {
+	switch cfg.NestingStrategy {
+	case NESTING_STRATEGY_THRESHOLD, NESTING_STRATEGY_SCORE:
+	default:
+		return fmt.Errorf("invalid nesting_strategy '%s': must be '%s' or '%s'", cfg.NestingStrategy, NESTING_STRATEGY_THRESHOLD, NESTING_STRATEGY_SCORE)
+	}
+
+	if val := cfg.MinNestingConfidence; val < 0 || val > 1 {
+		return fmt.Errorf("invalid min_nesting_confidence '%0.3f': must be >= 0 and <= 1", val)
+	}
+
+	if val := cfg.MaxNestingCandidates; val < 0 || val > 10 {
+		return fmt.Errorf("invalid max_nesting_candidates '%d': must be >= 0 and <= 10", val)
+	}
+
+	if val := cfg.MinNestSpawnpointPct; val < 0 || val > 100 {
+		return fmt.Errorf("invalid min_nest_spawnpoint_pct '%0.3f': must be >= 0 and <= 100", val)
+	}
+
+	if val := cfg.MinNestCoveragePct; val < 0 || val > 100 {
+		return fmt.Errorf("invalid min_nest_coverage_pct '%0.3f': must be >= 0 and <= 100", val)
+	}
+
+	switch val := cfg.NestMatchingPolicy; val {
+	case "", NEST_MATCHING_POLICY_ALL, NEST_MATCHING_POLICY_SMALLEST, NEST_MATCHING_POLICY_AREA_WEIGHTED:
+	default:
+		return fmt.Errorf("invalid nest_matching_policy '%s': must be one of '%s', '%s', '%s'", val, NEST_MATCHING_POLICY_ALL, NEST_MATCHING_POLICY_SMALLEST, NEST_MATCHING_POLICY_AREA_WEIGHTED)
+	}
+
+	if val := cfg.NestBufferMeters; val < -100 || val > 100 {
+		return fmt.Errorf("invalid nest_buffer_meters '%0.1f': must be >= -100 and <= 100", val)
+	}
+
+	if val := cfg.SpawnpointIndexRefreshMinutes; val < 1 {
+		return fmt.Errorf("invalid spawnpoint_index_refresh_minutes '%d': must be > 0", val)
+	}
+
+	if val := cfg.SpawnpointIndexMaxAgeDays; val < 1 {
+		return fmt.Errorf("invalid spawnpoint_index_max_age_days '%d': must be > 0", val)
+	}
+
+	if val := cfg.MaxNestingPokemon; val < 1 || val > 5 {
+		return fmt.Errorf("invalid max_nesting_pokemon '%d': must be >= 1 and <= 5", val)
+	}
+
 	if val := cfg.RotationIntervalMinutes; val < 1 {
 		return fmt.Errorf("invalid rotation_interval_minutes '%d': must be > 0", val)
 	}
 
+	if cfg.AlignRotation {
+		if val := cfg.RotationIntervalMinutes; (24*60)%val != 0 {
+			return fmt.Errorf("invalid rotation_interval_minutes '%d': must divide evenly into a day when align_rotation is enabled", val)
+		}
+	}
+
+	if val := cfg.RotationTimezone; val != "" {
+		if _, err := time.LoadLocation(val); err != nil {
+			return fmt.Errorf("invalid rotation_timezone '%s': %w", val, err)
+		}
+	}
+
 	if val := cfg.MinHistoryDurationHours; val < 1 || val > 12 {
 		return fmt.Errorf("invalid min_history_duration_hours '%d': must be > %d and <= %d", val, 0, 12)
 	}
@@ -108,6 +467,14 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("min_history_duration_hours(%d) > max_history_duration_hours(%d)", min, max)
 	}
 
+	if val := cfg.DownsampleAfterHours; val < 0 {
+		return fmt.Errorf("invalid downsample_after_hours '%d': must be >= 0", val)
+	}
+
+	if val := cfg.DownsampleBucketMinutes; cfg.DownsampleAfterHours > 0 && val < cfg.RotationIntervalMinutes {
+		return fmt.Errorf("invalid downsample_bucket_minutes '%d': must be >= rotation_interval_minutes (%d)", val, cfg.RotationIntervalMinutes)
+	}
+
 	if maxGlobalSpawnPct := cfg.MaxGlobalSpawnPct; maxGlobalSpawnPct > 0 && maxGlobalSpawnPct < 1 {
 		return fmt.Errorf("max_global_spawn_pct is too low (%0.3f < 1)", maxGlobalSpawnPct)
 	}
@@ -116,5 +483,50 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("skip_period_min_global_spawn_pct is too low (%0.3f < 3)", skipPeriodMinGlobalSpawnPct)
 	}
 
+	if val := cfg.AreaBaselineMinPokemon; cfg.AreaBaselines && val < 1 {
+		return fmt.Errorf("invalid area_baseline_min_pokemon '%d': must be > 0", val)
+	}
+
+	if val := cfg.NestChangeMinWins; val < 1 {
+		return fmt.Errorf("invalid nest_change_min_wins '%d': must be > 0", val)
+	}
+
+	if val := cfg.NestChangeMinMargin; val < 0 || val > 100 {
+		return fmt.Errorf("invalid nest_change_min_margin '%0.3f': must be >= 0 and <= 100", val)
+	}
+
+	if val := cfg.StatsSaveIntervalMinutes; val < 1 {
+		return fmt.Errorf("invalid stats_save_interval_minutes '%d': must be > 0", val)
+	}
+
+	if val := cfg.MigrationAnchor; val != "" {
+		if _, err := time.Parse(time.RFC3339, val); err != nil {
+			return fmt.Errorf("invalid migration_anchor '%s': must be in RFC3339 format like '2024-03-28T00:00:00Z': %w", val, err)
+		}
+		if days := cfg.MigrationIntervalDays; days < 1 {
+			return fmt.Errorf("invalid migration_interval_days '%d': must be > 0", days)
+		}
+	}
+
+	if val := cfg.DedupMaxEncounters; val < 0 {
+		return fmt.Errorf("invalid dedup_max_encounters '%d': must be >= 0", val)
+	}
+
+	if val := cfg.DedupDefaultTTLMinutes; val < 1 {
+		return fmt.Errorf("invalid dedup_default_ttl_minutes '%d': must be > 0", val)
+	}
+
+	for idx := range cfg.FormRules {
+		if err := cfg.FormRules[idx].Validate(); err != nil {
+			return fmt.Errorf("form_rules[%d]: %w", idx, err)
+		}
+	}
+
+	for idx := range cfg.Overrides {
+		if err := cfg.Overrides[idx].Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,179 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/UnownHash/Fletchling/areas"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// ConfigOverride replaces some of the nesting thresholds for nests in
+// certain areas or for specific nests. Unset values are not overridden.
+type ConfigOverride struct {
+	// Areas uses the same syntax as webhook areas: "London/*", "*/Harrow", "Harrow".
+	Areas []string `koanf:"areas" json:"areas,omitempty"`
+	// NestIds are specific nests to override.
+	NestIds []int64 `koanf:"nest_ids" json:"nest_ids,omitempty"`
+
+	MinNestPokemon             *int     `koanf:"min_nest_pokemon" json:"min_nest_pokemon,omitempty"`
+	MinNestPokemonPct          *float64 `koanf:"min_nest_pokemon_pct" json:"min_nest_pokemon_pct,omitempty"`
+	MinTotalPokemon            *int     `koanf:"min_total_pokemon" json:"min_total_pokemon,omitempty"`
+	MaxGlobalSpawnPct          *float64 `koanf:"max_global_spawn_pct" json:"max_global_spawn_pct,omitempty"`
+	MinNestPctToGlobalPctRatio *float64 `koanf:"min_nest_pct_to_global_pct_ratio" json:"min_nest_pct_to_global_pct_ratio,omitempty"`
+	MinNestingConfidence       *float64 `koanf:"min_nesting_confidence" json:"min_nesting_confidence,omitempty"`
+	MinNestSpawnpointPct       *float64 `koanf:"min_nest_spawnpoint_pct" json:"min_nest_spawnpoint_pct,omitempty"`
+	NestBufferMeters           *float64 `koanf:"nest_buffer_meters" json:"nest_buffer_meters,omitempty"`
+}
+
+func (override *ConfigOverride) matchesArea(nest *models.Nest) bool {
+	if len(override.Areas) == 0 {
+		return false
+	}
+	areaName := areas.AreaStringToAreaName(nest.AreaName.ValueOrZero())
+	return areaName.Matches(areas.AreaStringsToAreaNames(override.Areas))
+}
+
+func (override *ConfigOverride) matchesNestId(nest *models.Nest) bool {
+	for _, nestId := range override.NestIds {
+		if nestId == nest.Id {
+			return true
+		}
+	}
+	return false
+}
+
+func (override *ConfigOverride) applyTo(cfg *Config) {
+	if v := override.MinNestPokemon; v != nil {
+		cfg.MinNestPokemon = *v
+	}
+	if v := override.MinNestPokemonPct; v != nil {
+		cfg.MinNestPokemonPct = *v
+	}
+	if v := override.MinTotalPokemon; v != nil {
+		cfg.MinTotalPokemon = *v
+	}
+	if v := override.MaxGlobalSpawnPct; v != nil {
+		cfg.MaxGlobalSpawnPct = *v
+	}
+	if v := override.MinNestPctToGlobalPctRatio; v != nil {
+		cfg.MinNestPctToGlobalPctRatio = *v
+	}
+	if v := override.MinNestingConfidence; v != nil {
+		cfg.MinNestingConfidence = *v
+	}
+	if v := override.MinNestSpawnpointPct; v != nil {
+		cfg.MinNestSpawnpointPct = *v
+	}
+	if v := override.NestBufferMeters; v != nil {
+		cfg.NestBufferMeters = *v
+	}
+}
+
+func (override *ConfigOverride) String() string {
+	var parts []string
+	if len(override.Areas) > 0 {
+		parts = append(parts, fmt.Sprintf("areas:%v", override.Areas))
+	}
+	if len(override.NestIds) > 0 {
+		parts = append(parts, fmt.Sprintf("nest_ids:%v", override.NestIds))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (override *ConfigOverride) writeConfiguration(buf *bytes.Buffer) {
+	buf.WriteString("{")
+	buf.WriteString(override.String())
+	if v := override.MinNestPokemon; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_nest_pokemon:%d", *v))
+	}
+	if v := override.MinNestPokemonPct; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_nest_pokemon_pct:%0.3f", *v))
+	}
+	if v := override.MinTotalPokemon; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_total_pokemon:%d", *v))
+	}
+	if v := override.MaxGlobalSpawnPct; v != nil {
+		buf.WriteString(fmt.Sprintf(" max_global_spawn_pct:%0.3f", *v))
+	}
+	if v := override.MinNestPctToGlobalPctRatio; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_nest_pct_to_global_pct_ratio:%0.3f", *v))
+	}
+	if v := override.MinNestingConfidence; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_nesting_confidence:%0.3f", *v))
+	}
+	if v := override.MinNestSpawnpointPct; v != nil {
+		buf.WriteString(fmt.Sprintf(" min_nest_spawnpoint_pct:%0.3f", *v))
+	}
+	if v := override.NestBufferMeters; v != nil {
+		buf.WriteString(fmt.Sprintf(" nest_buffer_meters:%0.1f", *v))
+	}
+	buf.WriteString("}")
+}
+
+func (override *ConfigOverride) Validate() error {
+	if len(override.Areas) == 0 && len(override.NestIds) == 0 {
+		return errors.New("override requires areas or nest_ids")
+	}
+
+	if v := override.MinNestPokemon; v != nil && *v < 0 {
+		return fmt.Errorf("override %s: invalid min_nest_pokemon '%d': must be >= 0", override, *v)
+	}
+
+	if v := override.MinNestPokemonPct; v != nil && (*v < 0 || *v > 100) {
+		return fmt.Errorf("override %s: invalid min_nest_pokemon_pct '%0.3f': must be >= 0 and <= 100", override, *v)
+	}
+
+	if v := override.MinTotalPokemon; v != nil && *v < 0 {
+		return fmt.Errorf("override %s: invalid min_total_pokemon '%d': must be >= 0", override, *v)
+	}
+
+	if v := override.MaxGlobalSpawnPct; v != nil && *v > 0 && *v < 1 {
+		return fmt.Errorf("override %s: max_global_spawn_pct is too low (%0.3f < 1)", override, *v)
+	}
+
+	if v := override.MinNestPctToGlobalPctRatio; v != nil && *v < 0 {
+		return fmt.Errorf("override %s: invalid min_nest_pct_to_global_pct_ratio '%0.3f': must be >= 0", override, *v)
+	}
+
+	if v := override.MinNestingConfidence; v != nil && (*v < 0 || *v > 1) {
+		return fmt.Errorf("override %s: invalid min_nesting_confidence '%0.3f': must be >= 0 and <= 1", override, *v)
+	}
+
+	if v := override.MinNestSpawnpointPct; v != nil && (*v < 0 || *v > 100) {
+		return fmt.Errorf("override %s: invalid min_nest_spawnpoint_pct '%0.3f': must be >= 0 and <= 100", override, *v)
+	}
+
+	if v := override.NestBufferMeters; v != nil && (*v < -100 || *v > 100) {
+		return fmt.Errorf("override %s: invalid nest_buffer_meters '%0.1f': must be >= -100 and <= 100", override, *v)
+	}
+
+	return nil
+}
+
+// ForNest returns the config with any overrides for the nest merged
+// in. Area overrides are applied first, in order, followed by nest
+// overrides, so that the most specific wins.
+func (cfg *Config) ForNest(nest *models.Nest) Config {
+	nestCfg := *cfg
+
+	if len(cfg.Overrides) == 0 {
+		return nestCfg
+	}
+
+	for idx := range cfg.Overrides {
+		if override := &cfg.Overrides[idx]; override.matchesArea(nest) {
+			override.applyTo(&nestCfg)
+		}
+	}
+
+	for idx := range cfg.Overrides {
+		if override := &cfg.Overrides[idx]; override.matchesNestId(nest) {
+			override.applyTo(&nestCfg)
+		}
+	}
+
+	return nestCfg
+}
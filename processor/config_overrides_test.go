@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+)
+
+func TestConfigForNest(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+
+	base := newTestConfig()
+	base.MinNestPokemon = 10
+	base.MinNestPokemonPct = 5
+	base.MinTotalPokemon = 20
+	base.NestBufferMeters = 0
+	base.Overrides = []ConfigOverride{
+		{
+			Areas:             []string{"London/*"},
+			MinNestPokemon:    intPtr(20),
+			MinNestPokemonPct: floatPtr(7),
+		},
+		// listed before the area overrides, but applied after them.
+		{
+			NestIds:        []int64{2},
+			MinNestPokemon: intPtr(30),
+		},
+		{
+			Areas:           []string{"Harrow"},
+			MinTotalPokemon: intPtr(40),
+		},
+		{
+			Areas:            []string{"London/Chelsea"},
+			MinNestPokemon:   intPtr(25),
+			NestBufferMeters: floatPtr(-5),
+		},
+	}
+	if err := base.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		nestId   int64
+		areaName string
+		// changes to the base config.
+		want func(*Config)
+	}{
+		{
+			name:     "no match",
+			nestId:   1,
+			areaName: "Paris/Centre",
+			want:     func(*Config) {},
+		},
+		{
+			name:   "no area",
+			nestId: 1,
+			want:   func(*Config) {},
+		},
+		{
+			name:     "parent wildcard and name in any parent",
+			nestId:   1,
+			areaName: "London/Harrow",
+			want: func(cfg *Config) {
+				cfg.MinNestPokemon = 20
+				cfg.MinNestPokemonPct = 7
+				cfg.MinTotalPokemon = 40
+			},
+		},
+		{
+			name:     "name in any parent",
+			nestId:   1,
+			areaName: "Paris/Harrow",
+			want: func(cfg *Config) {
+				cfg.MinTotalPokemon = 40
+			},
+		},
+		{
+			name:     "later area override wins",
+			nestId:   1,
+			areaName: "London/Chelsea",
+			want: func(cfg *Config) {
+				cfg.MinNestPokemon = 25
+				cfg.MinNestPokemonPct = 7
+				cfg.NestBufferMeters = -5
+			},
+		},
+		{
+			name:     "nest id wins over areas",
+			nestId:   2,
+			areaName: "London/Chelsea",
+			want: func(cfg *Config) {
+				cfg.MinNestPokemon = 30
+				cfg.MinNestPokemonPct = 7
+				cfg.NestBufferMeters = -5
+			},
+		},
+		{
+			name:   "nest id without area",
+			nestId: 2,
+			want: func(cfg *Config) {
+				cfg.MinNestPokemon = 30
+			},
+		},
+	}
+
+	describe := func(cfg Config) string {
+		return fmt.Sprintf("min_nest_pokemon:%d min_nest_pokemon_pct:%0.3f min_total_pokemon:%d nest_buffer_meters:%0.1f",
+			cfg.MinNestPokemon, cfg.MinNestPokemonPct, cfg.MinTotalPokemon, cfg.NestBufferMeters,
+		)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nest := newTestNest(t, clk, tt.nestId, 10, 10, 0.01)
+			if tt.areaName != "" {
+				nest.AreaName = null.StringFrom(tt.areaName)
+			}
+
+			want := base
+			tt.want(&want)
+
+			got := base.ForNest(nest)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", describe(got), describe(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigNextRotationAfter(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(value string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name     string
+		interval int
+		align    bool
+		timezone string
+		last     string
+		// every rotation after 'last', in order.
+		want []string
+	}{
+		{
+			name:     "not aligned",
+			interval: 60,
+			last:     "2024-05-01T12:20:00Z",
+			want:     []string{"2024-05-01T13:20:00Z", "2024-05-01T14:20:00Z"},
+		},
+		{
+			// the stats start at an arbitrary time, so the first time
+			// period is partial. See NestProcessorManager.Run().
+			name:     "first period is partial",
+			interval: 60,
+			align:    true,
+			timezone: "UTC",
+			last:     "2024-05-01T12:20:00Z",
+			want:     []string{"2024-05-01T13:00:00Z", "2024-05-01T14:00:00Z"},
+		},
+		{
+			name:     "exactly on a boundary",
+			interval: 60,
+			align:    true,
+			timezone: "UTC",
+			last:     "2024-05-01T12:00:00Z",
+			want:     []string{"2024-05-01T13:00:00Z"},
+		},
+		{
+			name:     "just before a boundary",
+			interval: 60,
+			align:    true,
+			timezone: "UTC",
+			last:     "2024-05-01T12:59:59.999999999Z",
+			want:     []string{"2024-05-01T13:00:00Z", "2024-05-01T14:00:00Z"},
+		},
+		{
+			name:     "across midnight",
+			interval: 360,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-05-01T21:00:00-04:00",
+			want:     []string{"2024-05-02T00:00:00-04:00", "2024-05-02T06:00:00-04:00"},
+		},
+		{
+			// 02:00 doesn't exist. The hour around it is one time period.
+			name:     "dst forward hourly",
+			interval: 60,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-03-10T00:30:00-05:00",
+			want:     []string{"2024-03-10T01:00:00-05:00", "2024-03-10T03:00:00-04:00", "2024-03-10T04:00:00-04:00"},
+		},
+		{
+			name:     "dst forward every 2 hours",
+			interval: 120,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-03-10T00:00:00-05:00",
+			want:     []string{"2024-03-10T03:00:00-04:00", "2024-03-10T04:00:00-04:00", "2024-03-10T06:00:00-04:00"},
+		},
+		{
+			// the day is 23 hours long.
+			name:     "dst forward daily",
+			interval: 24 * 60,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-03-10T00:00:00-05:00",
+			want:     []string{"2024-03-11T00:00:00-04:00", "2024-03-12T00:00:00-04:00"},
+		},
+		{
+			// 01:00 happens twice. Both hours are one time period.
+			name:     "dst back hourly",
+			interval: 60,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-11-03T00:30:00-04:00",
+			want:     []string{"2024-11-03T01:00:00-04:00", "2024-11-03T02:00:00-05:00", "2024-11-03T03:00:00-05:00"},
+		},
+		{
+			name:     "dst back in the repeated hour",
+			interval: 60,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-11-03T01:30:00-05:00",
+			want:     []string{"2024-11-03T02:00:00-05:00"},
+		},
+		{
+			// the day is 25 hours long.
+			name:     "dst back daily",
+			interval: 24 * 60,
+			align:    true,
+			timezone: "America/New_York",
+			last:     "2024-11-03T00:00:00-04:00",
+			want:     []string{"2024-11-04T00:00:00-05:00", "2024-11-05T00:00:00-05:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestConfig()
+			config.RotationIntervalMinutes = tt.interval
+			config.AlignRotation = tt.align
+			config.RotationTimezone = tt.timezone
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			last := at(tt.last)
+			for idx, wantValue := range tt.want {
+				want := at(wantValue)
+				next := config.NextRotationAfter(last)
+				if !next.Equal(want) {
+					t.Fatalf("rotation %d after %s: got %s, want %s", idx+1, last.In(newYork), next.In(newYork), want.In(newYork))
+				}
+				last = next
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// nestDecision is what a nest's stats come to with a config. Computing
+// it changes nothing, so that ProcessStatsCollection() and Evaluate()
+// can share it.
+type nestDecision struct {
+	Coverage models.NestCoverage
+	// SkippedLowCoverage is set when the nest was not evaluated because
+	// its coverage is low and skip_low_coverage is set.
+	SkippedLowCoverage bool
+	Candidates         []models.NestingCandidate
+	// TooLittleHistory is set when there is not enough history yet to
+	// change the nesting pokemon.
+	TooLittleHistory bool
+	// NestingPokemon is the nesting pokemon to set, which is the current
+	// one with fresh stats while a change is pending. nil means there is
+	// no nesting pokemon.
+	NestingPokemon *models.NestingPokemonInfo
+	// PendingChange is the change that is being held back, if any.
+	PendingChange *models.PendingNestChange
+}
+
+// Changes returns whether the nesting pokemon is set from this decision.
+// If not, the nest keeps the nesting pokemon it has.
+func (decision *nestDecision) Changes() bool {
+	return !decision.SkippedLowCoverage && !decision.TooLittleHistory
+}
+
+// decideNest computes the nesting pokemon for 'nest' from 'fstats' using
+// 'config' and 'strategy', which must have been created from 'config'.
+// Returns nil if there are no stats for the nest.
+func decideNest(fstats *FrozenStatsCollection, nest *models.Nest, config Config, strategy NestingStrategy, logPrefix string, now time.Time) *nestDecision {
+	summary := fstats.GetSummaryForNest(nest, config.areaBaselineMinPokemon())
+	if summary == nil {
+		return nil
+	}
+
+	decision := &nestDecision{
+		Coverage: fstats.NestCoverage(nest, config.MinNestCoveragePct),
+	}
+
+	if decision.Coverage.Low && config.SkipLowCoverage {
+		decision.SkippedLowCoverage = true
+		return decision
+	}
+
+	ni, candidates := strategy.ComputeNesting(*summary, logPrefix)
+	decision.Candidates = candidates
+
+	if minHistory := config.MinHistoryDuration(); summary.Duration < minHistory {
+		decision.TooLittleHistory = true
+		return decision
+	}
+
+	decision.NestingPokemon, decision.PendingChange = decideNestChange(config, nest, *summary, ni, candidates, now)
+
+	return decision
+}
+
+// decideNestChange holds back a change of nesting pokemon until the new
+// pokemon has won enough evaluations in a row or by a large enough margin.
+// Returns the nesting pokemon to use, which is the current one with fresh
+// stats while a change is pending, and the pending change, if any.
+func decideNestChange(config Config, nest *models.Nest, summary models.NestTimePeriodSummary, ni *models.NestingPokemonInfo, candidates []models.NestingCandidate, now time.Time) (*models.NestingPokemonInfo, *models.PendingNestChange) {
+	cur_ni, _ := nest.GetNestingPokemon()
+
+	if ni == nil || cur_ni == nil || cur_ni.Stale || ni.PokemonKey == cur_ni.PokemonKey {
+		// not a change, so any pending change lost its streak.
+		return ni, nil
+	}
+
+	pendingChange := &models.PendingNestChange{
+		PokemonKey: ni.PokemonKey,
+		Wins:       1,
+		Since:      now,
+	}
+
+	if prev := nest.GetPendingChange(); prev != nil && prev.PokemonKey == ni.PokemonKey {
+		pendingChange.Wins = prev.Wins + 1
+		pendingChange.Since = prev.Since
+	}
+
+	var curPokStats *models.NestPokemonCountAndTotal
+	for idx := range summary.PokemonCountsAndTotals {
+		if pokStats := &summary.PokemonCountsAndTotals[idx]; pokStats.PokemonKey == cur_ni.PokemonKey {
+			curPokStats = pokStats
+			break
+		}
+	}
+
+	pendingChange.Margin = ni.NestPct()
+	if curPokStats != nil {
+		pendingChange.Margin -= curPokStats.NestPct()
+	}
+
+	if pendingChange.Wins >= config.NestChangeMinWins ||
+		(config.NestChangeMinMargin > 0 && pendingChange.Margin >= config.NestChangeMinMargin) {
+		return ni, nil
+	}
+
+	if curPokStats == nil {
+		// not seen at all anymore. keep what we had.
+		return cur_ni, pendingChange
+	}
+
+	held_ni := newNestingPokemonInfo(summary, *curPokStats)
+	held_ni.Confidence = cur_ni.Confidence
+	for _, candidate := range candidates {
+		if candidate.PokemonKey == cur_ni.PokemonKey {
+			held_ni.Confidence = candidate.Confidence
+			break
+		}
+	}
+	held_ni.Candidates = candidates
+
+	// the others that are nesting now, including the pending one.
+	if maxAdditional := config.MaxNestingPokemon - 1; maxAdditional > 0 {
+		others := append([]models.AdditionalNestingPokemon{{
+			PokemonKey:      ni.PokemonKey,
+			NestCount:       ni.NestCount,
+			NestHourlyCount: ni.NestHourlyCount,
+			NestPct:         ni.NestPct(),
+			Confidence:      ni.Confidence,
+		}}, ni.AdditionalPokemon...)
+		for _, other := range others {
+			if other.PokemonKey != cur_ni.PokemonKey && len(held_ni.AdditionalPokemon) < maxAdditional {
+				held_ni.AdditionalPokemon = append(held_ni.AdditionalPokemon, other)
+			}
+		}
+	}
+
+	return held_ni, pendingChange
+}
@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type encounterCacheEntry struct {
+	encounterId uint64
+	expiresAt   int64
+	// position in the expiry heap.
+	index int
+}
+
+// encounterExpiryHeap is a min-heap on expiresAt, so that the next
+// encounter to expire is always at the front.
+type encounterExpiryHeap []*encounterCacheEntry
+
+func (h encounterExpiryHeap) Len() int           { return len(h) }
+func (h encounterExpiryHeap) Less(i, j int) bool { return h[i].expiresAt < h[j].expiresAt }
+
+func (h encounterExpiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *encounterExpiryHeap) Push(x any) {
+	entry := x.(*encounterCacheEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *encounterExpiryHeap) Pop() any {
+	old := *h
+	l := len(old)
+	entry := old[l-1]
+	old[l-1] = nil
+	*h = old[:l-1]
+	return entry
+}
+
+// encounterCacheShard holds the encounters whose ids map to it. Each
+// has its own lock, so that webhook workers don't all wait on a single
+// one.
+type encounterCacheShard struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[uint64]*encounterCacheEntry
+	expiries   encounterExpiryHeap
+	// keep shards on separate cache lines.
+	_ [64]byte
+}
+
+// requires shard.mutex be locked. removes expired entries and, if
+// needed, the soonest expiring entries to get under 'maxEntries'.
+func (shard *encounterCacheShard) evict(now int64) {
+	for len(shard.expiries) > 0 {
+		front := shard.expiries[0]
+		if front.expiresAt > now && len(shard.entries) <= shard.maxEntries {
+			return
+		}
+		heap.Pop(&shard.expiries)
+		delete(shard.entries, front.encounterId)
+	}
+}
+
+// requires shard.mutex be locked.
+func (shard *encounterCacheShard) add(encounterId uint64, expiresAt int64, now int64) bool {
+	entry, ok := shard.entries[encounterId]
+	if ok && entry.expiresAt > now {
+		if expiresAt > entry.expiresAt {
+			entry.expiresAt = expiresAt
+			heap.Fix(&shard.expiries, entry.index)
+		}
+		return false
+	}
+
+	if ok {
+		// expired, but not evicted yet.
+		entry.expiresAt = expiresAt
+		heap.Fix(&shard.expiries, entry.index)
+	} else {
+		entry = &encounterCacheEntry{
+			encounterId: encounterId,
+			expiresAt:   expiresAt,
+		}
+		shard.entries[encounterId] = entry
+		heap.Push(&shard.expiries, entry)
+	}
+	shard.evict(now)
+
+	return true
+}
+
+// EncounterCache remembers encounter IDs until they expire so that the
+// same spawn is not counted more than once when Golbat re-sends it. It
+// is split into shards by encounter id, and each shard holds its share
+// of maxEntries. When a shard is full, its encounters closest to
+// expiring are evicted first.
+type EncounterCache struct {
+	shards []*encounterCacheShard
+}
+
+func (cache *EncounterCache) shard(encounterId uint64) *encounterCacheShard {
+	return cache.shards[mixBits(encounterId)%uint64(len(cache.shards))]
+}
+
+// Add records an encounter that expires at 'expiresAt'. Returns false
+// if the encounter was already known, meaning it is a duplicate.
+func (cache *EncounterCache) Add(encounterId uint64, expiresAt time.Time, now time.Time) bool {
+	shard := cache.shard(encounterId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	return shard.add(encounterId, expiresAt.Unix(), now.Unix())
+}
+
+func (cache *EncounterCache) Len() int {
+	var l int
+	for _, shard := range cache.shards {
+		shard.mutex.Lock()
+		l += len(shard.entries)
+		shard.mutex.Unlock()
+	}
+	return l
+}
+
+// SetMaxEntries changes the max size of the cache, evicting entries
+// if it is now too large or expired as of 'now'.
+func (cache *EncounterCache) SetMaxEntries(maxEntries int, now time.Time) {
+	shardMaxEntries := shardMaxEncounters(maxEntries, len(cache.shards))
+	for _, shard := range cache.shards {
+		shard.mutex.Lock()
+		shard.maxEntries = shardMaxEntries
+		shard.evict(now.Unix())
+		shard.mutex.Unlock()
+	}
+}
+
+// shardMaxEncounters splits 'maxEntries' over the shards, rounding up.
+func shardMaxEncounters(maxEntries, numShards int) int {
+	return (maxEntries + numShards - 1) / numShards
+}
+
+// NewEncounterCache creates a cache with a shard per CPU.
+func NewEncounterCache(maxEntries int) *EncounterCache {
+	return newEncounterCache(maxEntries, runtime.GOMAXPROCS(0))
+}
+
+func newEncounterCache(maxEntries, numShards int) *EncounterCache {
+	cache := &EncounterCache{
+		shards: make([]*encounterCacheShard, numShards),
+	}
+	shardMaxEntries := shardMaxEncounters(maxEntries, numShards)
+	for idx := range cache.shards {
+		cache.shards[idx] = &encounterCacheShard{
+			maxEntries: shardMaxEntries,
+			entries:    make(map[uint64]*encounterCacheEntry),
+		}
+	}
+	return cache
+}
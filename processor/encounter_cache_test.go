@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncounterCacheDuplicates(t *testing.T) {
+	cache := newEncounterCache(100, 4)
+	now := time.Unix(1_700_000_000, 0)
+
+	if !cache.Add(1, now.Add(time.Minute), now) {
+		t.Fatal("first add of encounter should not be a duplicate")
+	}
+	if cache.Add(1, now.Add(time.Minute), now) {
+		t.Fatal("second add of encounter should be a duplicate")
+	}
+
+	// once expired, the encounter counts again.
+	later := now.Add(2 * time.Minute)
+	if !cache.Add(1, later.Add(time.Minute), later) {
+		t.Fatal("add after expiry should not be a duplicate")
+	}
+}
+
+func TestEncounterCacheExtendInPlace(t *testing.T) {
+	cache := newEncounterCache(100, 1)
+	now := time.Unix(1_700_000_000, 0)
+
+	cache.Add(1, now.Add(time.Minute), now)
+	for i := 2; i < 10; i++ {
+		cache.Add(1, now.Add(time.Duration(i)*time.Minute), now)
+	}
+
+	shard := cache.shards[0]
+	if l := len(shard.expiries); l != 1 {
+		t.Fatalf("extending an expiry should not add heap entries: got %d", l)
+	}
+	if got, want := shard.entries[1].expiresAt, now.Add(9*time.Minute).Unix(); got != want {
+		t.Fatalf("expiresAt: got %d, want %d", got, want)
+	}
+
+	// still a duplicate after the original expiry.
+	later := now.Add(5 * time.Minute)
+	if cache.Add(1, later, later) {
+		t.Fatal("extended encounter should still be a duplicate")
+	}
+}
+
+func TestEncounterCacheEviction(t *testing.T) {
+	cache := newEncounterCache(10, 1)
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := uint64(1); i <= 20; i++ {
+		cache.Add(i, now.Add(time.Duration(i)*time.Minute), now)
+	}
+	if l := cache.Len(); l != 10 {
+		t.Fatalf("Len: got %d, want 10", l)
+	}
+
+	// the soonest expiring were evicted.
+	if !cache.Add(1, now.Add(time.Hour), now) {
+		t.Fatal("evicted encounter should not be a duplicate")
+	}
+	if cache.Add(20, now.Add(time.Hour), now) {
+		t.Fatal("latest expiring encounter should still be cached")
+	}
+
+	cache.SetMaxEntries(4, now)
+	if l := cache.Len(); l != 4 {
+		t.Fatalf("Len after SetMaxEntries: got %d, want 4", l)
+	}
+	if l := len(cache.shards[0].expiries); l != 4 {
+		t.Fatalf("heap size after SetMaxEntries: got %d, want 4", l)
+	}
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"errors"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// NestEvaluation is the result of running the nesting computation for
+// a nest with the current config and with another config.
+type NestEvaluation struct {
+	Nest         *models.Nest
+	Current      *models.NestingPokemonInfo
+	Hypothetical *models.NestingPokemonInfo
+	Changed      bool
+}
+
+// Evaluate computes the nesting pokemon for every nest with stats using
+// both the current config and 'config' against a snapshot of the current
+// stats, the same way processing the stats would, including coverage,
+// minimum history, and held back changes. A nest that would not be
+// changed keeps its nesting pokemon. Nothing is logged, written to the
+// DB, or sent as webhooks. 'config' must already be validated. The form
+// rules can't be changed, as the stats were counted with them.
+func (np *NestProcessor) Evaluate(config Config) ([]NestEvaluation, error) {
+	if !formRulesEqual(config.FormRules, np.config.FormRules) {
+		return nil, errors.New("'form_rules' can't be evaluated, as the stats were already counted with the current ones. Change them in the config and reload instead.")
+	}
+
+	speciesRules, err := NewSpeciesRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hypotheticalStrategy, err := NewNestingStrategy(np.logger, config, speciesRules)
+	if err != nil {
+		return nil, err
+	}
+
+	fstats := np.GetStatsSnapshot()
+	totals := fstats.Totals
+	now := np.clock.Now()
+
+	evaluations := make([]NestEvaluation, 0, len(totals.NestCounts))
+
+	for nestId := range totals.NestCounts {
+		nest := np.nestMatcher.GetNestById(nestId)
+		if nest == nil {
+			// a reload happened and nest was removed
+			continue
+		}
+
+		evaluation := NestEvaluation{
+			Nest: nest,
+		}
+
+		evaluation.Current = evaluationResult(decideNest(fstats, nest, np.config, np.nestingStrategy, "", now), nest)
+		evaluation.Hypothetical = evaluationResult(decideNest(fstats, nest, config, hypotheticalStrategy, "", now), nest)
+
+		evaluation.Changed = !evaluation.Current.SamePokemon(evaluation.Hypothetical)
+
+		evaluations = append(evaluations, evaluation)
+	}
+
+	return evaluations, nil
+}
+
+// evaluationResult returns the nesting pokemon 'nest' would have after
+// 'decision'.
+func evaluationResult(decision *nestDecision, nest *models.Nest) *models.NestingPokemonInfo {
+	if decision == nil || !decision.Changes() {
+		ni, _ := nest.GetNestingPokemon()
+		return ni
+	}
+	return decision.NestingPokemon
+}
@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+)
+
+func TestEvaluateMatchesProcessing(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	config := newTestConfig()
+	config.NestChangeMinWins = 3
+
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	np := newTestNestProcessor(t, clk, config, &testWebhookSender{}, nest)
+
+	// bulbasaur starts nesting.
+	addTestBackground(np)
+	addTestSpawns(np, 1, testInsideLat, testInsideLon, 100)
+	clk.Advance(time.Hour)
+	np.ProcessStatsCollection(np.RotateStats())
+
+	if ni, _ := nest.GetNestingPokemon(); ni == nil || ni.PokemonKey.PokemonId != 1 {
+		t.Fatalf("got nesting pokemon %v, want bulbasaur", ni)
+	}
+
+	// then only charmander is seen.
+	np.PurgeOldestStats(time.Hour + time.Minute)
+	addTestBackground(np)
+	addTestSpawns(np, 4, testInsideLat, testInsideLon, 100)
+	clk.Advance(time.Hour)
+	np.RotateStats()
+
+	evaluate := func(config Config) NestEvaluation {
+		t.Helper()
+		evaluations, err := np.Evaluate(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evaluations) != 1 {
+			t.Fatalf("got %d evaluations, want 1", len(evaluations))
+		}
+		return evaluations[0]
+	}
+
+	// the change is held back with the current config.
+	changeNow := config
+	changeNow.NestChangeMinWins = 1
+	evaluation := evaluate(changeNow)
+	if evaluation.Current.PokemonKey.PokemonId != 1 || evaluation.Hypothetical.PokemonKey.PokemonId != 4 || !evaluation.Changed {
+		t.Errorf("got current %s and hypothetical %s, want bulbasaur held back and charmander", evaluation.Current.PokemonKey, evaluation.Hypothetical.PokemonKey)
+	}
+	if nest.GetPendingChange() != nil {
+		t.Errorf("evaluating set a pending change")
+	}
+
+	// a nest with low coverage that is skipped keeps its pokemon.
+	numSpawnpoints := int64(100)
+	nest.Spawnpoints = &numSpawnpoints
+	skipLowCoverage := changeNow
+	skipLowCoverage.MinNestCoveragePct = 50
+	skipLowCoverage.SkipLowCoverage = true
+	evaluation = evaluate(skipLowCoverage)
+	if evaluation.Hypothetical.PokemonKey.PokemonId != 1 || evaluation.Changed {
+		t.Errorf("got hypothetical %s, want bulbasaur kept because of low coverage", evaluation.Hypothetical.PokemonKey)
+	}
+	nest.Spawnpoints = nil
+
+	// with not enough history, nothing changes either.
+	moreHistory := changeNow
+	moreHistory.MinHistoryDurationHours = 2
+	moreHistory.MaxHistoryDurationHours = max(moreHistory.MaxHistoryDurationHours, 2)
+	evaluation = evaluate(moreHistory)
+	if evaluation.Hypothetical.PokemonKey.PokemonId != 1 || evaluation.Changed {
+		t.Errorf("got hypothetical %s, want bulbasaur kept because of too little history", evaluation.Hypothetical.PokemonKey)
+	}
+
+	// the stats were counted with the current form rules.
+	otherFormRules := changeNow
+	otherFormRules.FormRules = []FormRule{{PokemonIds: []int{4}, ToForm: 1}}
+	if _, err := np.Evaluate(otherFormRules); err == nil {
+		t.Errorf("evaluating other form rules didn't fail")
+	}
+}
@@ -0,0 +1,332 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/areas"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// Event is a known event window (CD, habitat rotation, raid hour, etc)
+// during which pokemon should not be counted.
+type Event struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// Areas limits the event to nests in these areas. Same syntax as
+	// webhook areas: "London/*", "*/Harrow", "Harrow". Empty means
+	// everywhere.
+	Areas []string `json:"areas,omitempty"`
+
+	areaNames []areas.AreaName
+}
+
+func (ev *Event) Validate() error {
+	if ev.StartTime.IsZero() || ev.EndTime.IsZero() {
+		return errors.New("event requires start_time and end_time")
+	}
+	if !ev.EndTime.After(ev.StartTime) {
+		return fmt.Errorf("event end_time (%s) is not after start_time (%s)",
+			ev.EndTime.Format(time.RFC3339),
+			ev.StartTime.Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+func (ev *Event) IsGlobal() bool {
+	return len(ev.Areas) == 0
+}
+
+func (ev *Event) IsActive(t time.Time) bool {
+	return !t.Before(ev.StartTime) && t.Before(ev.EndTime)
+}
+
+func (ev *Event) AppliesToNest(nest *models.Nest) bool {
+	if ev.IsGlobal() {
+		return true
+	}
+	return areas.AreaStringToAreaName(nest.AreaName.ValueOrZero()).Matches(ev.areaNames)
+}
+
+// EventCalendar holds the known events. If it has a filename, events
+// are loaded from and saved to that file.
+type EventCalendar struct {
+	logger   *logrus.Logger
+	filename string
+
+	mutex  sync.RWMutex
+	events []*Event
+	nextId int64
+}
+
+// requires calendar.mutex be write locked. Events without an Id, or
+// with the Id of an earlier event, are given a new one. Returns the
+// number of events given a new Id.
+func (calendar *EventCalendar) setEvents(events []*Event) int {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+
+	calendar.nextId = 1
+	for _, ev := range events {
+		ev.areaNames = areas.AreaStringsToAreaNames(ev.Areas)
+		if ev.Id >= calendar.nextId {
+			calendar.nextId = ev.Id + 1
+		}
+	}
+
+	numNewIds := 0
+	seenIds := make(map[int64]struct{}, len(events))
+	for _, ev := range events {
+		if _, ok := seenIds[ev.Id]; ok || ev.Id <= 0 {
+			ev.Id = calendar.nextId
+			calendar.nextId++
+			numNewIds++
+		}
+		seenIds[ev.Id] = struct{}{}
+	}
+
+	calendar.events = events
+
+	return numNewIds
+}
+
+// requires calendar.mutex be locked.
+func (calendar *EventCalendar) save() error {
+	if calendar.filename == "" {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(calendar.filename), filepath.Base(calendar.filename)+".*")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer f.Close()
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(calendar.events); err != nil {
+			return fmt.Errorf("couldn't json encode events: %w", err)
+		}
+		return f.Close()
+	}()
+
+	if err == nil {
+		if err = os.Rename(f.Name(), calendar.filename); err != nil {
+			err = fmt.Errorf("failed to rename tmp events file: %s -> %s: %w", f.Name(), calendar.filename, err)
+		}
+	}
+
+	if err != nil {
+		if unlinkErr := os.Remove(f.Name()); unlinkErr != nil {
+			calendar.logger.Warnf("failed to remove tmpfile '%s': %v", f.Name(), unlinkErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Load loads the events from the file. A missing file is not an error.
+func (calendar *EventCalendar) Load() error {
+	if calendar.filename == "" {
+		return nil
+	}
+
+	var events []*Event
+
+	contents, err := os.ReadFile(calendar.filename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else if err := json.Unmarshal(contents, &events); err != nil {
+		return fmt.Errorf("couldn't decode events file '%s': %w", calendar.filename, err)
+	}
+
+	for _, ev := range events {
+		if err := ev.Validate(); err != nil {
+			return fmt.Errorf("events file '%s': event '%s': %w", calendar.filename, ev.Name, err)
+		}
+	}
+
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+
+	if numNewIds := calendar.setEvents(events); numNewIds > 0 {
+		calendar.logger.Warnf("EVENTS: gave %d event(s) in '%s' without an id or with a duplicate id a new id", numNewIds, calendar.filename)
+		if err := calendar.save(); err != nil {
+			// the new ids still work until the next restart.
+			calendar.logger.Warnf("EVENTS: failed to save the new ids: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (calendar *EventCalendar) Filename() string {
+	return calendar.filename
+}
+
+// GetEvents returns copies of all events, sorted by start time.
+func (calendar *EventCalendar) GetEvents() []Event {
+	calendar.mutex.RLock()
+	defer calendar.mutex.RUnlock()
+
+	events := make([]Event, len(calendar.events))
+	for idx, ev := range calendar.events {
+		events[idx] = *ev
+	}
+	return events
+}
+
+// AddEvent adds a new event, assigning it an Id, and saves the calendar.
+func (calendar *EventCalendar) AddEvent(ev Event) (Event, error) {
+	if err := ev.Validate(); err != nil {
+		return ev, err
+	}
+
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+
+	ev.Id = calendar.nextId
+	events := append(calendar.events[:len(calendar.events):len(calendar.events)], &ev)
+	calendar.setEvents(events)
+
+	return ev, calendar.save()
+}
+
+// UpdateEvent replaces the event with the same Id and saves the calendar.
+// Returns false if the event is unknown.
+func (calendar *EventCalendar) UpdateEvent(ev Event) (bool, error) {
+	if err := ev.Validate(); err != nil {
+		return false, err
+	}
+
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+
+	events := make([]*Event, len(calendar.events))
+	found := false
+	for idx, oldEv := range calendar.events {
+		if oldEv.Id == ev.Id {
+			oldEv = &ev
+			found = true
+		}
+		events[idx] = oldEv
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	calendar.setEvents(events)
+
+	return true, calendar.save()
+}
+
+// RemoveEvent removes an event and saves the calendar. Returns false if
+// the event is unknown.
+func (calendar *EventCalendar) RemoveEvent(eventId int64) (bool, error) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+
+	events := make([]*Event, 0, len(calendar.events))
+	for _, ev := range calendar.events {
+		if ev.Id != eventId {
+			events = append(events, ev)
+		}
+	}
+
+	if len(events) == len(calendar.events) {
+		return false, nil
+	}
+
+	calendar.setEvents(events)
+
+	return true, calendar.save()
+}
+
+// ActiveEvents returns the events active at time 't'.
+func (calendar *EventCalendar) ActiveEvents(t time.Time) []*Event {
+	calendar.mutex.RLock()
+	defer calendar.mutex.RUnlock()
+
+	var active []*Event
+
+	for _, ev := range calendar.events {
+		if ev.StartTime.After(t) {
+			// sorted by start time, so nothing else can be active.
+			break
+		}
+		if ev.IsActive(t) {
+			active = append(active, ev)
+		}
+	}
+
+	return active
+}
+
+// GlobalEventRanges returns the parts of [startTime, endTime) covered
+// by global events, merged so that they do not overlap.
+func (calendar *EventCalendar) GlobalEventRanges(startTime, endTime time.Time) []SkippedRange {
+	calendar.mutex.RLock()
+	defer calendar.mutex.RUnlock()
+
+	var ranges []SkippedRange
+
+	for _, ev := range calendar.events {
+		if !ev.StartTime.Before(endTime) {
+			break
+		}
+		if !ev.IsGlobal() || !ev.EndTime.After(startTime) {
+			continue
+		}
+
+		rangeStart, rangeEnd := ev.StartTime, ev.EndTime
+		if rangeStart.Before(startTime) {
+			rangeStart = startTime
+		}
+		if rangeEnd.After(endTime) {
+			rangeEnd = endTime
+		}
+
+		if l := len(ranges); l > 0 && !rangeStart.After(ranges[l-1].EndTime) {
+			// overlaps the previous one. events are sorted by start.
+			if rangeEnd.After(ranges[l-1].EndTime) {
+				ranges[l-1].EndTime = rangeEnd
+			}
+			ranges[l-1].Reason += ", " + ev.Name
+			continue
+		}
+
+		ranges = append(ranges, SkippedRange{
+			StartTime: rangeStart,
+			EndTime:   rangeEnd,
+			Reason:    "event: " + ev.Name,
+		})
+	}
+
+	return ranges
+}
+
+func NewEventCalendar(logger *logrus.Logger, filename string) *EventCalendar {
+	return &EventCalendar{
+		logger:   logger,
+		filename: filename,
+		nextId:   1,
+	}
+}
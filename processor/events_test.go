@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEventCalendarLoadAssignsIds(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "events.json")
+	contents := `[
+  {"name": "a", "start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T03:00:00Z"},
+  {"id": 5, "name": "b", "start_time": "2024-01-02T00:00:00Z", "end_time": "2024-01-02T03:00:00Z"},
+  {"id": 5, "name": "c", "start_time": "2024-01-03T00:00:00Z", "end_time": "2024-01-03T03:00:00Z"},
+  {"name": "d", "start_time": "2024-01-04T00:00:00Z", "end_time": "2024-01-04T03:00:00Z"}
+]`
+	if err := os.WriteFile(filename, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	calendar := NewEventCalendar(logrus.New(), filename)
+	if err := calendar.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[int64]string)
+	for _, ev := range calendar.GetEvents() {
+		if ev.Id <= 0 {
+			t.Errorf("event '%s' has no id", ev.Name)
+		}
+		if other, ok := ids[ev.Id]; ok {
+			t.Errorf("events '%s' and '%s' share id %d", other, ev.Name, ev.Id)
+		}
+		ids[ev.Id] = ev.Name
+	}
+	if ids[5] != "b" {
+		t.Errorf("the first event with id 5 should keep it, got '%s'", ids[5])
+	}
+
+	// removing one event leaves the others.
+	if ok, err := calendar.RemoveEvent(5); !ok || err != nil {
+		t.Fatalf("RemoveEvent: %t, %v", ok, err)
+	}
+	if l := len(calendar.GetEvents()); l != 3 {
+		t.Fatalf("got %d events after removing one, want 3", l)
+	}
+
+	// the new ids were saved.
+	reloaded := NewEventCalendar(logrus.New(), filename)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for idx, ev := range reloaded.GetEvents() {
+		if want := calendar.GetEvents()[idx].Id; ev.Id != want {
+			t.Errorf("event '%s': id %d after reload, want %d", ev.Name, ev.Id, want)
+		}
+	}
+}
+
+func TestEventCalendarSaveLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	calendar := NewEventCalendar(logrus.New(), filepath.Join(dir, "events.json"))
+	if err := calendar.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ev := Event{Name: "cd", StartTime: start, EndTime: start.Add(3 * time.Hour)}
+	if _, err := calendar.AddEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "events.json" {
+		t.Fatalf("unexpected files after save: %v", entries)
+	}
+}
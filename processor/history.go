@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/UnownHash/Fletchling/db_store"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// nestHistoryStats returns the stats to store with a nest history
+// entry or nil if there are no stats, which happens when the nesting
+// pokemon was only loaded from the DB.
+func nestHistoryStats(ni *models.NestingPokemonInfo) *db_store.NestHistoryStats {
+	if ni == nil || ni.NestTotal == 0 {
+		return nil
+	}
+	return &db_store.NestHistoryStats{
+		PokemonAvg:   null.FloatFrom(ni.NestHourlyCount),
+		PokemonRatio: null.FloatFrom(ni.NestPct()),
+		PokemonCount: null.FloatFrom(float64(ni.NestCount)),
+	}
+}
+
+// startNestHistory records that 'ni' started nesting, ending the
+// history for 'old_ni', if any.
+func (np *NestProcessor) startNestHistory(ctx context.Context, nest *models.Nest, ni, old_ni *models.NestingPokemonInfo) {
+	if np.dryRun {
+		return
+	}
+
+	history := &db_store.NestHistory{
+		NestId:       nest.Id,
+		PokemonId:    ni.PokemonKey.PokemonId,
+		PokemonForm:  ni.PokemonKey.FormId,
+		DetectedAt:   ni.DetectedAt.Unix(),
+		PokemonAvg:   null.FloatFrom(ni.NestHourlyCount),
+		PokemonRatio: null.FloatFrom(ni.NestPct()),
+		PokemonCount: null.FloatFrom(float64(ni.NestCount)),
+	}
+
+	if err := np.nestsDBStore.StartNestHistory(ctx, history, nestHistoryStats(old_ni)); err != nil {
+		np.logger.Errorf("PROCESSOR[%s]: failed to add nest history: %v", nest, err)
+	}
+}
+
+// endNestHistory records that 'old_ni' stopped nesting at 'endedAt'.
+func (np *NestProcessor) endNestHistory(ctx context.Context, nest *models.Nest, old_ni *models.NestingPokemonInfo, endedAt time.Time) {
+	if np.dryRun {
+		return
+	}
+
+	if err := np.nestsDBStore.EndNestHistory(ctx, nest.Id, endedAt.Unix(), nestHistoryStats(old_ni)); err != nil {
+		np.logger.Errorf("PROCESSOR[%s]: failed to end nest history: %v", nest, err)
+	}
+}
+
+// GetNestHistory returns the nesting history for a nest, newest first.
+func (np *NestProcessor) GetNestHistory(ctx context.Context, nestId int64, limit int) ([]*db_store.NestHistory, error) {
+	return np.nestsDBStore.GetNestHistory(ctx, nestId, limit)
+}
+
+// GetPokemonHistory returns where a pokemon has nested since 'since', newest first.
+func (np *NestProcessor) GetPokemonHistory(ctx context.Context, pokemonId int, formId *int, since time.Time, limit int) ([]*db_store.NestHistory, error) {
+	return np.nestsDBStore.GetPokemonHistory(ctx, pokemonId, formId, since.Unix(), limit)
+}
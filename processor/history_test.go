@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestNestHistoryStats(t *testing.T) {
+	tests := []struct {
+		name string
+		ni   *models.NestingPokemonInfo
+		// nil means no stats.
+		want []float64
+	}{
+		{
+			name: "no nesting pokemon",
+		},
+		{
+			name: "loaded from the db",
+			ni: &models.NestingPokemonInfo{
+				PokemonKey: models.PokemonKey{PokemonId: 25},
+			},
+		},
+		{
+			name: "counted",
+			ni: &models.NestingPokemonInfo{
+				PokemonKey:      models.PokemonKey{PokemonId: 25},
+				NestCount:       30,
+				NestTotal:       120,
+				NestHourlyCount: 7.5,
+			},
+			want: []float64{7.5, 25, 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := nestHistoryStats(tt.ni)
+			if tt.want == nil {
+				if stats != nil {
+					t.Errorf("got stats %+v, want none", stats)
+				}
+				return
+			}
+			if stats == nil {
+				t.Fatalf("got no stats, want %v", tt.want)
+			}
+			got := []float64{stats.PokemonAvg.Float64, stats.PokemonRatio.Float64, stats.PokemonCount.Float64}
+			if !stats.PokemonAvg.Valid || !stats.PokemonRatio.Valid || !stats.PokemonCount.Valid || got[0] != tt.want[0] || got[1] != tt.want[1] || got[2] != tt.want[2] {
+				t.Errorf("got avg, ratio and count %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
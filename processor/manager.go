@@ -9,8 +9,10 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/UnownHash/Fletchling/areas"
 	"github.com/UnownHash/Fletchling/db_store"
 	"github.com/UnownHash/Fletchling/koji_client"
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/models"
 	"github.com/UnownHash/Fletchling/stats_collector"
 )
@@ -34,6 +36,12 @@ type NestProcessorManagerConfig struct {
 	NestingPokemonURL string
 	StatsCollector    stats_collector.StatsCollector
 	WebhookSender     WebhookSender
+	// AreasLoader provides the area fences for area_baselines. Optional.
+	AreasLoader *areas.AreasLoader
+	// Clock defaults to the real clock.
+	Clock clock.Clock
+	// DryRun disables all writes to the nests DB.
+	DryRun bool
 }
 
 type NestProcessorManager struct {
@@ -45,12 +53,16 @@ type NestProcessorManager struct {
 	kojiProjectName string
 	statsCollector  stats_collector.StatsCollector
 	webhookSender   WebhookSender
+	clock           clock.Clock
+	dryRun          bool
+	areasLoader     *areas.AreasLoader
 
 	reloadCh    chan struct{}
 	reloadMutex sync.Mutex
 	config      Config
 
 	pokemonProcessedCount atomic.Uint64
+	pokemonDuplicateCount atomic.Uint64
 	nestsMatchedCount     atomic.Uint64
 
 	nestProcessorMutex sync.Mutex
@@ -84,6 +96,11 @@ func (mgr *NestProcessorManager) GetNests() []*models.Nest {
 func (mgr *NestProcessorManager) ProcessPokemon(pokemon *models.Pokemon) {
 	resp := mgr.GetNestProcessor().AddPokemon(pokemon)
 	mgr.pokemonProcessedCount.Add(1)
+	if resp.IsDuplicate {
+		mgr.pokemonDuplicateCount.Add(1)
+		mgr.statsCollector.AddPokemonDuplicate(1)
+		return
+	}
 	// webhook handler adds pokemon processed to statsCollector.
 	mgr.nestsMatchedCount.Add(resp.NumNestsMatched)
 	mgr.statsCollector.AddNestsMatched(resp.NumNestsMatched)
@@ -101,7 +118,119 @@ func (mgr *NestProcessorManager) processStats(ctx context.Context, nestProcessor
 	}
 }
 
-// Run runs the processor until `ctx` is cancelled. One must load
+// RotateAndProcessStats rotates the stats and processes them before
+// returning. This is for tools that drive the manager themselves, like
+// replaying recorded webhooks, instead of calling Run().
+func (mgr *NestProcessorManager) RotateAndProcessStats() {
+	nestProcessor := mgr.GetNestProcessor()
+	if statsCollection := nestProcessor.RotateStats(); statsCollection != nil {
+		nestProcessor.ProcessStatsCollection(statsCollection)
+	}
+}
+
+func (mgr *NestProcessorManager) saveStats(nestProcessor *NestProcessor) {
+	if nestProcessor.config.StatsFilename == "" {
+		return
+	}
+	start := mgr.clock.Now()
+	if err := nestProcessor.SaveStats(); err != nil {
+		mgr.logger.Errorf("PROCESSOR: failed to save stats to '%s': %v", nestProcessor.config.StatsFilename, err)
+		return
+	}
+	mgr.logger.Debugf("PROCESSOR: saved stats to '%s' in %s", nestProcessor.config.StatsFilename, mgr.clock.Now().Sub(start).Truncate(time.Millisecond))
+}
+
+// buildSpawnpointIndex builds the spawnpoint index for the nests in
+// 'nestMatcher'. Returns nil if there is no golbat DB to build it from.
+func (mgr *NestProcessorManager) buildSpawnpointIndex(ctx context.Context, nestMatcher *NestMatcher, config Config) (*SpawnpointIndex, error) {
+	if mgr.golbatDBStore == nil {
+		mgr.logger.Warnf("SPAWNPOINT-INDEX: spawnpoint_index requires golbat_db to be configured. Matching pokemon by location only.")
+		return nil, nil
+	}
+
+	start := mgr.clock.Now()
+	spawnpointIndex, err := BuildSpawnpointIndex(ctx, mgr.golbatDBStore, nestMatcher, config.SpawnpointIndexMaxAgeDays, start)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr.logger.Infof("SPAWNPOINT-INDEX: indexed %d spawnpoint(s), %d in nests, in %s",
+		spawnpointIndex.Len(),
+		spawnpointIndex.NumInNests(),
+		mgr.clock.Now().Sub(start).Truncate(time.Millisecond),
+	)
+
+	return spawnpointIndex, nil
+}
+
+// refreshSpawnpointIndex rebuilds the spawnpoint index to pick up new
+// spawnpoints. The old index is kept if this fails.
+func (mgr *NestProcessorManager) refreshSpawnpointIndex(ctx context.Context) {
+	// a reload builds its own index for its own nests.
+	mgr.reloadMutex.Lock()
+	defer mgr.reloadMutex.Unlock()
+
+	nestProcessor := mgr.GetNestProcessor()
+	if !nestProcessor.config.SpawnpointIndex {
+		return
+	}
+
+	spawnpointIndex, err := mgr.buildSpawnpointIndex(ctx, nestProcessor.nestMatcher, nestProcessor.config)
+	if err != nil {
+		mgr.logger.Errorf("SPAWNPOINT-INDEX: failed to refresh: %v", err)
+		return
+	}
+
+	nestProcessor.SetSpawnpointIndex(spawnpointIndex)
+}
+
+// loadAreaFences adds the fences of the nests' areas to 'nestMatcher'
+// for area baselines. Nests in areas without a fence are compared
+// against the global spawns.
+func (mgr *NestProcessorManager) loadAreaFences(ctx context.Context, nestMatcher *NestMatcher) {
+	if mgr.areasLoader == nil {
+		mgr.logger.Warnf("NEST-LOAD[]: area_baselines requires areas to be configured. Using the global spawns for all nests.")
+		return
+	}
+
+	if err := mgr.areasLoader.ReloadAreas(ctx); err != nil {
+		mgr.logger.Warnf("NEST-LOAD[]: failed to reload areas, using the previous ones: %v", err)
+	}
+
+	nestAreaNames := make(map[string]bool)
+	for _, areaName := range nestMatcher.GetNestAreaNames() {
+		nestAreaNames[areaName] = false
+	}
+
+	for _, feature := range mgr.areasLoader.GetAllAreas(ctx) {
+		parent, _ := feature.Properties["parent"].(string)
+		name, _ := feature.Properties["name"].(string)
+		if name == "" {
+			continue
+		}
+
+		areaName := areas.NewAreaName(parent, name).String()
+		if _, ok := nestAreaNames[areaName]; !ok {
+			// no nests to compare against it.
+			continue
+		}
+
+		if err := nestMatcher.AddArea(areaName, feature.Geometry); err != nil {
+			mgr.logger.Warnf("NEST-LOAD[]: skipping fence for area '%s': %v", areaName, err)
+			continue
+		}
+		nestAreaNames[areaName] = true
+	}
+
+	for _, areaName := range nestMatcher.GetNestAreaNames() {
+		if !nestAreaNames[areaName] {
+			mgr.logger.Warnf("NEST-LOAD[]: no fence found for area '%s'. Its nests will use the global spawns.", areaName)
+		}
+	}
+}
+
+// Run runs the processor until `ctx` is cancelled. Stats are saved
+// when `ctx` is cancelled, if a stats file is configured. One must load
 // a config via LoadConfig() before calling Run().
 func (mgr *NestProcessorManager) Run(ctx context.Context) {
 	nestProcessor := mgr.GetNestProcessor()
@@ -110,21 +239,80 @@ func (mgr *NestProcessorManager) Run(ctx context.Context) {
 	}
 
 	statsTimerStopped := false
-	rotationInterval := nestProcessor.config.RotationInterval()
-	statsTimerStart := time.Now()
-	statsTimer := time.NewTimer(rotationInterval)
+	rotationSchedule := nestProcessor.config.rotationSchedule()
+	lastRotation := mgr.clock.Now()
+	nextRotation := nestProcessor.config.NextRotationAfter(lastRotation)
+	statsTimer := mgr.clock.NewTimer(nextRotation.Sub(lastRotation))
+	if nestProcessor.config.AlignRotation {
+		// the stats started at an arbitrary time, so the first time
+		// period only runs until the first boundary.
+		mgr.logger.Infof("PROCESSOR: first stats time period is partial. Rotating at %s (in %s), then every %s",
+			nextRotation.Format(time.RFC3339),
+			nextRotation.Sub(lastRotation).Truncate(time.Second),
+			nestProcessor.config.RotationInterval(),
+		)
+	}
 	defer func() {
 		if !statsTimerStopped && !statsTimer.Stop() {
-			<-statsTimer.C
+			<-statsTimer.C()
+		}
+	}()
+
+	saveTimerStopped := false
+	saveTimer := mgr.clock.NewTimer(nestProcessor.config.StatsSaveInterval())
+	defer func() {
+		if !saveTimerStopped && !saveTimer.Stop() {
+			<-saveTimer.C()
+		}
+	}()
+
+	indexTimerStopped := false
+	indexTimer := mgr.clock.NewTimer(nestProcessor.config.SpawnpointIndexRefreshInterval())
+	defer func() {
+		if !indexTimerStopped && !indexTimer.Stop() {
+			<-indexTimer.C()
 		}
 	}()
 
 	logTimerStopped := false
 	logInterval := time.Minute
-	logTimer := time.NewTimer(logInterval)
+	logTimer := mgr.clock.NewTimer(logInterval)
 	defer func() {
 		if !logTimerStopped && !logTimer.Stop() {
-			<-logTimer.C
+			<-logTimer.C()
+		}
+	}()
+
+	// migrationCh is nil, and never fires, when there's no
+	// migration schedule configured.
+	var migrationTimer clock.Timer
+	var migrationCh <-chan time.Time
+	var nextMigration time.Time
+
+	scheduleMigration := func(cfg Config) {
+		now := mgr.clock.Now()
+		next := cfg.NextMigrationAfter(now)
+		if next.Equal(nextMigration) {
+			return
+		}
+		if migrationTimer != nil {
+			// this timer is being thrown away, so it doesn't need draining.
+			migrationTimer.Stop()
+			migrationTimer, migrationCh = nil, nil
+		}
+		nextMigration = next
+		if next.IsZero() {
+			return
+		}
+		migrationTimer = mgr.clock.NewTimer(next.Sub(now))
+		migrationCh = migrationTimer.C()
+		mgr.logger.Infof("PROCESSOR: next nest migration is at %s", next.Format(time.RFC3339))
+	}
+
+	scheduleMigration(nestProcessor.config)
+	defer func() {
+		if migrationTimer != nil {
+			migrationTimer.Stop()
 		}
 	}()
 
@@ -137,45 +325,67 @@ func (mgr *NestProcessorManager) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
+			mgr.saveStats(mgr.GetNestProcessor())
 			return
 		case <-mgr.reloadCh:
 			nestProcessor = mgr.GetNestProcessor()
-			if newInterval := nestProcessor.config.RotationInterval(); newInterval != rotationInterval {
-				mgr.logger.Infof("RELOAD: processing interval changed from %s to %s",
-					rotationInterval, newInterval,
+			scheduleMigration(nestProcessor.config)
+			if newSchedule := nestProcessor.config.rotationSchedule(); newSchedule != rotationSchedule {
+				mgr.logger.Infof("RELOAD: processing schedule changed from %s to %s",
+					rotationSchedule, newSchedule,
 				)
-				rotationInterval = newInterval
-				passed := time.Now().Sub(statsTimerStart)
+				rotationSchedule = newSchedule
 				statsTime := false
 				// since timer hasn't fired, we have to stop first.
 				if !statsTimer.Stop() {
 					// we raced.
-					<-statsTimer.C
+					<-statsTimer.C()
 					statsTime = true
 				}
 				statsTimerStopped = true
-				if statsTime || passed > rotationInterval {
+				now := mgr.clock.Now()
+				nextRotation = nestProcessor.config.NextRotationAfter(lastRotation)
+				if statsTime || !nextRotation.After(now) {
 					mgr.logger.Infof("RELOAD: processing time hit during reload. Will process stats now.")
 					mgr.processStats(ctx, nestProcessor)
-					passed = 0
-					statsTimerStart = time.Now()
+					lastRotation = now
+					nextRotation = nestProcessor.config.NextRotationAfter(lastRotation)
 				}
-				statsTimer.Reset(rotationInterval - passed)
+				statsTimer.Reset(nextRotation.Sub(now))
 				statsTimerStopped = false
-				mgr.logger.Infof("RELOAD: next processing time set for %s from now", (rotationInterval - passed).Truncate(time.Second))
+				mgr.logger.Infof("RELOAD: next processing time set for %s from now", nextRotation.Sub(now).Truncate(time.Second))
 			}
-		case <-logTimer.C:
+		case <-logTimer.C():
 			logTimerStopped = true
 			pokemonCnt := mgr.pokemonProcessedCount.Swap(0)
+			duplicateCnt := mgr.pokemonDuplicateCount.Swap(0)
 			nestsCnt := mgr.nestsMatchedCount.Swap(0)
-			mgr.logger.Infof("PROCESSOR: last minute: processed %d pokemon, matched %d nest(s)", pokemonCnt, nestsCnt)
+			mgr.logger.Infof("PROCESSOR: last minute: processed %d pokemon (%d duplicate), matched %d nest(s)", pokemonCnt, duplicateCnt, nestsCnt)
 			logTimer.Reset(logInterval)
 			logTimerStopped = false
-		case <-statsTimer.C:
+		case <-migrationCh:
+			migrationTimer, migrationCh, nextMigration = nil, nil, time.Time{}
+			mgr.logger.Infof("MIGRATION: nest migration time reached")
+			nestProcessor.HandleMigration(ctx)
+			scheduleMigration(nestProcessor.config)
+		case <-saveTimer.C():
+			saveTimerStopped = true
+			mgr.saveStats(nestProcessor)
+			// picks up any interval change from a reload.
+			saveTimer.Reset(nestProcessor.config.StatsSaveInterval())
+			saveTimerStopped = false
+		case <-indexTimer.C():
+			indexTimerStopped = true
+			mgr.refreshSpawnpointIndex(ctx)
+			// picks up any interval change from a reload.
+			indexTimer.Reset(mgr.GetNestProcessor().config.SpawnpointIndexRefreshInterval())
+			indexTimerStopped = false
+		case <-statsTimer.C():
 			statsTimerStopped = true
 			mgr.processStats(ctx, nestProcessor)
-			statsTimerStart = time.Now()
-			statsTimer.Reset(rotationInterval)
+			lastRotation = mgr.clock.Now()
+			nextRotation = nestProcessor.config.NextRotationAfter(lastRotation)
+			statsTimer.Reset(nextRotation.Sub(lastRotation))
 			statsTimerStopped = false
 		}
 	}
@@ -205,11 +415,18 @@ func (mgr *NestProcessorManager) LoadConfig(ctx context.Context, config Config)
 
 	mgr.logger.Infof("NEST-LOAD[]: Loaded %d active nest(s) from the DB", len(dbNests))
 
-	nestMatcher := NewNestMatcher(mgr.logger)
+	nestMatcher := NewNestMatcher(mgr.logger, config.NestMatchingPolicy)
 	curNestProcessor := mgr.nestProcessor
 
+	if curNestProcessor != nil && curNestProcessor.config.NestMatchingPolicy != config.NestMatchingPolicy {
+		mgr.logger.Warnf("RELOAD: nest_matching_policy changed from '%s' to '%s'. Stats already counted keep the old policy until they age out or are purged.",
+			curNestProcessor.config.NestMatchingPolicy,
+			config.NestMatchingPolicy,
+		)
+	}
+
 	for _, dbNest := range dbNests {
-		nest, err := models.NewNestFromDBStore(dbNest)
+		nest, err := models.NewNestFromDBStore(dbNest, mgr.clock)
 		if err != nil {
 			mgr.logger.Warnf("NEST-LOAD[%s]: skipping nest id '%d': %v", dbNest.Name, dbNest.NestId, err)
 			continue
@@ -224,6 +441,12 @@ func (mgr *NestProcessorManager) LoadConfig(ctx context.Context, config Config)
 
 		fullName := nest.FullName()
 
+		if bufferMeters := config.ForNest(nest).NestBufferMeters; bufferMeters != 0 {
+			if err := nest.SetBufferMeters(bufferMeters); err != nil {
+				mgr.logger.Warnf("NEST-LOAD[%s]: ignoring buffer of %0.1f meter(s): %v", fullName, bufferMeters, err)
+			}
+		}
+
 		if err := nestMatcher.AddNest(nest); err != nil {
 			mgr.logger.Warnf("NEST-LOAD[%s]: Failed to add nest to matcher: %v", fullName, err)
 			continue
@@ -237,12 +460,34 @@ func (mgr *NestProcessorManager) LoadConfig(ctx context.Context, config Config)
 			spawnpointsStr = fmt.Sprintf("%d spawnpoint(s)", *nest.Spawnpoints)
 		}
 
-		mgr.logger.Infof("NEST-LOAD[%s]: Nest loaded with %s covering %0.3f meters squared", fullName, spawnpointsStr, nest.AreaM2)
+		var bufferStr string
+
+		if nest.BufferMeters != 0 {
+			bufferStr = fmt.Sprintf(" (matching with a %0.1f meter buffer)", nest.BufferMeters)
+		}
+
+		mgr.logger.Infof("NEST-LOAD[%s]: Nest loaded with %s covering %0.3f meters squared%s", fullName, spawnpointsStr, nest.AreaM2, bufferStr)
 	}
 
-	nestProcessor := NewNestProcessor(mgr.nestProcessor, mgr.logger, mgr.nestsDBStore, nestMatcher, mgr.webhookSender, config)
+	if config.AreaBaselines {
+		mgr.loadAreaFences(ctx, nestMatcher)
+	}
+
+	nestProcessor, err := NewNestProcessor(mgr.nestProcessor, mgr.logger, mgr.clock, mgr.nestsDBStore, mgr.dryRun, nestMatcher, mgr.webhookSender, mgr.statsCollector, config)
+	if err != nil {
+		return fmt.Errorf("failed to create nest processor: %w", err)
+	}
 	nestProcessor.LogConfiguration("Config loaded: ", nestMatcher.Len())
 
+	if config.SpawnpointIndex {
+		spawnpointIndex, err := mgr.buildSpawnpointIndex(ctx, nestMatcher, config)
+		if err != nil {
+			// pokemon are still matched by location without it.
+			mgr.logger.Errorf("SPAWNPOINT-INDEX: failed to build: %v", err)
+		}
+		nestProcessor.SetSpawnpointIndex(spawnpointIndex)
+	}
+
 	// now we can swap in the new state
 	mgr.nestProcessorMutex.Lock()
 	defer mgr.nestProcessorMutex.Unlock()
@@ -270,6 +515,9 @@ func NewNestProcessorManager(config NestProcessorManagerConfig) (*NestProcessorM
 		nestLoader:      config.NestLoader,
 		statsCollector:  config.StatsCollector,
 		webhookSender:   config.WebhookSender,
+		clock:           clock.OrReal(config.Clock),
+		dryRun:          config.DryRun,
+		areasLoader:     config.AreasLoader,
 		reloadCh:        make(chan struct{}, 1),
 	}
 	return mgr, nil
@@ -2,17 +2,36 @@ package processor
 
 import (
 	"fmt"
+	"math"
+	"sort"
 
+	"github.com/paulmach/orb"
 	"github.com/sirupsen/logrus"
 
 	"github.com/UnownHash/Fletchling/geo"
 	"github.com/UnownHash/Fletchling/processor/models"
 )
 
+const (
+	// count a pokemon in every nest containing it.
+	NEST_MATCHING_POLICY_ALL = "all"
+	// count a pokemon only in the smallest nest containing it.
+	NEST_MATCHING_POLICY_SMALLEST = "smallest"
+	// count a pokemon in one of the nests containing it, picked
+	// pseudo-randomly from the pokemon and weighted towards smaller
+	// nests. The counts of each nest then come out weighted by 1/area.
+	NEST_MATCHING_POLICY_AREA_WEIGHTED = "area_weighted"
+)
+
 type NestMatcher struct {
 	logger     *logrus.Logger
+	policy     string
 	nestsRtree *geo.FenceRTree[*models.Nest]
 	nests      map[int64]*models.Nest
+	// fences of the areas, keyed by area name.
+	areasRtree *geo.FenceRTree[string]
+	// bounding box of all nests.
+	bound orb.Bound
 }
 
 // GetMatchingNests returns nests that contain the given lat, lon. There is no locking.
@@ -21,6 +40,120 @@ func (matcher *NestMatcher) GetMatchingNests(lat, lon float64) []*models.Nest {
 	return matcher.nestsRtree.GetMatches(lat, lon)
 }
 
+// ApplyPolicy reduces 'nests', which all contain 'pokemon', to the ones
+// it is counted in according to the matching policy. For the area
+// weighted policy, the nest is picked from the pokemon itself, so that
+// the same pokemon always goes to the same nest. See pokemonPickKey().
+func (matcher *NestMatcher) ApplyPolicy(nests []*models.Nest, pokemon *models.Pokemon) []*models.Nest {
+	if len(nests) < 2 {
+		return nests
+	}
+
+	switch matcher.policy {
+	case NEST_MATCHING_POLICY_SMALLEST:
+		smallest := nests[0]
+		for _, nest := range nests[1:] {
+			if nestIsSmaller(nest, smallest) {
+				smallest = nest
+			}
+		}
+		return []*models.Nest{smallest}
+	case NEST_MATCHING_POLICY_AREA_WEIGHTED:
+		return []*models.Nest{pickAreaWeightedNest(nests, pokemonPickKey(pokemon))}
+	default:
+		return nests
+	}
+}
+
+// nestIsSmaller returns true if 'nest' is smaller than 'other'. Nests
+// with an unknown area are the largest. Ties go to the lower nest id.
+func nestIsSmaller(nest, other *models.Nest) bool {
+	area, otherArea := nest.AreaM2, other.AreaM2
+	switch {
+	case area > 0 && otherArea > 0 && area != otherArea:
+		return area < otherArea
+	case area > 0 && otherArea <= 0:
+		return true
+	case area <= 0 && otherArea > 0:
+		return false
+	default:
+		return nest.Id < other.Id
+	}
+}
+
+// pokemonPickKey returns the value that picks the nest for the area
+// weighted policy. It is the encounter id or, without one (lured pokemon
+// or bad ids), made from the spawnpoint, location and despawn time. It
+// never depends on anything else, so that replays and evaluations count
+// the same pokemon in the same nest.
+func pokemonPickKey(pokemon *models.Pokemon) uint64 {
+	if pokemon.EncounterId != 0 {
+		return pokemon.EncounterId
+	}
+	key := mixBits(pokemon.SpawnpointId)
+	key = mixBits(key ^ math.Float64bits(pokemon.Lat))
+	key = mixBits(key ^ math.Float64bits(pokemon.Lon))
+	if !pokemon.DisappearTime.IsZero() {
+		key = mixBits(key ^ uint64(pokemon.DisappearTime.Unix()))
+	}
+	return key
+}
+
+// pickAreaWeightedNest picks one of 'nests' with a chance proportional
+// to 1/area, using 'key' as the random value. Nests with an unknown area
+// are weighted like the largest known one.
+func pickAreaWeightedNest(nests []*models.Nest, key uint64) *models.Nest {
+	var maxArea float64
+	for _, nest := range nests {
+		maxArea = max(maxArea, nest.AreaM2)
+	}
+	if maxArea <= 0 {
+		maxArea = 1
+	}
+
+	weights := make([]float64, len(nests))
+	var totalWeight float64
+	for idx, nest := range nests {
+		area := nest.AreaM2
+		if area <= 0 {
+			area = maxArea
+		}
+		weights[idx] = 1 / area
+		totalWeight += weights[idx]
+	}
+
+	r := float64(mixBits(key)>>11) / (1 << 53)
+
+	// nests from the rtree are in no particular order.
+	order := make([]int, len(nests))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.Slice(order, func(i, j int) bool { return nests[order[i]].Id < nests[order[j]].Id })
+
+	r *= totalWeight
+	for _, idx := range order {
+		if r < weights[idx] {
+			return nests[idx]
+		}
+		r -= weights[idx]
+	}
+
+	// rounding.
+	return nests[order[len(order)-1]]
+}
+
+// mixBits spreads the bits of 'v' so that ids that are close together
+// don't pick the same nest (splitmix64 finalizer).
+func mixBits(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
 // AddNest stores a nest for later matching by lat/lon. There is no locking. If a
 // nest exists already with the same Id, an error will be returned.
 func (matcher *NestMatcher) AddNest(nest *models.Nest) error {
@@ -28,16 +161,71 @@ func (matcher *NestMatcher) AddNest(nest *models.Nest) error {
 		return fmt.Errorf("nest with id '%d' already exists", nest.Id)
 	}
 
-	err := matcher.nestsRtree.InsertGeometry(nest.Geometry.Geometry(), nest)
+	geometry := nest.MatchingGeometry().Geometry()
+
+	err := matcher.nestsRtree.InsertGeometry(geometry, nest)
 	if err != nil {
 		return err
 	}
 
+	if len(matcher.nests) == 0 {
+		matcher.bound = geometry.Bound()
+	} else {
+		matcher.bound = matcher.bound.Union(geometry.Bound())
+	}
+
 	matcher.nests[nest.Id] = nest
 
 	return nil
 }
 
+// AddArea stores the fence of an area for GetMatchingAreas. 'areaName'
+// should be in the same form as the nests' AreaName. There is no locking.
+func (matcher *NestMatcher) AddArea(areaName string, geometry orb.Geometry) error {
+	return matcher.areasRtree.InsertGeometry(geometry, areaName)
+}
+
+// GetMatchingAreas returns the names of the areas whose fences contain
+// the given lat, lon. There is no locking.
+func (matcher *NestMatcher) GetMatchingAreas(lat, lon float64) []string {
+	return matcher.areasRtree.GetMatches(lat, lon)
+}
+
+// GetNestAreaNames returns the distinct area names of the nests, sorted.
+// There is no locking.
+func (matcher *NestMatcher) GetNestAreaNames() []string {
+	seen := make(map[string]bool)
+	var areaNames []string
+	for _, nest := range matcher.nests {
+		areaName := nest.AreaName.ValueOrZero()
+		if areaName == "" || seen[areaName] {
+			continue
+		}
+		seen[areaName] = true
+		areaNames = append(areaNames, areaName)
+	}
+	sort.Strings(areaNames)
+	return areaNames
+}
+
+// Bound returns the bounding box of all nests, or the zero Bound if
+// there are none.
+func (matcher *NestMatcher) Bound() orb.Bound {
+	return matcher.bound
+}
+
+// GetNestsByIds returns the nests with the given ids, skipping any that
+// are unknown. There is no locking.
+func (matcher *NestMatcher) GetNestsByIds(nestIds []int64) []*models.Nest {
+	nests := make([]*models.Nest, 0, len(nestIds))
+	for _, nestId := range nestIds {
+		if nest := matcher.nests[nestId]; nest != nil {
+			nests = append(nests, nest)
+		}
+	}
+	return nests
+}
+
 func (matcher *NestMatcher) Len() int {
 	return len(matcher.nests)
 }
@@ -58,11 +246,15 @@ func (matcher *NestMatcher) GetAllNests() []*models.Nest {
 	return nests
 }
 
-func NewNestMatcher(logger *logrus.Logger) *NestMatcher {
+// NewNestMatcher creates a NestMatcher using the matching 'policy'. ""
+// means NEST_MATCHING_POLICY_ALL.
+func NewNestMatcher(logger *logrus.Logger, policy string) *NestMatcher {
 	matcher := &NestMatcher{
 		logger:     logger,
+		policy:     policy,
 		nestsRtree: geo.NewFenceRTree[*models.Nest](),
 		nests:      make(map[int64]*models.Nest),
+		areasRtree: geo.NewFenceRTree[string](),
 	}
 	return matcher
 }
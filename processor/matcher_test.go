@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestNestMatcherGetMatchingAreas(t *testing.T) {
+	matcher := NewNestMatcher(logrus.New(), "")
+
+	// an L-shaped area whose bounding box covers all of the square one.
+	lShape := orb.Polygon{{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}, {0, 0}}}
+	square := orb.Polygon{{{1.2, 1.2}, {1.8, 1.2}, {1.8, 1.8}, {1.2, 1.8}, {1.2, 1.2}}}
+
+	if err := matcher.AddArea("London/Harrow", lShape); err != nil {
+		t.Fatal(err)
+	}
+	if err := matcher.AddArea("London/Chelsea", square); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     []string
+	}{
+		{"inside L", 0.5, 0.5, []string{"London/Harrow"}},
+		{"inside square", 1.5, 1.5, []string{"London/Chelsea"}},
+		{"in L bounding box only", 1.9, 1.1, nil},
+		{"outside everything", 3, 3, nil},
+	}
+
+	for _, tt := range tests {
+		got := matcher.GetMatchingAreas(tt.lat, tt.lon)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got areas %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for idx := range got {
+			if got[idx] != tt.want[idx] {
+				t.Errorf("%s: got areas %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func nestIds(nests []*models.Nest) []int64 {
+	ids := make([]int64, len(nests))
+	for idx, nest := range nests {
+		ids[idx] = nest.Id
+	}
+	return ids
+}
+
+func TestNestMatcherApplyPolicy(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	// nests with the given areas. 0 is unknown.
+	testNests := func(areas ...float64) []*models.Nest {
+		nests := make([]*models.Nest, len(areas))
+		for idx, area := range areas {
+			nests[idx] = newTestNest(t, clk, int64(idx+1), 10, 10, 0.01)
+			nests[idx].AreaM2 = area
+		}
+		return nests
+	}
+
+	pokemon := &models.Pokemon{EncounterId: 12345}
+
+	tests := []struct {
+		desc   string
+		policy string
+		nests  []*models.Nest
+		want   []int64
+	}{
+		{"all: every match", NEST_MATCHING_POLICY_ALL, testNests(100, 50, 200), []int64{1, 2, 3}},
+		{"smallest: smallest area", NEST_MATCHING_POLICY_SMALLEST, testNests(100, 50, 200), []int64{2}},
+		{"smallest: tie goes to the lower id", NEST_MATCHING_POLICY_SMALLEST, testNests(100, 50, 50), []int64{2}},
+		{"smallest: unknown area is largest", NEST_MATCHING_POLICY_SMALLEST, testNests(0, 500), []int64{2}},
+		{"smallest: all unknown goes to the lower id", NEST_MATCHING_POLICY_SMALLEST, testNests(0, 0), []int64{1}},
+		{"smallest: one nest", NEST_MATCHING_POLICY_SMALLEST, testNests(100), []int64{1}},
+		{"area_weighted: one nest", NEST_MATCHING_POLICY_AREA_WEIGHTED, testNests(100), []int64{1}},
+		{"area_weighted: no nests", NEST_MATCHING_POLICY_AREA_WEIGHTED, testNests(), []int64{}},
+	}
+
+	for _, test := range tests {
+		matcher := NewNestMatcher(newTestLogger(), test.policy)
+		got := nestIds(matcher.ApplyPolicy(test.nests, pokemon))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got nests %v, want %v", test.desc, got, test.want)
+			continue
+		}
+		for idx := range got {
+			if got[idx] != test.want[idx] {
+				t.Errorf("%s: got nests %v, want %v", test.desc, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNestMatcherApplyPolicyAreaWeighted(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	small := newTestNest(t, clk, 1, 10, 10, 0.01)
+	small.AreaM2 = 100
+	large := newTestNest(t, clk, 2, 10, 10, 0.01)
+	large.AreaM2 = 300
+	unknown := newTestNest(t, clk, 3, 10, 10, 0.01)
+	unknown.AreaM2 = 0
+
+	matcher := NewNestMatcher(newTestLogger(), NEST_MATCHING_POLICY_AREA_WEIGHTED)
+
+	pick := func(nests []*models.Nest, pokemon *models.Pokemon) int64 {
+		t.Helper()
+		picked := matcher.ApplyPolicy(nests, pokemon)
+		if len(picked) != 1 {
+			t.Fatalf("got %d nests, want 1", len(picked))
+		}
+		return picked[0].Id
+	}
+
+	// shares come out as 1/area: 3/4 and 1/4, then with the unknown nest
+	// weighted like the large one, 3/5, 1/5 and 1/5.
+	shareTests := []struct {
+		nests      []*models.Nest
+		wantShares map[int64]float64
+	}{
+		{[]*models.Nest{small, large}, map[int64]float64{1: 0.75, 2: 0.25}},
+		{[]*models.Nest{small, large, unknown}, map[int64]float64{1: 0.6, 2: 0.2, 3: 0.2}},
+	}
+
+	const numPokemon = 100000
+
+	for _, test := range shareTests {
+		counts := make(map[int64]int)
+		for encounterId := uint64(1); encounterId <= numPokemon; encounterId++ {
+			counts[pick(test.nests, &models.Pokemon{EncounterId: encounterId})]++
+		}
+		for nestId, wantShare := range test.wantShares {
+			if share := float64(counts[nestId]) / numPokemon; math.Abs(share-wantShare) > 0.01 {
+				t.Errorf("%v: nest %d got share %0.3f, want %0.3f", nestIds(test.nests), nestId, share, wantShare)
+			}
+		}
+	}
+
+	// the same pokemon always picks the same nest, whatever order the
+	// nests are in.
+	reversed := []*models.Nest{unknown, large, small}
+	for encounterId := uint64(1); encounterId <= 1000; encounterId++ {
+		pokemon := &models.Pokemon{EncounterId: encounterId}
+		first := pick([]*models.Nest{small, large, unknown}, pokemon)
+		if again := pick([]*models.Nest{small, large, unknown}, pokemon); again != first {
+			t.Fatalf("encounter %d: picked nest %d, then %d", encounterId, first, again)
+		}
+		if other := pick(reversed, pokemon); other != first {
+			t.Fatalf("encounter %d: picked nest %d, then %d with the nests reversed", encounterId, first, other)
+		}
+	}
+
+	// without an encounter id, the pick comes from the rest of the
+	// pokemon, and is still spread out.
+	counts := make(map[int64]int)
+	despawn := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < numPokemon; i++ {
+		pokemon := &models.Pokemon{
+			SpawnpointId:  uint64(i % 50),
+			Lat:           10.005,
+			Lon:           10.005,
+			DisappearTime: despawn.Add(time.Duration(i) * time.Second),
+		}
+		nestId := pick([]*models.Nest{small, large}, pokemon)
+		if again := pick([]*models.Nest{small, large}, pokemon); again != nestId {
+			t.Fatalf("no encounter id: picked nest %d, then %d", nestId, again)
+		}
+		counts[nestId]++
+	}
+	if share := float64(counts[1]) / numPokemon; math.Abs(share-0.75) > 0.01 {
+		t.Errorf("no encounter id: small nest got share %0.3f, want 0.750", share)
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// NestCoverage estimates how well a nest is being scanned by comparing
+// what was seen in it against its spawnpoints in the DB.
+type NestCoverage struct {
+	// SpawnpointsSeen is how many different spawnpoints had a
+	// pokemon seen on them. When averaged over time periods, this
+	// is the average per time period.
+	SpawnpointsSeen uint64 `json:"spawnpoints_seen"`
+	// Spawnpoints is the nest's spawnpoints in the DB. If 0, the
+	// coverage is unknown.
+	Spawnpoints uint64 `json:"spawnpoints"`
+	// Pct is the percent of Spawnpoints seen, at most 100.
+	Pct float64 `json:"pct"`
+	// HourlySightingsPerSpawnpoint is how many pokemon were seen
+	// per hour for each of Spawnpoints. A spawnpoint spawns about
+	// once an hour, so a fully scanned nest is close to 1.
+	HourlySightingsPerSpawnpoint float64 `json:"hourly_sightings_per_spawnpoint"`
+	// Low is set when Pct is below the configured minimum.
+	Low bool `json:"low,omitempty"`
+}
+
+// Known returns whether the nest's spawnpoints are known, without
+// which the coverage can't be estimated.
+func (coverage *NestCoverage) Known() bool {
+	return coverage.Spawnpoints > 0
+}
+
+// NewNestCoverage estimates the coverage of 'nest' from 'sightings' on
+// 'spawnpointsSeen' different spawnpoints over 'duration'. Low is set if
+// it is known and less than 'minPct'.
+func NewNestCoverage(nest *Nest, spawnpointsSeen, sightings uint64, duration time.Duration, minPct float64) NestCoverage {
+	coverage := NestCoverage{
+		SpawnpointsSeen: spawnpointsSeen,
+	}
+
+	if nest.Spawnpoints == nil || *nest.Spawnpoints <= 0 {
+		return coverage
+	}
+
+	coverage.Spawnpoints = uint64(*nest.Spawnpoints)
+	// the DB count can be out of date.
+	coverage.Pct = min(100, 100*float64(spawnpointsSeen)/float64(coverage.Spawnpoints))
+	if hours := float64(duration) / float64(time.Hour); hours > 0 {
+		coverage.HourlySightingsPerSpawnpoint = float64(sightings) / hours / float64(coverage.Spawnpoints)
+	}
+	coverage.Low = coverage.Pct < minPct
+
+	return coverage
+}
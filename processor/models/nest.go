@@ -14,6 +14,7 @@ import (
 
 	"github.com/UnownHash/Fletchling/db_store"
 	"github.com/UnownHash/Fletchling/geo"
+	"github.com/UnownHash/Fletchling/processor/clock"
 )
 
 // NestingPokemonInfo contains info about a nesting pokemon. 'Count'
@@ -28,16 +29,78 @@ type NestingPokemonInfo struct {
 	NestTotal       uint64  `json:"nest_total"`
 	NestHourlyCount float64 `json:"nest_hourly_count"`
 	NestHourlyTotal float64 `json:"nest_hourly_total"`
+	// Spawnpoints is how many different spawnpoints the PokemonKey
+	// was seen on. NestSpawnpoints is for all pokemon.
+	Spawnpoints     uint64 `json:"spawnpoints"`
+	NestSpawnpoints uint64 `json:"nest_spawnpoints"`
 
 	GlobalCount       uint64  `json:"global_count"`
 	GlobalTotal       uint64  `json:"global_total"`
 	GlobalHourlyCount float64 `json:"global_hourly_count"`
 	GlobalHourlyTotal float64 `json:"global_hourly_total"`
+	// BaselineArea is set when the 'Global' values are for
+	// this area only.
+	BaselineArea string `json:"baseline_area,omitempty"`
+
+	// Confidence is 0..1: this pokemon's share of the scores of
+	// all of the candidates.
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// Stale is set when nests have migrated since this was computed.
+	Stale bool `json:"stale,omitempty"`
+
+	// Candidates are the top pokemon that were considered, including
+	// this one.
+	Candidates []NestingCandidate `json:"candidates,omitempty"`
+
+	// AdditionalPokemon are other pokemon also nesting here, ranked,
+	// when max_nesting_pokemon allows more than one.
+	AdditionalPokemon []AdditionalNestingPokemon `json:"additional_pokemon,omitempty"`
 
 	DetectedAt time.Time `json:"detected_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// NestingCandidate is a pokemon that was considered when computing
+// the nesting pokemon and why it was or wasn't chosen.
+type NestingCandidate struct {
+	PokemonKey              PokemonKey `json:"pokemon"`
+	NestCount               uint64     `json:"nest_count"`
+	NestPct                 float64    `json:"nest_pct"`
+	Spawnpoints             uint64     `json:"spawnpoints"`
+	GlobalPct               float64    `json:"global_pct"`
+	NestPctToGlobalPctRatio float64    `json:"nest_pct_to_global_pct_ratio"`
+	// Confidence is 0..1: this pokemon's share of the scores of
+	// all of the candidates.
+	Confidence float64 `json:"confidence"`
+	Nesting    bool    `json:"nesting"`
+	// RejectReason is why this pokemon is not nesting.
+	RejectReason string `json:"reject_reason,omitempty"`
+}
+
+// AdditionalNestingPokemon is another pokemon nesting in a nest, such
+// as in the other half of a large park.
+type AdditionalNestingPokemon struct {
+	PokemonKey      PokemonKey `json:"pokemon"`
+	NestCount       uint64     `json:"nest_count"`
+	NestHourlyCount float64    `json:"nest_hourly_count"`
+	NestPct         float64    `json:"nest_pct"`
+	Confidence      float64    `json:"confidence,omitempty"`
+}
+
+// PendingNestChange is a pokemon that has beaten the nesting pokemon,
+// but not for long enough or by enough to replace it yet.
+type PendingNestChange struct {
+	PokemonKey PokemonKey `json:"pokemon"`
+	// Wins is the number of evaluations in a row it has won.
+	Wins int `json:"wins"`
+	// Margin is how many percentage points more of the nest it was
+	// than the nesting pokemon in the last evaluation.
+	Margin float64 `json:"margin"`
+	// Since is when it first won.
+	Since time.Time `json:"since"`
+}
+
 func (ni *NestingPokemonInfo) NestPct() float64 {
 	if ni.NestTotal == 0 {
 		return 0
@@ -45,6 +108,23 @@ func (ni *NestingPokemonInfo) NestPct() float64 {
 	return 100 * float64(ni.NestCount) / float64(ni.NestTotal)
 }
 
+// SamePokemon returns true if 'other' has the same nesting pokemon,
+// including the additional ones in the same order.
+func (ni *NestingPokemonInfo) SamePokemon(other *NestingPokemonInfo) bool {
+	if ni == nil || other == nil {
+		return ni == other
+	}
+	if ni.PokemonKey != other.PokemonKey || len(ni.AdditionalPokemon) != len(other.AdditionalPokemon) {
+		return false
+	}
+	for idx := range ni.AdditionalPokemon {
+		if ni.AdditionalPokemon[idx].PokemonKey != other.AdditionalPokemon[idx].PokemonKey {
+			return false
+		}
+	}
+	return true
+}
+
 func (ni *NestingPokemonInfo) NestRatio() float64 {
 	if ni.NestCount == ni.NestTotal {
 		return 0
@@ -68,6 +148,7 @@ func (ni *NestingPokemonInfo) GlobalRatio() float64 {
 
 type NestStatsInfo struct {
 	mutex sync.Mutex
+	clock clock.Clock
 
 	// updatedAt here is used as the Updated time
 	// in the db when we write. It's inside this struct
@@ -75,6 +156,55 @@ type NestStatsInfo struct {
 	// processing and this is where we have the locking.
 	updatedAt      time.Time
 	nestingPokemon *NestingPokemonInfo
+	// candidates from the last evaluation, whether or not
+	// a nesting pokemon was found.
+	candidates []NestingCandidate
+	// pendingChange is a pokemon waiting to replace nestingPokemon.
+	pendingChange *PendingNestChange
+	// coverage from the last evaluation.
+	coverage *NestCoverage
+}
+
+func (si *NestStatsInfo) GetCoverage() *NestCoverage {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	return si.coverage
+}
+
+func (si *NestStatsInfo) SetCoverage(coverage *NestCoverage) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.coverage = coverage
+}
+
+func (si *NestStatsInfo) GetPendingChange() *PendingNestChange {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	return si.pendingChange
+}
+
+func (si *NestStatsInfo) SetPendingChange(pendingChange *PendingNestChange) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.pendingChange = pendingChange
+}
+
+func (si *NestStatsInfo) GetNestingCandidates() []NestingCandidate {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	return si.candidates
+}
+
+func (si *NestStatsInfo) SetNestingCandidates(candidates []NestingCandidate) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.candidates = candidates
 }
 
 func (si *NestStatsInfo) GetNestingPokemon() (*NestingPokemonInfo, time.Time) {
@@ -109,13 +239,13 @@ func (si *NestStatsInfo) SetNestingPokemon(ni *NestingPokemonInfo, updatedAt tim
 	// until there's been no mon for a period of time.
 	if ni != nil {
 		if updatedAt.IsZero() {
-			updatedAt = time.Now()
+			updatedAt = clock.OrReal(si.clock).Now()
 		}
 		// we'll be writing to the DB, so update this,
 		// as this is what will be used for Updated column.
 		si.updatedAt = updatedAt
 
-		if old != nil && old.PokemonKey == ni.PokemonKey {
+		if old != nil && !old.Stale && old.PokemonKey == ni.PokemonKey {
 			// same mon, so copy the DetectedAt.
 			ni.DetectedAt = old.DetectedAt
 		}
@@ -126,6 +256,35 @@ func (si *NestStatsInfo) SetNestingPokemon(ni *NestingPokemonInfo, updatedAt tim
 	return old, si.updatedAt
 }
 
+// MarkNestingPokemonStale flags the current nesting mon as computed from
+// before a nest migration. It is kept until stats produce a new nesting
+// mon. Returns false if there's no nesting mon.
+func (si *NestStatsInfo) MarkNestingPokemonStale() bool {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	if si.nestingPokemon == nil {
+		return false
+	}
+
+	// others may hold the old pointer, so replace it with a copy.
+	ni := *si.nestingPokemon
+	ni.Stale = true
+	si.nestingPokemon = &ni
+
+	return true
+}
+
+// NewNestStatsInfo returns an empty NestStatsInfo updated now. A nil
+// 'clk' means the real clock.
+func NewNestStatsInfo(clk clock.Clock) *NestStatsInfo {
+	clk = clock.OrReal(clk)
+	return &NestStatsInfo{
+		clock:     clk,
+		updatedAt: clk.Now(),
+	}
+}
+
 type Nest struct {
 	SyncedToDb bool
 	ExistsInDb bool
@@ -140,6 +299,11 @@ type Nest struct {
 	Active      bool
 	Discarded   string
 
+	// MatchGeometry is Geometry grown or shrunk by BufferMeters, if
+	// a buffer is configured for the nest.
+	BufferMeters  float64
+	MatchGeometry *geojson.Geometry
+
 	// broken out so we can just copy this pointer to
 	// new Nests without needing to lock.
 	*NestStatsInfo
@@ -158,6 +322,34 @@ func (nest *Nest) String() string {
 	return fmt.Sprintf("'%s' centered at %0.5f,%0.5f", nest.FullName(), center.Lat(), center.Lon())
 }
 
+// MatchingGeometry returns the geometry that pokemon are matched
+// against.
+func (nest *Nest) MatchingGeometry() *geojson.Geometry {
+	if nest.MatchGeometry != nil {
+		return nest.MatchGeometry
+	}
+	return nest.Geometry
+}
+
+// SetBufferMeters sets MatchGeometry to Geometry grown by 'meters', or
+// shrunk if negative. 0 removes the buffer.
+func (nest *Nest) SetBufferMeters(meters float64) error {
+	if meters == 0 {
+		nest.BufferMeters = 0
+		nest.MatchGeometry = nil
+		return nil
+	}
+
+	geometry, err := geo.BufferGeometry(nest.Geometry.Geometry(), meters)
+	if err != nil {
+		return err
+	}
+
+	nest.BufferMeters = meters
+	nest.MatchGeometry = geojson.NewGeometry(geometry)
+	return nil
+}
+
 func (nest *Nest) AsDBStoreNest() *db_store.Nest {
 	center := nest.Center
 
@@ -191,6 +383,18 @@ func (nest *Nest) AsDBStoreNest() *db_store.Nest {
 		dbNest.PokemonAvg = null.FloatFrom(ni.NestHourlyCount)
 		// nestcollector uses pct, and I agree it is better than 'ratio'.
 		dbNest.PokemonRatio = null.FloatFrom(ni.NestPct())
+
+		additional := make([]db_store.AdditionalNestPokemon, len(ni.AdditionalPokemon))
+		for idx, addl := range ni.AdditionalPokemon {
+			additional[idx] = db_store.AdditionalNestPokemon{
+				PokemonId:    addl.PokemonKey.PokemonId,
+				PokemonForm:  addl.PokemonKey.FormId,
+				PokemonAvg:   addl.NestHourlyCount,
+				PokemonRatio: addl.NestPct,
+				PokemonCount: float64(addl.NestCount),
+			}
+		}
+		dbNest.SetAdditionalPokemon(additional)
 	}
 
 	return dbNest
@@ -219,10 +423,12 @@ func (nest *Nest) AsStorePartialUpdatePokemon(updatedAt time.Time) *db_store.Nes
 		PokemonCount: &dbNest.PokemonCount,
 		PokemonAvg:   &dbNest.PokemonAvg,
 		PokemonRatio: &dbNest.PokemonRatio,
+
+		AdditionalPokemon: &dbNest.AdditionalPokemon,
 	}
 }
 
-func NestingPokemonInfoFromDBStore(dbNest *db_store.Nest) (*NestingPokemonInfo, time.Time) {
+func NestingPokemonInfoFromDBStore(dbNest *db_store.Nest, now time.Time) (*NestingPokemonInfo, time.Time) {
 	// preserve nesting pokemon in DB if it looks ok. But if there's no Updated, set to
 	// now.
 	var dbUpdatedAt time.Time
@@ -232,7 +438,7 @@ func NestingPokemonInfoFromDBStore(dbNest *db_store.Nest) (*NestingPokemonInfo,
 
 	updatedAtOrNow := dbUpdatedAt
 	if updatedAtOrNow.IsZero() {
-		updatedAtOrNow = time.Now()
+		updatedAtOrNow = now
 	}
 
 	if pokemonId := dbNest.PokemonId.ValueOrZero(); pokemonId > 0 {
@@ -257,14 +463,32 @@ func NestingPokemonInfoFromDBStore(dbNest *db_store.Nest) (*NestingPokemonInfo,
 			DetectedAt:      updatedAtOrNow,
 			UpdatedAt:       updatedAtOrNow,
 		}
+
+		// a bad column only loses the additional pokemon until
+		// the next time the nest is processed.
+		additional, _ := dbNest.GetAdditionalPokemon()
+		for _, addl := range additional {
+			ni.AdditionalPokemon = append(ni.AdditionalPokemon, AdditionalNestingPokemon{
+				PokemonKey: PokemonKey{
+					PokemonId: addl.PokemonId,
+					FormId:    addl.PokemonForm,
+				},
+				NestCount:       uint64(addl.PokemonCount),
+				NestHourlyCount: addl.PokemonAvg,
+				NestPct:         addl.PokemonRatio,
+			})
+		}
+
 		return ni, dbUpdatedAt
 	}
 	return nil, dbUpdatedAt
 }
 
-func NewNestFromDBStore(storeNest *db_store.Nest) (*Nest, error) {
-	nestStatsInfo := &NestStatsInfo{}
-	nestStatsInfo.SetNestingPokemon(NestingPokemonInfoFromDBStore(storeNest))
+func NewNestFromDBStore(storeNest *db_store.Nest, clk clock.Clock) (*Nest, error) {
+	clk = clock.OrReal(clk)
+	// updatedAt is only set if there's a nesting pokemon.
+	nestStatsInfo := &NestStatsInfo{clock: clk}
+	nestStatsInfo.SetNestingPokemon(NestingPokemonInfoFromDBStore(storeNest, clk.Now()))
 
 	geometry, err := storeNest.Geometry()
 	if err != nil {
@@ -288,7 +512,7 @@ func NewNestFromDBStore(storeNest *db_store.Nest) (*Nest, error) {
 	}, nil
 }
 
-func NewNestFromKojiFeature(feature *geojson.Feature) (*Nest, error) {
+func NewNestFromKojiFeature(feature *geojson.Feature, clk clock.Clock) (*Nest, error) {
 	props := feature.Properties
 
 	name, ok := props["name"].(string)
@@ -343,8 +567,6 @@ func NewNestFromKojiFeature(feature *geojson.Feature) (*Nest, error) {
 		// default to true
 		Active: true,
 
-		NestStatsInfo: &NestStatsInfo{
-			updatedAt: time.Now(),
-		},
+		NestStatsInfo: NewNestStatsInfo(clk),
 	}, nil
 }
@@ -1,6 +1,14 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ditto spawns disguised as other pokemon.
+const DITTO_POKEMON_ID = 132
 
 type PokemonKey struct {
 	PokemonId int `json:"pokemon_id"`
@@ -14,16 +22,39 @@ func (k PokemonKey) MarshalText() ([]byte, error) {
 	return []byte(k.String()), nil
 }
 
+// UnmarshalText is the reverse of MarshalText, so that stats
+// can be read back in after being saved.
+func (k *PokemonKey) UnmarshalText(b []byte) error {
+	pokemonStr, formStr, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return fmt.Errorf("malformed pokemon key '%s'", string(b))
+	}
+	pokemonId, err := strconv.Atoi(pokemonStr)
+	if err != nil {
+		return fmt.Errorf("malformed pokemon id in pokemon key '%s': %w", string(b), err)
+	}
+	formId, err := strconv.Atoi(formStr)
+	if err != nil {
+		return fmt.Errorf("malformed form id in pokemon key '%s': %w", string(b), err)
+	}
+	k.PokemonId = pokemonId
+	k.FormId = formId
+	return nil
+}
+
 func (k PokemonKey) String() string {
 	return fmt.Sprintf("%d:%d", k.PokemonId, k.FormId)
 }
 
 type Pokemon struct {
-	PokemonId    int
-	FormId       int
-	SpawnpointId uint64
-	Lat          float64
-	Lon          float64
+	// EncounterId is 0 if unknown.
+	EncounterId   uint64
+	PokemonId     int
+	FormId        int
+	SpawnpointId  uint64
+	Lat           float64
+	Lon           float64
+	DisappearTime time.Time
 }
 
 func (pokemon Pokemon) Key() PokemonKey {
@@ -9,8 +9,14 @@ type NestTimePeriodSummary struct {
 	// actual sum of durations of the periods. may be lower than
 	// (EndTime - StartTime) if this is being used as totals
 	// for all time periods, and a time period was dropped.
-	Duration               time.Duration
+	Duration time.Duration
+	// BaselineArea is the area whose counts were used for the
+	// 'Global' values, or "" if global counts were used.
+	BaselineArea           string
 	PokemonCountsAndTotals NestPokemonCountsAndTotals
+	// Spawnpoints is the number of different spawnpoints
+	// pokemon were seen on in the nest.
+	Spawnpoints uint64
 }
 
 type NestPokemonCountAndTotal struct {
@@ -25,6 +31,8 @@ type NestPokemonCountAndTotal struct {
 	Global uint64
 	// Total of all pokemon globally
 	GlobalTotal uint64
+	// Number of different spawnpoints PokemonKey was seen on in this nest
+	Spawnpoints uint64
 }
 
 func (np *NestPokemonCountAndTotal) NestPct() float64 {
@@ -34,6 +42,15 @@ func (np *NestPokemonCountAndTotal) NestPct() float64 {
 	return 100 * float64(np.Count) / float64(np.Total)
 }
 
+// SpawnpointPct returns the percent of the nest's spawnpoints
+// the pokemon was seen on.
+func (np *NestPokemonCountAndTotal) SpawnpointPct(nestSpawnpoints uint64) float64 {
+	if nestSpawnpoints == 0 {
+		return 0
+	}
+	return 100 * float64(np.Spawnpoints) / float64(nestSpawnpoints)
+}
+
 func (np *NestPokemonCountAndTotal) GlobalPct() float64 {
 	if np.GlobalTotal == 0 {
 		return 0
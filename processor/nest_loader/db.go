@@ -6,12 +6,14 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/UnownHash/Fletchling/db_store"
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/models"
 )
 
 type DBNestLoader struct {
 	logger  *logrus.Logger
 	dbStore *db_store.NestsDBStore
+	clock   clock.Clock
 }
 
 func (*DBNestLoader) LoaderName() string {
@@ -26,7 +28,7 @@ func (loader *DBNestLoader) LoadNests(ctx context.Context) ([]*models.Nest, erro
 	nests := make([]*models.Nest, len(dbNests))
 	idx := 0
 	for _, dbNest := range dbNests {
-		nest, err := models.NewNestFromDBStore(dbNest)
+		nest, err := models.NewNestFromDBStore(dbNest, loader.clock)
 		if err != nil {
 			loader.logger.Warnf("skipping nest %d/%s: %s", dbNest.NestId, dbNest.Name, err)
 			continue
@@ -38,9 +40,10 @@ func (loader *DBNestLoader) LoadNests(ctx context.Context) ([]*models.Nest, erro
 	return nests, nil
 }
 
-func NewDBNestLoader(logger *logrus.Logger, dbStore *db_store.NestsDBStore) *DBNestLoader {
+func NewDBNestLoader(logger *logrus.Logger, dbStore *db_store.NestsDBStore, clk clock.Clock) *DBNestLoader {
 	return &DBNestLoader{
 		logger:  logger,
 		dbStore: dbStore,
+		clock:   clock.OrReal(clk),
 	}
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/UnownHash/Fletchling/db_store"
 	"github.com/UnownHash/Fletchling/koji_client"
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/models"
 )
 
@@ -16,6 +17,7 @@ type KojiNestLoader struct {
 	kojiCli      *koji_client.APIClient
 	projectName  string
 	nestsDBStore *db_store.NestsDBStore
+	clock        clock.Clock
 }
 
 func (*KojiNestLoader) LoaderName() string {
@@ -33,7 +35,7 @@ func (loader *KojiNestLoader) LoadNests(ctx context.Context) ([]*models.Nest, er
 
 	idx := 0
 	for _, feature := range fc.Features {
-		nest, err := models.NewNestFromKojiFeature(feature)
+		nest, err := models.NewNestFromKojiFeature(feature, loader.clock)
 		if err != nil {
 			loader.logger.Warnf("NEST-LOAD[]: skipping geofence from koji: %v", err)
 			continue
@@ -62,19 +64,20 @@ func (loader *KojiNestLoader) LoadNests(ctx context.Context) ([]*models.Nest, er
 			kojiNest.Active = dbNest.Active.ValueOrZero()
 			kojiNest.Discarded = dbNest.Discarded.ValueOrZero()
 			kojiNest.SetNestingPokemon(
-				models.NestingPokemonInfoFromDBStore(dbNest),
+				models.NestingPokemonInfoFromDBStore(dbNest, loader.clock.Now()),
 			)
 		}
 	}
 	return kojiNests, nil
 }
 
-func NewKojiNestLoader(logger *logrus.Logger, kojiCli *koji_client.APIClient, projectName string, nestsDBStore *db_store.NestsDBStore) *KojiNestLoader {
+func NewKojiNestLoader(logger *logrus.Logger, kojiCli *koji_client.APIClient, projectName string, nestsDBStore *db_store.NestsDBStore, clk clock.Clock) *KojiNestLoader {
 	st := &KojiNestLoader{
 		logger:       logger,
 		kojiCli:      kojiCli,
 		projectName:  projectName,
 		nestsDBStore: nestsDBStore,
+		clock:        clock.OrReal(clk),
 	}
 	return st
 }
@@ -3,13 +3,20 @@ package processor
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/UnownHash/Fletchling/db_store"
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/models"
+	"github.com/UnownHash/Fletchling/stats_collector"
 )
 
 // NestProcessor is the pokemon nest processor.
@@ -19,12 +26,27 @@ import (
 // preserving the pointer to the history.
 type NestProcessor struct {
 	logger       *logrus.Logger
+	clock        clock.Clock
 	nestsDBStore *db_store.NestsDBStore
+	// dryRun disables all writes to the nests DB.
+	dryRun bool
 
-	nestMatcher *NestMatcher
+	nestMatcher     *NestMatcher
+	nestingStrategy NestingStrategy
+	// nil when not enabled or not built yet.
+	spawnpointIndex atomic.Pointer[SpawnpointIndex]
+	speciesRules    *SpeciesRules
 
 	statsCollection *StatsCollection
+	encounterCache  *EncounterCache
+	eventCalendar   *EventCalendar
 	webhookSender   WebhookSender
+	statsCollector  stats_collector.StatsCollector
+
+	// processMutex is held while processing stats and while handling
+	// a migration, so that they don't overlap. It is shared with the
+	// processors from reloads, like statsCollection.
+	processMutex *sync.Mutex
 
 	config Config
 }
@@ -39,181 +61,201 @@ func (np *NestProcessor) LogConfiguration(prefix string, numNests int) {
 	np.logger.Info(buf.String())
 }
 
-func (np *NestProcessor) AddPokemon(pokemon *models.Pokemon) AddPokemonStats {
-	nests := np.nestMatcher.GetMatchingNests(pokemon.Lat, pokemon.Lon)
-	wasCounted := np.statsCollection.AddPokemon(pokemon, nests)
-	return AddPokemonStats{
-		WasCounted:      wasCounted,
-		NumNestsMatched: uint64(len(nests)),
+// isDuplicate returns true if we've already seen this encounter. Golbat
+// will send the same encounter again when it is re-scanned or updated.
+func (np *NestProcessor) isDuplicate(pokemon *models.Pokemon) bool {
+	if np.encounterCache == nil || pokemon.EncounterId == 0 {
+		return false
 	}
-}
 
-func (np *NestProcessor) RotateStats() *FrozenStatsCollection {
-	return np.statsCollection.Rotate(np.config.MaxHistoryDuration(), np.config.SkipPeriodMinGlobalSpawnPct)
-}
-
-func (np *NestProcessor) KeepRecentStats(keepDuration time.Duration) (int, time.Duration) {
-	return np.statsCollection.KeepRecent(keepDuration)
-}
+	now := np.clock.Now()
+	expiresAt := pokemon.DisappearTime
+	if expiresAt.Before(now) {
+		expiresAt = now.Add(np.config.DedupDefaultTTL())
+	}
 
-func (np *NestProcessor) PurgeNewestStats(purgeDuration time.Duration, includeCurrent bool) (int, time.Duration) {
-	return np.statsCollection.PurgeNewest(purgeDuration, includeCurrent)
+	return !np.encounterCache.Add(pokemon.EncounterId, expiresAt, now)
 }
 
-func (np *NestProcessor) PurgeOldestStats(purgeDuration time.Duration) (int, time.Duration) {
-	return np.statsCollection.PurgeOldest(purgeDuration)
+// updateNestPartial updates the nest in the DB unless this is a dry run.
+func (np *NestProcessor) updateNestPartial(ctx context.Context, nestId int64, partialNest *db_store.NestPartialUpdate) error {
+	if np.dryRun {
+		return nil
+	}
+	return np.nestsDBStore.UpdateNestPartial(ctx, nestId, partialNest)
 }
 
-func (np *NestProcessor) logPokemonAndComputeNesting(summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal, onlyLog bool, logPrefix string) *models.NestingPokemonInfo {
-	nest := summary.Nest
+func (np *NestProcessor) AddPokemon(pokemon *models.Pokemon) AddPokemonStats {
+	if np.isDuplicate(pokemon) {
+		return AddPokemonStats{
+			IsDuplicate: true,
+		}
+	}
 
-	nestPct := pokStats.NestPct()
-	gblPct := pokStats.GlobalPct()
-	var nestPctToGblPct float64
+	nests, withIndex := np.getMatchingNests(pokemon)
+	numNestsMatched := uint64(len(nests))
 
-	if gblPct != 0 {
-		nestPctToGblPct = nestPct / gblPct
+	// the index only has the spawnpoints near the nests, so pokemon it
+	// doesn't have are matched by location. Either way, the pokemon is
+	// skipped if it is in no nest, so that the baseline doesn't depend
+	// on which spawnpoints are indexed.
+	if numNestsMatched == 0 && withIndex && np.config.SkipSpawnpointsOutsideNests {
+		return AddPokemonStats{
+			OutsideNests: true,
+		}
 	}
 
-	cfg := np.config
+	// the policy goes first, so that a pokemon in a smaller nest that is
+	// in an event isn't counted in the larger one instead.
+	nests = np.nestMatcher.ApplyPolicy(nests, pokemon)
 
-	ni, reason := func() (*models.NestingPokemonInfo, string) {
-		if onlyLog {
-			return nil, ""
+	nests, inEvent := np.filterNestsInEvents(nests, np.clock.Now())
+	if inEvent {
+		return AddPokemonStats{
+			InEvent:         true,
+			NumNestsMatched: numNestsMatched,
 		}
+	}
 
-		// The more interesting checks are first to see what they look like in logs.
+	var areaNames []string
+	if np.config.AreaBaselines {
+		areaNames = np.nestMatcher.GetMatchingAreas(pokemon.Lat, pokemon.Lon)
+	}
 
-		if nestPct < cfg.MinNestPokemonPct {
-			return nil, fmt.Sprintf("this pokemon's percent in the nest (%0.3f) too small (< %0.3f)", nestPct, np.config.MinNestPokemonPct)
-		}
+	wasCounted := np.statsCollection.AddPokemon(pokemon.EncounterId, np.speciesRules.PokemonKey(pokemon), pokemon.SpawnpointId, nests, areaNames)
+	return AddPokemonStats{
+		WasCounted:      wasCounted,
+		NumNestsMatched: numNestsMatched,
+	}
+}
 
-		if nestPct < gblPct {
-			return nil, fmt.Sprintf("this pokemon's percent in the nest (%0.3f) is less than global spawn percent (%0.3f)", nestPct, gblPct)
+// getMatchingNests returns the nests containing the pokemon, from the
+// spawnpoint index if its spawnpoint is in it, else by location. The
+// bool is true if there is a spawnpoint index.
+func (np *NestProcessor) getMatchingNests(pokemon *models.Pokemon) ([]*models.Nest, bool) {
+	spawnpointIndex := np.spawnpointIndex.Load()
+	if spawnpointIndex != nil && pokemon.SpawnpointId != 0 {
+		if nestIds, ok := spawnpointIndex.Lookup(pokemon.SpawnpointId); ok {
+			return np.nestMatcher.GetNestsByIds(nestIds), true
 		}
+	}
+	return np.nestMatcher.GetMatchingNests(pokemon.Lat, pokemon.Lon), spawnpointIndex != nil
+}
 
-		if nestPctToGblPct < cfg.MinNestPctToGlobalPctRatio {
-			return nil, fmt.Sprintf("this pokemon's ratio (%0.3f) of nest percent (%0.3f) to global percent (%0.3f) is too small (< %0.3f)", nestPctToGblPct, nestPct, gblPct, cfg.MinNestPctToGlobalPctRatio)
-		}
+// SetSpawnpointIndex replaces the spawnpoint index. It must have been
+// built from this processor's nests. nil disables it.
+func (np *NestProcessor) SetSpawnpointIndex(spawnpointIndex *SpawnpointIndex) {
+	np.spawnpointIndex.Store(spawnpointIndex)
+}
 
-		// if a mon is spawning enough globally, ignore it. but I find the nestPct:gblPct ratio better.
-		if maxPct := cfg.MaxGlobalSpawnPct; maxPct > 0 {
-			if pct := pokStats.GlobalPct(); pct > maxPct {
-				return nil, fmt.Sprintf("this pokemon's global spawn pct is too high (%0.3f > %0.3f)", pct, maxPct)
-			}
-		}
+// GetSpawnpointIndex returns the spawnpoint index, or nil if there is
+// none.
+func (np *NestProcessor) GetSpawnpointIndex() *SpawnpointIndex {
+	return np.spawnpointIndex.Load()
+}
 
-		if pokStats.Total < uint64(cfg.MinTotalPokemon) {
-			return nil, fmt.Sprintf("not enough pokemon seen overall (%d < %d)", pokStats.Total, cfg.MinTotalPokemon)
-		}
+// filterNestsInEvents removes nests that are in the areas of events
+// active at 't'. Returns true if a global event is active, meaning
+// the pokemon should not be counted at all.
+func (np *NestProcessor) filterNestsInEvents(nests []*models.Nest, t time.Time) ([]*models.Nest, bool) {
+	events := np.eventCalendar.ActiveEvents(t)
+	if len(events) == 0 {
+		return nests, false
+	}
 
-		if pokStats.Count < uint64(cfg.MinNestPokemon) {
-			return nil, fmt.Sprintf("not enough of this pokemon seen (%d < %d)", pokStats.Count, cfg.MinNestPokemon)
+	for _, ev := range events {
+		if ev.IsGlobal() {
+			return nil, true
 		}
+	}
+
+	filtered := make([]*models.Nest, 0, len(nests))
 
-		if minHistory := np.config.MinHistoryDuration(); summary.Duration < minHistory {
-			return nil, "not enough stats history yet"
+	for _, nest := range nests {
+		inEvent := false
+		for _, ev := range events {
+			if ev.AppliesToNest(nest) {
+				inEvent = true
+				break
+			}
+		}
+		if !inEvent {
+			filtered = append(filtered, nest)
 		}
+	}
 
-		hours := float64(summary.Duration) / float64(time.Hour)
+	return filtered, false
+}
 
-		return &models.NestingPokemonInfo{
-			PokemonKey: pokStats.PokemonKey,
+func (np *NestProcessor) RotateStats() *FrozenStatsCollection {
+	fstats := np.statsCollection.Rotate(np.config.MaxHistoryDuration(), np.config.SkipPeriodMinGlobalSpawnPct, np.eventCalendar)
 
-			StatsDurationMinutes: uint64(summary.Duration / time.Minute),
+	if downsampleAfter := np.config.DownsampleAfter(); downsampleAfter > 0 {
+		olderThan := np.clock.Now().Add(-downsampleAfter)
+		if numMerged := np.statsCollection.Downsample(olderThan, np.config.DownsampleBucketDuration()); numMerged > 0 {
+			np.logger.Debugf("PROCESSOR: downsampled stats: merged away %d time period(s)", numMerged)
+		}
+	}
 
-			UpdatedAt:  summary.EndTime,
-			DetectedAt: summary.EndTime,
+	return fstats
+}
 
-			NestCount:       pokStats.Count,
-			NestTotal:       pokStats.Total,
-			NestHourlyCount: float64(pokStats.Count) / hours,
-			NestHourlyTotal: float64(pokStats.Total) / hours,
+func (np *NestProcessor) KeepRecentStats(keepDuration time.Duration) (int, time.Duration) {
+	return np.statsCollection.KeepRecent(keepDuration)
+}
 
-			GlobalCount:       pokStats.Global,
-			GlobalTotal:       pokStats.GlobalTotal,
-			GlobalHourlyCount: float64(pokStats.Global) / hours,
-			GlobalHourlyTotal: float64(pokStats.GlobalTotal) / hours,
-		}, "nesting!"
-	}()
+func (np *NestProcessor) PurgeNewestStats(purgeDuration time.Duration, includeCurrent bool) (int, time.Duration) {
+	return np.statsCollection.PurgeNewest(purgeDuration, includeCurrent)
+}
 
-	if logPrefix != "" {
-		fmt := "%s NEST [%s] #%02d: %d:%d nest: %d/%d (%0.3f%%), global: %d/%d (%0.3f%%), nestPctToGlobalPctRatio: %0.3f)"
-		if reason != "" {
-			fmt += ": " + reason
-		}
-		np.logger.Infof(fmt,
-			logPrefix,
-			nest,
-			pokStats.Rank,
-			pokStats.PokemonKey.PokemonId,
-			pokStats.PokemonKey.FormId,
-			pokStats.Count,
-			pokStats.Total,
-			nestPct,
-			pokStats.Global,
-			pokStats.GlobalTotal,
-			gblPct,
-			nestPctToGblPct,
-		)
-	}
+func (np *NestProcessor) PurgeOldestStats(purgeDuration time.Duration) (int, time.Duration) {
+	return np.statsCollection.PurgeOldest(purgeDuration)
+}
 
-	return ni
+// SaveStats writes the current stats to the configured stats file. It is a
+// no-op if there is no stats file configured.
+func (np *NestProcessor) SaveStats() error {
+	filename := np.config.StatsFilename
+	if filename == "" {
+		return nil
+	}
+	return np.statsCollection.Save(filename)
 }
 
-func (np *NestProcessor) processTimePeriodSummary(summary models.NestTimePeriodSummary, logPrefix string) *models.NestingPokemonInfo {
-	var nestingPokemonInfo *models.NestingPokemonInfo
-
-	// XXX: It's probably more interesting to look at these as a whole. For
-	// example, we can possibly reason about things if we compared against
-	// each other. For example, these are sorted by % in the nest. If there's
-	// a tie, the one spawning the least globally wins. But, let's say we have
-	// 22% in nest spawning @ 3% globally.. and.. 20% in nest spawning at 0.5%
-	// globally. It is more likely that the 20% is the nesting pokemon.
-	//
-	// So, we're currently relying on the 22% to get thrown out and ignored by
-	// its own individual stats. And it would by some of the global configs like:
-	//
-	// 1) you could configure to ignore pokemon spawning at 2.5%+. That might be
-	//    reasonable. From eyeballing prometheus over the past number of months,
-	//    it seems Niantic doesn't go much over 10% for a single mon and often
-	//    looks like around 7%. As I write this, there's only 10 pokemon spawning
-	//    at more than 2%. The highest is ~6% and #2 is ~4.75%.
-	// 2) you could configure the nest_pct_to_global_pct ratio accordingly. The
-	//    default for this one is currently 8, and that's enough to get the 22%
-	//    in the above example thrown out (ratio is ~7). The default of 10 has seemed
-	//    like a good number for this as I've been testing things. The obvious nesting
-	//    mons can have some absurd ratios.
-	//
-	// Or it might be interesting to look at the top 2 choices and compare those. Or
-	// some sort of scoring system.
-	for idx, pokStats := range summary.PokemonCountsAndTotals {
-		// stop at 10 pokemon
-		if idx > 9 {
-			if logPrefix != "" {
-				np.logger.Infof(
-					"%s NEST [%s] Stopping at %d out of %d pokemon",
-					logPrefix,
-					summary.Nest,
-					idx,
-					len(summary.PokemonCountsAndTotals),
-				)
-			}
-			break
-		}
-		if pokStats.GlobalTotal <= 0 || pokStats.Global <= 0 ||
-			pokStats.Total <= 0 || pokStats.Count <= 0 {
-			np.logger.Warnf("PROCESSOR: Got unexpected stats when processing time period: %#v", pokStats)
-			continue
-		}
+// restoreStatsCollection loads the stats saved by SaveStats() on a previous
+// run. Stats for nests that no longer exist are dropped. If there's no
+// stats file configured or it can't be loaded, new empty stats are returned.
+func (np *NestProcessor) restoreStatsCollection() *StatsCollection {
+	filename := np.config.StatsFilename
+	if filename == "" {
+		return NewStatsCollection(np.logger, np.clock)
+	}
 
-		res := np.logPokemonAndComputeNesting(summary, pokStats, nestingPokemonInfo != nil, logPrefix)
-		if res != nil {
-			nestingPokemonInfo = res
+	keepNest := func(nestId int64) bool {
+		return np.nestMatcher.GetNestById(nestId) != nil
+	}
+
+	statsCollection, err := LoadStatsCollection(np.logger, np.clock, filename, np.config.MaxHistoryDuration(), keepNest)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			np.logger.Infof("STATS-RESTORE: no saved stats in '%s' yet. Starting with empty stats.", filename)
+		} else {
+			np.logger.Warnf("STATS-RESTORE: failed to restore stats from '%s': %v. Starting with empty stats.", filename, err)
 		}
+		return NewStatsCollection(np.logger, np.clock)
 	}
 
-	return nestingPokemonInfo
+	np.logger.Infof("STATS-RESTORE: restored %d time period(s) (%s) from '%s'",
+		statsCollection.Len()-1,
+		statsCollection.Duration,
+		filename,
+	)
+
+	return statsCollection
+}
+
+func (np *NestProcessor) processTimePeriodSummary(summary models.NestTimePeriodSummary, logPrefix string) (*models.NestingPokemonInfo, []models.NestingCandidate) {
+	return np.nestingStrategy.ComputeNesting(summary, logPrefix)
 }
 
 func (np *NestProcessor) logLatestEntry(lastEntry *CountsForTimePeriod) {
@@ -230,7 +272,7 @@ func (np *NestProcessor) logLatestEntry(lastEntry *CountsForTimePeriod) {
 			np.logger.Warnf("LAST-PERIOD: Ignoring missing nest %d", nestId)
 			continue
 		}
-		summary := lastEntry.GetSummaryForNest(nest, lastEntry.EndTime.Sub(lastEntry.StartTime))
+		summary := lastEntry.GetSummaryForNest(nest, lastEntry.EndTime.Sub(lastEntry.StartTime), np.config.areaBaselineMinPokemon())
 		if summary == nil {
 			np.logger.Warnf("LAST-PERIOD: No summary for nest %s", nest)
 			continue
@@ -239,6 +281,10 @@ func (np *NestProcessor) logLatestEntry(lastEntry *CountsForTimePeriod) {
 	}
 }
 
+func (np *NestProcessor) GetEventCalendar() *EventCalendar {
+	return np.eventCalendar
+}
+
 func (np *NestProcessor) GetConfig() Config {
 	return np.config
 }
@@ -257,6 +303,14 @@ func (np *NestProcessor) GetStatsSnapshot() *FrozenStatsCollection {
 }
 
 func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsCollection) {
+	np.processMutex.Lock()
+	defer np.processMutex.Unlock()
+
+	if generation := np.statsCollection.Generation(); statsCollection.Generation != generation {
+		np.logger.Infof("PROCESSOR: discarding stats from before the last nest migration")
+		return
+	}
+
 	np.LogConfiguration("PROCESSOR: time period processing starting with configuration: ", np.nestMatcher.Len())
 	defer np.logger.Infof("PROCESSOR: time period processing ending")
 
@@ -286,9 +340,13 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 		np.logLatestEntry(statsCollection.LatestEntry())
 	}
 
-	now := time.Now()
+	now := np.clock.Now()
 
 	logPrefix := fmt.Sprintf("ALL-PERIODS(%d):", statsCollection.Len())
+
+	numLowCoverage := 0
+	defer func() { np.statsCollector.SetNestsLowCoverage(numLowCoverage) }()
+
 	for nestId := range totals.NestCounts {
 		nest := np.nestMatcher.GetNestById(nestId)
 		if nest == nil {
@@ -297,21 +355,64 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 			continue
 		}
 
-		summary := totals.GetSummaryForNest(nest, duration)
-		if summary == nil {
+		decision := decideNest(statsCollection, nest, np.config, np.nestingStrategy, logPrefix, now)
+		if decision == nil {
 			np.logger.Warnf("PROCESSOR: No summary for nest %s", nest)
 			continue
 		}
 
-		ni := np.processTimePeriodSummary(
-			*summary,
-			logPrefix,
-		)
+		coverage := decision.Coverage
+		nest.SetCoverage(&coverage)
+
+		if coverage.Known() {
+			np.statsCollector.ObserveNestCoverage(coverage.Pct)
+		}
+
+		if coverage.Low {
+			numLowCoverage++
+
+			if decision.SkippedLowCoverage {
+				np.logger.Warnf("PROCESSOR[%s]: LOW-COVERAGE: skipping nest: only %d of %d spawnpoints seen (%0.3f%% < %0.3f%%)",
+					nest,
+					coverage.SpawnpointsSeen,
+					coverage.Spawnpoints,
+					coverage.Pct,
+					np.config.MinNestCoveragePct,
+				)
+				continue
+			}
+
+			np.logger.Warnf("PROCESSOR[%s]: LOW-COVERAGE: only %d of %d spawnpoints seen (%0.3f%% < %0.3f%%)",
+				nest,
+				coverage.SpawnpointsSeen,
+				coverage.Spawnpoints,
+				coverage.Pct,
+				np.config.MinNestCoveragePct,
+			)
+		}
+
+		nest.SetNestingCandidates(decision.Candidates)
 
-		if minHistory := np.config.MinHistoryDuration(); summary.Duration < minHistory {
+		if decision.TooLittleHistory {
 			continue
 		}
 
+		nest.SetPendingChange(decision.PendingChange)
+
+		if pendingChange := decision.PendingChange; pendingChange != nil {
+			cur_ni, _ := nest.GetNestingPokemon()
+			np.logger.Infof("PROCESSOR[%s]: NEST-CHANGE-PENDING: %s has won %d of %d evaluation(s) needed to replace %s (margin: %0.3f)",
+				nest,
+				pendingChange.PokemonKey,
+				pendingChange.Wins,
+				np.config.NestChangeMinWins,
+				cur_ni.PokemonKey,
+				pendingChange.Margin,
+			)
+		}
+
+		ni := decision.NestingPokemon
+
 		// side effect: updates ni.DetectedAt.
 		old_ni, dbUpdatedAt := nest.SetNestingPokemon(ni, now)
 
@@ -325,6 +426,7 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 					nest,
 					old_ni.PokemonKey,
 				)
+				np.endNestHistory(context.Background(), nest, old_ni, now)
 			}
 
 			if now.After(dbUpdatedAt.Add(np.config.NoNestingPokemonAge())) {
@@ -336,7 +438,7 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 			)
 
 			partialNest := nest.AsStorePartialUpdatePokemon(now)
-			if err := np.nestsDBStore.UpdateNestPartial(context.Background(), nest.Id, partialNest); err != nil {
+			if err := np.updateNestPartial(context.Background(), nest.Id, partialNest); err != nil {
 				np.logger.Errorf("PROCESSOR[%s]: failed to update DB to unset nesting pokemon: %v",
 					nest,
 					err,
@@ -352,6 +454,16 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 				ni.PokemonKey,
 			)
 			np.webhookSender.AddNestWebhook(nest, ni)
+			np.startNestHistory(context.Background(), nest, ni, nil)
+		} else if old_ni.Stale {
+			np.logger.Infof("PROCESSOR[%s]: NEST-START: nesting pokemon after migration is %s (was %s)",
+				nest,
+				ni.PokemonKey,
+				old_ni.PokemonKey,
+			)
+			np.webhookSender.AddNestWebhook(nest, ni)
+			// the old history was ended at migration time.
+			np.startNestHistory(context.Background(), nest, ni, nil)
 		} else if ni.PokemonKey != old_ni.PokemonKey {
 			np.logger.Infof("PROCESSOR[%s]: NEST-CHANGE: nesting pokemon has changed from %s to %s",
 				nest,
@@ -359,6 +471,14 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 				ni.PokemonKey,
 			)
 			np.webhookSender.AddNestWebhook(nest, ni)
+			np.startNestHistory(context.Background(), nest, ni, old_ni)
+		} else if !ni.SamePokemon(old_ni) {
+			np.logger.Infof("PROCESSOR[%s]: NEST-ADDITIONAL: additional nesting pokemon are now %s (were %s)",
+				nest,
+				additionalPokemonString(ni),
+				additionalPokemonString(old_ni),
+			)
+			np.webhookSender.AddNestWebhook(nest, ni)
 		}
 
 		var nestToGlobalPctRatio float64
@@ -366,10 +486,15 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 			nestToGlobalPctRatio = ni.NestPct() / gblPct
 		}
 
-		np.logger.Infof("PROCESSOR[%s]: NESTING: %s (nestingFor:%s, statsDuration:%s, cnt:%d/%d, nestHourlyRate:%0.3f, nestPct:%0.3f, gblHourlyRate:%0.3f, gblPct:%0.3f, nestPctToGlobalPctRatio:%0.3f ",
+		baseline := "global"
+		if ni.BaselineArea != "" {
+			baseline = "area:" + ni.BaselineArea
+		}
+
+		np.logger.Infof("PROCESSOR[%s]: NESTING: %s (nestingFor:%s, statsDuration:%s, cnt:%d/%d, nestHourlyRate:%0.3f, nestPct:%0.3f, gblHourlyRate:%0.3f, gblPct:%0.3f, nestPctToGlobalPctRatio:%0.3f, baseline:%s)",
 			nest,
 			ni.PokemonKey,
-			time.Since(ni.DetectedAt),
+			now.Sub(ni.DetectedAt),
 			time.Minute*time.Duration(ni.StatsDurationMinutes),
 			ni.NestCount,
 			ni.NestTotal,
@@ -378,10 +503,11 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 			ni.GlobalHourlyCount,
 			ni.GlobalPct(),
 			nestToGlobalPctRatio,
+			baseline,
 		)
 
 		partialNest := nest.AsStorePartialUpdatePokemon(now)
-		if err := np.nestsDBStore.UpdateNestPartial(context.Background(), nest.Id, partialNest); err != nil {
+		if err := np.updateNestPartial(context.Background(), nest.Id, partialNest); err != nil {
 			np.logger.Errorf("PROCESSOR[%s]: failed to update DB to set nesting pokemon: %v",
 				nest,
 				err,
@@ -392,23 +518,131 @@ func (np *NestProcessor) ProcessStatsCollection(statsCollection *FrozenStatsColl
 	}
 }
 
-func NewNestProcessor(oldNestProcessor *NestProcessor, logger *logrus.Logger, nestsDBStore *db_store.NestsDBStore, nestMatcher *NestMatcher, webhookSender WebhookSender, config Config) *NestProcessor {
+func additionalPokemonString(ni *models.NestingPokemonInfo) string {
+	if len(ni.AdditionalPokemon) == 0 {
+		return "none"
+	}
+	strs := make([]string, len(ni.AdditionalPokemon))
+	for idx, addl := range ni.AdditionalPokemon {
+		strs[idx] = addl.PokemonKey.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// HandleMigration is called when nests migrate. All stats are purged, as
+// they describe the old nesting pokemon. Nesting pokemon are either marked
+// stale or, if configured, cleared in the DB.
+func (np *NestProcessor) HandleMigration(ctx context.Context) {
+	np.processMutex.Lock()
+	defer np.processMutex.Unlock()
+
+	// this also starts a new generation, so stats taken before now
+	// that are still waiting to be processed are thrown away.
+	numPeriods, duration := np.statsCollection.Reset()
+	np.logger.Infof("MIGRATION: purged %d time period(s) (%s) of stats",
+		numPeriods,
+		duration,
+	)
+
+	now := np.clock.Now()
+	numStale := 0
+	numCleared := 0
+
+	for _, nest := range np.GetNests() {
+		nest.SetPendingChange(nil)
+
+		if ni, _ := nest.GetNestingPokemon(); ni != nil && !ni.Stale {
+			np.endNestHistory(ctx, nest, ni, now)
+		}
+
+		if !np.config.MigrationClearNestingPokemon {
+			if nest.MarkNestingPokemonStale() {
+				numStale++
+			}
+			continue
+		}
+
+		old_ni, _ := nest.SetNestingPokemon(nil, now)
+		if old_ni == nil {
+			continue
+		}
+
+		partialNest := nest.AsStorePartialUpdatePokemon(now)
+		if err := np.updateNestPartial(ctx, nest.Id, partialNest); err != nil {
+			np.logger.Errorf("MIGRATION[%s]: failed to update DB to unset nesting pokemon: %v",
+				nest,
+				err,
+			)
+			continue
+		}
+		nest.SetUpdatedAt(now)
+		numCleared++
+	}
+
+	np.logger.Infof("MIGRATION: marked %d nesting pokemon stale, cleared %d nesting pokemon",
+		numStale,
+		numCleared,
+	)
+}
+
+func NewNestProcessor(oldNestProcessor *NestProcessor, logger *logrus.Logger, clk clock.Clock, nestsDBStore *db_store.NestsDBStore, dryRun bool, nestMatcher *NestMatcher, webhookSender WebhookSender, statsCollector stats_collector.StatsCollector, config Config) (*NestProcessor, error) {
+	speciesRules, err := NewSpeciesRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	nestingStrategy, err := NewNestingStrategy(logger, config, speciesRules)
+	if err != nil {
+		return nil, err
+	}
+
 	nestProcessor := &NestProcessor{
-		logger:        logger,
-		nestsDBStore:  nestsDBStore,
-		nestMatcher:   nestMatcher,
-		webhookSender: webhookSender,
-		config:        config,
+		logger:          logger,
+		clock:           clk,
+		nestsDBStore:    nestsDBStore,
+		dryRun:          dryRun,
+		nestMatcher:     nestMatcher,
+		nestingStrategy: nestingStrategy,
+		speciesRules:    speciesRules,
+		webhookSender:   webhookSender,
+		statsCollector:  statsCollector,
+		config:          config,
 	}
 	if oldNestProcessor == nil {
 		// startup.
-		nestProcessor.statsCollection = NewStatsCollection(logger)
+		nestProcessor.statsCollection = nestProcessor.restoreStatsCollection()
+		nestProcessor.processMutex = &sync.Mutex{}
 	} else {
 		// reload.
 		// these will still contain deleted nests, but those will be
 		// skipped when nesting mon is computed. they'll eventually
 		// cycle out.
 		nestProcessor.statsCollection = oldNestProcessor.statsCollection
+		nestProcessor.processMutex = oldNestProcessor.processMutex
+		nestProcessor.encounterCache = oldNestProcessor.encounterCache
+	}
+
+	if filename := config.EventsFilename; filename != "" {
+		// (re)load from the file. Any changes via the API have
+		// been saved to it.
+		nestProcessor.eventCalendar = NewEventCalendar(logger, filename)
+		if err := nestProcessor.eventCalendar.Load(); err != nil {
+			return nil, err
+		}
+	} else if oldNestProcessor != nil && oldNestProcessor.eventCalendar.Filename() == "" {
+		// keep any events added via the API.
+		nestProcessor.eventCalendar = oldNestProcessor.eventCalendar
+	} else {
+		nestProcessor.eventCalendar = NewEventCalendar(logger, "")
 	}
-	return nestProcessor
+
+	if maxEncounters := config.DedupMaxEncounters; maxEncounters <= 0 {
+		nestProcessor.encounterCache = nil
+	} else if nestProcessor.encounterCache == nil {
+		nestProcessor.encounterCache = NewEncounterCache(maxEncounters)
+	} else {
+		nestProcessor.encounterCache.SetMaxEntries(maxEncounters, clk.Now())
+	}
+
+	return nestProcessor, nil
 }
@@ -0,0 +1,321 @@
+package processor
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+	"github.com/UnownHash/Fletchling/stats_collector"
+)
+
+type testWebhookSender struct {
+	mutex    sync.Mutex
+	webhooks []models.NestingPokemonInfo
+}
+
+func (sender *testWebhookSender) AddNestWebhook(nest *models.Nest, nestingPokemon *models.NestingPokemonInfo) {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	sender.webhooks = append(sender.webhooks, *nestingPokemon)
+}
+
+func (sender *testWebhookSender) Len() int {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	return len(sender.webhooks)
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testNestFeature returns a square nest of 'size' degrees with its
+// south west corner at lat, lon.
+func testNestFeature(nestId int64, lat, lon, size float64) *geojson.Feature {
+	feature := geojson.NewFeature(orb.Polygon{{
+		{lon, lat},
+		{lon + size, lat},
+		{lon + size, lat + size},
+		{lon, lat + size},
+		{lon, lat},
+	}})
+	feature.Properties["id"] = uint64(nestId)
+	feature.Properties["name"] = "nest"
+	return feature
+}
+
+// newTestNest creates a square nest of 'size' degrees with its south
+// west corner at lat, lon.
+func newTestNest(t *testing.T, clk clock.Clock, nestId int64, lat, lon, size float64) *models.Nest {
+	t.Helper()
+
+	nest, err := models.NewNestFromKojiFeature(testNestFeature(nestId, lat, lon, size), clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nest
+}
+
+func newTestNestProcessor(t *testing.T, clk clock.Clock, config Config, webhookSender WebhookSender, nests ...*models.Nest) *NestProcessor {
+	t.Helper()
+
+	logger := newTestLogger()
+
+	nestMatcher := NewNestMatcher(logger, config.NestMatchingPolicy)
+	for _, nest := range nests {
+		if err := nestMatcher.AddNest(nest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	np, err := NewNestProcessor(nil, logger, clk, nil, true, nestMatcher, webhookSender, stats_collector.NewNoopStatsCollector(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return np
+}
+
+func newTestConfig() Config {
+	config := GetDefaultConfig()
+	config.StatsFilename = ""
+	config.EventsFilename = ""
+	config.DedupMaxEncounters = 0
+	return config
+}
+
+// a point inside and outside of the nest created by newTestNest(t, clk, id, 10, 10, 0.01).
+const (
+	testInsideLat  = 10.005
+	testInsideLon  = 10.005
+	testOutsideLat = 20
+	testOutsideLon = 20
+)
+
+func addTestSpawns(np *NestProcessor, pokemonId int, lat, lon float64, num int) {
+	for i := 0; i < num; i++ {
+		np.AddPokemon(&models.Pokemon{PokemonId: pokemonId, Lat: lat, Lon: lon})
+	}
+}
+
+// addTestBackground adds 1000 spawns of 20 other pokemon outside of
+// the nests, so that no pokemon is common globally.
+func addTestBackground(np *NestProcessor) {
+	for pokemonId := 200; pokemonId < 220; pokemonId++ {
+		addTestSpawns(np, pokemonId, testOutsideLat, testOutsideLon, 50)
+	}
+}
+
+func TestAddPokemonSkipSpawnpointsOutsideNests(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	config := newTestConfig()
+	config.SkipSpawnpointsOutsideNests = true
+
+	// the nests' bounding box is 10,10 to 10.06,10.06.
+	nest1 := newTestNest(t, clk, 1, 10, 10, 0.01)
+	nest2 := newTestNest(t, clk, 2, 10.05, 10.05, 0.01)
+	np := newTestNestProcessor(t, clk, config, &testWebhookSender{}, nest1, nest2)
+
+	tests := []struct {
+		desc        string
+		pokemon     *models.Pokemon
+		wantSkipped bool
+	}{
+		{"indexed in a nest", &models.Pokemon{PokemonId: 1, SpawnpointId: 100, Lat: 10.005, Lon: 10.005}, false},
+		{"indexed in the bounding box, in no nest", &models.Pokemon{PokemonId: 1, SpawnpointId: 200, Lat: 10.03, Lon: 10.03}, true},
+		{"indexed outside the bounding box", &models.Pokemon{PokemonId: 1, SpawnpointId: 300, Lat: 20, Lon: 20}, true},
+		{"not indexed, outside the bounding box", &models.Pokemon{PokemonId: 1, SpawnpointId: 400, Lat: 20, Lon: 20}, true},
+		{"not indexed, in a nest", &models.Pokemon{PokemonId: 1, SpawnpointId: 500, Lat: 10.055, Lon: 10.055}, false},
+		{"no spawnpoint, in no nest", &models.Pokemon{PokemonId: 1, Lat: 20, Lon: 20}, true},
+	}
+
+	// without the index, nothing is skipped.
+	for _, test := range tests {
+		if stats := np.AddPokemon(test.pokemon); stats.OutsideNests || !stats.WasCounted {
+			t.Errorf("%s: without index: got %+v, want the pokemon counted", test.desc, stats)
+		}
+	}
+
+	np.SetSpawnpointIndex(&SpawnpointIndex{
+		spawnpoints: map[uint64]uint32{100: 1, 200: 0, 300: 0},
+		nestIdLists: [][]int64{nil, {1}},
+		numInNests:  1,
+	})
+
+	for _, test := range tests {
+		stats := np.AddPokemon(test.pokemon)
+		if test.wantSkipped {
+			if !stats.OutsideNests || stats.WasCounted {
+				t.Errorf("%s: got %+v, want the pokemon skipped", test.desc, stats)
+			}
+		} else if stats.OutsideNests || !stats.WasCounted || stats.NumNestsMatched != 1 {
+			t.Errorf("%s: got %+v, want the pokemon counted in 1 nest", test.desc, stats)
+		}
+	}
+}
+
+func TestProcessStatsCollectionTransitions(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	config := newTestConfig()
+	config.NestChangeMinWins = 2
+
+	webhookSender := &testWebhookSender{}
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	np := newTestNestProcessor(t, clk, config, webhookSender, nest)
+
+	steps := []struct {
+		desc         string
+		pokemonId    int
+		num          int
+		wantNesting  int
+		wantPending  int
+		wantWins     int
+		wantWebhooks int
+	}{
+		{"NEST-START", 1, 100, 1, 0, 0, 1},
+		{"change pending", 4, 100, 1, 4, 1, 1},
+		{"change lost its streak", 1, 100, 1, 0, 0, 1},
+		{"change pending again", 4, 100, 1, 4, 1, 1},
+		{"NEST-CHANGE", 4, 100, 4, 0, 0, 2},
+		{"still nesting", 4, 100, 4, 0, 0, 2},
+		// too few to nest.
+		{"NEST-END", 4, 1, 0, 0, 0, 2},
+		{"NEST-START after end", 1, 100, 1, 0, 0, 3},
+	}
+
+	for _, step := range steps {
+		// every evaluation only sees its own hour.
+		np.PurgeOldestStats(time.Hour + time.Minute)
+		addTestBackground(np)
+		addTestSpawns(np, step.pokemonId, testInsideLat, testInsideLon, step.num)
+		clk.Advance(time.Hour)
+		np.ProcessStatsCollection(np.RotateStats())
+
+		ni, _ := nest.GetNestingPokemon()
+		var nestingId int
+		if ni != nil {
+			nestingId = ni.PokemonKey.PokemonId
+		}
+		if nestingId != step.wantNesting {
+			t.Errorf("%s: got nesting pokemon %d, want %d", step.desc, nestingId, step.wantNesting)
+		}
+
+		pendingChange := nest.GetPendingChange()
+		var pendingId, wins int
+		if pendingChange != nil {
+			pendingId, wins = pendingChange.PokemonKey.PokemonId, pendingChange.Wins
+		}
+		if pendingId != step.wantPending || wins != step.wantWins {
+			t.Errorf("%s: got pending change to %d with %d win(s), want %d with %d", step.desc, pendingId, wins, step.wantPending, step.wantWins)
+		}
+
+		if n := webhookSender.Len(); n != step.wantWebhooks {
+			t.Errorf("%s: got %d webhook(s), want %d", step.desc, n, step.wantWebhooks)
+		}
+	}
+
+	if t.Failed() {
+		return
+	}
+	for idx, want := range []int{1, 4, 1} {
+		if got := webhookSender.webhooks[idx].PokemonKey.PokemonId; got != want {
+			t.Errorf("webhook %d: got pokemon %d, want %d", idx, got, want)
+		}
+	}
+}
+
+func TestProcessStatsCollectionChangeMinMargin(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	config := newTestConfig()
+	config.NestChangeMinWins = 3
+	config.NestChangeMinMargin = 50
+
+	webhookSender := &testWebhookSender{}
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	np := newTestNestProcessor(t, clk, config, webhookSender, nest)
+
+	process := func(spawns map[int]int) {
+		np.PurgeOldestStats(time.Hour + time.Minute)
+		addTestBackground(np)
+		for pokemonId, num := range spawns {
+			addTestSpawns(np, pokemonId, testInsideLat, testInsideLon, num)
+		}
+		clk.Advance(time.Hour)
+		np.ProcessStatsCollection(np.RotateStats())
+	}
+
+	process(map[int]int{1: 100})
+
+	// 60% vs 40% isn't enough of a margin.
+	process(map[int]int{1: 40, 4: 60})
+	if ni, _ := nest.GetNestingPokemon(); ni == nil || ni.PokemonKey.PokemonId != 1 {
+		t.Fatalf("small margin: got nesting pokemon %v, want bulbasaur", ni)
+	}
+	if pendingChange := nest.GetPendingChange(); pendingChange == nil || pendingChange.Margin != 20 {
+		t.Errorf("small margin: got pending change %+v, want a margin of 20", pendingChange)
+	}
+
+	// 100% vs 0% is, without waiting for more wins.
+	process(map[int]int{4: 100})
+	if ni, _ := nest.GetNestingPokemon(); ni == nil || ni.PokemonKey.PokemonId != 4 {
+		t.Errorf("large margin: got nesting pokemon %v, want charmander", ni)
+	}
+	if pendingChange := nest.GetPendingChange(); pendingChange != nil {
+		t.Errorf("large margin: got pending change %+v, want none", pendingChange)
+	}
+	if n := webhookSender.Len(); n != 2 {
+		t.Errorf("got %d webhook(s), want 2", n)
+	}
+}
+
+func TestProcessStatsCollectionAdditionalPokemonWebhook(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	config := newTestConfig()
+	config.MaxNestingPokemon = 2
+
+	webhookSender := &testWebhookSender{}
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	np := newTestNestProcessor(t, clk, config, webhookSender, nest)
+
+	process := func(spawns map[int]int) {
+		np.PurgeOldestStats(time.Hour + time.Minute)
+		addTestBackground(np)
+		for pokemonId, num := range spawns {
+			addTestSpawns(np, pokemonId, testInsideLat, testInsideLon, num)
+		}
+		clk.Advance(time.Hour)
+		np.ProcessStatsCollection(np.RotateStats())
+	}
+
+	process(map[int]int{1: 60})
+	process(map[int]int{1: 60, 4: 40})
+
+	ni, _ := nest.GetNestingPokemon()
+	if ni == nil || ni.PokemonKey.PokemonId != 1 || len(ni.AdditionalPokemon) != 1 || ni.AdditionalPokemon[0].PokemonKey.PokemonId != 4 {
+		t.Fatalf("got nesting pokemon %+v, want bulbasaur with charmander", ni)
+	}
+	if n := webhookSender.Len(); n != 2 {
+		t.Fatalf("got %d webhook(s), want 2", n)
+	}
+	if additional := webhookSender.webhooks[1].AdditionalPokemon; len(additional) != 1 || additional[0].PokemonKey.PokemonId != 4 {
+		t.Errorf("got additional pokemon %+v in webhook, want charmander", additional)
+	}
+
+	// no change, no webhook.
+	process(map[int]int{1: 60, 4: 40})
+	if n := webhookSender.Len(); n != 2 {
+		t.Errorf("got %d webhook(s) without a change, want 2", n)
+	}
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UnownHash/Fletchling/db_store"
+)
+
+// SpawnpointIndex maps the spawnpoints in the Golbat DB to the nests
+// containing them, so that pokemon on a known spawnpoint don't need a
+// point-in-polygon lookup. Many spawnpoints are in the same nests, so
+// each spawnpoint only stores an index into the distinct lists of nest
+// ids. It does not change once built.
+type SpawnpointIndex struct {
+	// index into nestIdLists. 0 is no nests.
+	spawnpoints map[uint64]uint32
+	nestIdLists [][]int64
+	numInNests  int
+	builtAt     time.Time
+}
+
+// Lookup returns the ids of the nests containing the spawnpoint. The
+// bool is false if the spawnpoint is not in the index.
+func (idx *SpawnpointIndex) Lookup(spawnpointId uint64) ([]int64, bool) {
+	listIdx, ok := idx.spawnpoints[spawnpointId]
+	if !ok {
+		return nil, false
+	}
+	return idx.nestIdLists[listIdx], true
+}
+
+// Len returns the number of spawnpoints in the index.
+func (idx *SpawnpointIndex) Len() int {
+	return len(idx.spawnpoints)
+}
+
+// NumInNests returns the number of spawnpoints in at least one nest.
+func (idx *SpawnpointIndex) NumInNests() int {
+	return idx.numInNests
+}
+
+func (idx *SpawnpointIndex) BuiltAt() time.Time {
+	return idx.builtAt
+}
+
+// BuildSpawnpointIndex reads the spawnpoints seen in the last 'maxDays'
+// days within the bounds of the matcher's nests and matches them against
+// the nests.
+func BuildSpawnpointIndex(ctx context.Context, golbatDBStore *db_store.GolbatDBStore, nestMatcher *NestMatcher, maxDays int, now time.Time) (*SpawnpointIndex, error) {
+	idx := &SpawnpointIndex{
+		spawnpoints: make(map[uint64]uint32),
+		// 0 is no nests.
+		nestIdLists: [][]int64{nil},
+		builtAt:     now,
+	}
+
+	if nestMatcher.Len() == 0 {
+		return idx, nil
+	}
+
+	listIdxByKey := make(map[string]uint32)
+	var keyBuilder strings.Builder
+
+	err := golbatDBStore.IterateSpawnpoints(ctx, nestMatcher.Bound(), maxDays, func(spawnpoint db_store.Spawnpoint) error {
+		nests := nestMatcher.GetMatchingNests(spawnpoint.Lat, spawnpoint.Lon)
+		if len(nests) == 0 {
+			idx.spawnpoints[spawnpoint.Id] = 0
+			return nil
+		}
+
+		nestIds := make([]int64, len(nests))
+		for i, nest := range nests {
+			nestIds[i] = nest.Id
+		}
+		sort.Slice(nestIds, func(i, j int) bool { return nestIds[i] < nestIds[j] })
+
+		keyBuilder.Reset()
+		for _, nestId := range nestIds {
+			keyBuilder.WriteString(strconv.FormatInt(nestId, 10))
+			keyBuilder.WriteByte(',')
+		}
+		key := keyBuilder.String()
+
+		listIdx, ok := listIdxByKey[key]
+		if !ok {
+			listIdx = uint32(len(idx.nestIdLists))
+			idx.nestIdLists = append(idx.nestIdLists, nestIds)
+			listIdxByKey[key] = listIdx
+		}
+
+		idx.spawnpoints[spawnpoint.Id] = listIdx
+		idx.numInNests++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spawnpoints from the golbat DB: %w", err)
+	}
+
+	return idx, nil
+}
@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// FormRule collapses forms that don't matter for nesting, like costumes
+// or seasonal forms, into a single form so that their counts are not
+// split up. A rule applies to a pokemon if its id is in PokemonIds and
+// its form is in Forms. An empty list matches anything, but at least
+// one must be given.
+type FormRule struct {
+	PokemonIds []int `koanf:"pokemon_ids" json:"pokemon_ids"`
+	Forms      []int `koanf:"forms" json:"forms"`
+	// The form to count the pokemon as.
+	ToForm int `koanf:"to_form" json:"to_form"`
+}
+
+func (rule *FormRule) writeConfiguration(buf *bytes.Buffer) {
+	buf.WriteString(fmt.Sprintf("{pokemon_ids: %v, forms: %v, to_form: %d}", rule.PokemonIds, rule.Forms, rule.ToForm))
+}
+
+// formRulesEqual returns true if both lists have the same rules in the
+// same order.
+func formRulesEqual(a, b []FormRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if !intsEqual(a[idx].PokemonIds, b[idx].PokemonIds) ||
+			!intsEqual(a[idx].Forms, b[idx].Forms) ||
+			a[idx].ToForm != b[idx].ToForm {
+			return false
+		}
+	}
+	return true
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *FormRule) Validate() error {
+	if len(rule.PokemonIds) == 0 && len(rule.Forms) == 0 {
+		return errors.New("form rule requires pokemon_ids or forms")
+	}
+	if rule.ToForm < 0 {
+		return fmt.Errorf("invalid to_form '%d': must be >= 0", rule.ToForm)
+	}
+	return nil
+}
+
+func (rule *FormRule) matches(pokemonKey models.PokemonKey) bool {
+	return containsOrEmpty(rule.PokemonIds, pokemonKey.PokemonId) &&
+		containsOrEmpty(rule.Forms, pokemonKey.FormId)
+}
+
+func containsOrEmpty(vals []int, val int) bool {
+	if len(vals) == 0 {
+		return true
+	}
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// nestingSpeciesFile is the format of nesting_species_filename. The
+// lists are added to the ones in the config.
+type nestingSpeciesFile struct {
+	NestingSpecies    []int `json:"nesting_species"`
+	NonNestingSpecies []int `json:"non_nesting_species"`
+}
+
+// SpeciesRules decides which pokemon can nest and which form each
+// pokemon is counted as.
+type SpeciesRules struct {
+	// nil allows all species.
+	nesting    map[int]struct{}
+	nonNesting map[int]struct{}
+	formRules  []FormRule
+}
+
+// CanNest returns false for species that were ruled out.
+func (rules *SpeciesRules) CanNest(pokemonId int) bool {
+	if _, ok := rules.nonNesting[pokemonId]; ok {
+		return false
+	}
+	if rules.nesting == nil {
+		return true
+	}
+	_, ok := rules.nesting[pokemonId]
+	return ok
+}
+
+// PokemonKey returns the key to count the pokemon under, with the first
+// matching form rule applied. Pokemon with an unknown form, like what a
+// Ditto is disguised as, have form 0 and go through the same rules.
+func (rules *SpeciesRules) PokemonKey(pokemon *models.Pokemon) models.PokemonKey {
+	pokemonKey := pokemon.Key()
+	for idx := range rules.formRules {
+		if rule := &rules.formRules[idx]; rule.matches(pokemonKey) {
+			pokemonKey.FormId = rule.ToForm
+			break
+		}
+	}
+	return pokemonKey
+}
+
+func speciesSet(set map[int]struct{}, pokemonIds []int) map[int]struct{} {
+	if len(pokemonIds) == 0 {
+		return set
+	}
+	if set == nil {
+		set = make(map[int]struct{}, len(pokemonIds))
+	}
+	for _, pokemonId := range pokemonIds {
+		set[pokemonId] = struct{}{}
+	}
+	return set
+}
+
+// NewSpeciesRules creates the species rules for 'config', reading
+// nesting_species_filename if it is set.
+func NewSpeciesRules(config Config) (*SpeciesRules, error) {
+	rules := &SpeciesRules{
+		nesting:    speciesSet(nil, config.NestingSpecies),
+		nonNesting: speciesSet(nil, config.NonNestingSpecies),
+		formRules:  config.FormRules,
+	}
+
+	if filename := config.NestingSpeciesFilename; filename != "" {
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read nesting species file: %w", err)
+		}
+
+		var speciesFile nestingSpeciesFile
+		if err := json.Unmarshal(contents, &speciesFile); err != nil {
+			return nil, fmt.Errorf("couldn't decode nesting species file '%s': %w", filename, err)
+		}
+
+		rules.nesting = speciesSet(rules.nesting, speciesFile.NestingSpecies)
+		rules.nonNesting = speciesSet(rules.nonNesting, speciesFile.NonNestingSpecies)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestSpeciesRulesPokemonKeyFormUnknown(t *testing.T) {
+	config := newTestConfig()
+	config.FormRules = []FormRule{
+		{PokemonIds: []int{19}, ToForm: 46},
+		{Forms: []int{2335}, ToForm: 0},
+	}
+	rules, err := NewSpeciesRules(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alolanRattata := &models.Pokemon{PokemonId: 19, FormId: 46}
+	costumedPikachu := &models.Pokemon{PokemonId: 25, FormId: 2335}
+	pikachu := &models.Pokemon{PokemonId: 25, FormId: 598}
+	// what a Ditto is disguised as has form 0.
+	dittoAsRattata := &models.Pokemon{PokemonId: 19}
+	dittoAsPikachu := &models.Pokemon{PokemonId: 25}
+
+	check := func(desc string) {
+		t.Helper()
+		// a rule for the whole species counts the disguise with its spawns.
+		if key := rules.PokemonKey(dittoAsRattata); key != rules.PokemonKey(alolanRattata) {
+			t.Errorf("%s: rattata: got %s, want %s", desc, key, rules.PokemonKey(alolanRattata))
+		}
+		// otherwise it is form 0, whatever was seen before.
+		if key := rules.PokemonKey(dittoAsPikachu); key != (models.PokemonKey{PokemonId: 25}) {
+			t.Errorf("%s: pikachu: got %s, want 25:0", desc, key)
+		}
+	}
+
+	check("nothing seen")
+	rules.PokemonKey(pikachu)
+	check("pikachu seen")
+	rules.PokemonKey(costumedPikachu)
+	check("costumed pikachu seen")
+}
@@ -1,25 +1,46 @@
 package processor
 
 import (
+	"fmt"
+	"math"
+	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/UnownHash/Fletchling/processor/clock"
 	"github.com/UnownHash/Fletchling/processor/models"
 )
 
 type AddPokemonStats struct {
+	IsDuplicate     bool
+	InEvent         bool
 	WasCounted      bool
 	NumNestsMatched uint64
+	// OutsideNests is set when the pokemon wasn't counted because its
+	// spawnpoint is in no nest.
+	OutsideNests bool
 }
 
 // This is protected by the other structures using it and
-// doesn't require locking.
+// doesn't require locking. Once counts stop changing, they may be
+// compacted, and ByPokemon is then nil. Use Get, ForEach, and Len to
+// read them.
 type CountsByPokemon struct {
 	Total     uint64                       `json:"total"`
 	ByPokemon map[models.PokemonKey]uint64 `json:"by_pokemon"`
+	// Spawnpoints are the different spawnpoints each pokemon was
+	// seen on. Only kept for nests in time periods, not in totals.
+	Spawnpoints map[models.PokemonKey]SpawnpointSet `json:"spawnpoints,omitempty"`
+
+	// see compact()
+	compactKeyIds           []uint32
+	compactCounts           []uint32
+	compactSpawnpoints      [][]uint64
+	compactTotalSpawnpoints uint64
 }
 
 // returns true if empty now
@@ -34,10 +55,17 @@ func (counts *CountsByPokemon) subtract(logger *logrus.Logger, other *CountsByPo
 			)
 		}
 		counts.ByPokemon = nil
+		counts.Spawnpoints = nil
+		counts.compactKeyIds = nil
+		counts.compactCounts = nil
+		counts.compactSpawnpoints = nil
+		counts.compactTotalSpawnpoints = 0
 		return true
 	}
 
-	for k, v := range other.ByPokemon {
+	counts.expand()
+
+	other.ForEach(func(k models.PokemonKey, v uint64) {
 		counts.ByPokemon[k] -= v
 
 		if counts.ByPokemon[k] <= 0 {
@@ -49,13 +77,26 @@ func (counts *CountsByPokemon) subtract(logger *logrus.Logger, other *CountsByPo
 				)
 			}
 			delete(counts.ByPokemon, k)
-			continue
 		}
-	}
+	})
 
 	return false
 }
 
+// add adds the counts in 'other'. Spawnpoints are only added if
+// 'withSpawnpoints' is set, as they can't be subtracted again.
+func (counts *CountsByPokemon) add(other *CountsByPokemon, withSpawnpoints bool) {
+	counts.expand()
+
+	counts.Total += other.Total
+	other.ForEach(func(k models.PokemonKey, v uint64) {
+		counts.ByPokemon[k] += v
+	})
+	if withSpawnpoints {
+		other.forEachSpawnpoint(counts.addSpawnpoint)
+	}
+}
+
 func (counts *CountsByPokemon) mostSpawningPokemon() (models.PokemonKey, float64) {
 	var pokemon models.PokemonKey
 	var maxCount uint64
@@ -64,22 +105,34 @@ func (counts *CountsByPokemon) mostSpawningPokemon() (models.PokemonKey, float64
 		return pokemon, 0
 	}
 
-	for pokemonKey, count := range counts.ByPokemon {
+	counts.ForEach(func(pokemonKey models.PokemonKey, count uint64) {
 		if count > maxCount {
 			maxCount = count
 			pokemon = pokemonKey
 		}
-	}
+	})
 
 	return pokemon, 100 * float64(maxCount) / float64(counts.Total)
 }
 
 func (counts *CountsByPokemon) clone() *CountsByPokemon {
+	if counts.isCompact() {
+		// compacted counts never change, so they can be shared.
+		return &CountsByPokemon{
+			Total:                   counts.Total,
+			compactKeyIds:           counts.compactKeyIds,
+			compactCounts:           counts.compactCounts,
+			compactSpawnpoints:      counts.compactSpawnpoints,
+			compactTotalSpawnpoints: counts.compactTotalSpawnpoints,
+		}
+	}
+
 	nCounts := NewCountsByPokemon()
 	nCounts.Total = counts.Total
 	for k, v := range counts.ByPokemon {
 		nCounts.ByPokemon[k] = v
 	}
+	counts.forEachSpawnpoint(nCounts.addSpawnpoint)
 	return nCounts
 }
 
@@ -89,18 +142,91 @@ func NewCountsByPokemon() *CountsByPokemon {
 	}
 }
 
+// SkippedRange is a range of time that was not counted.
+type SkippedRange struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason"`
+}
+
+func (sr SkippedRange) Duration() time.Duration {
+	return sr.EndTime.Sub(sr.StartTime)
+}
+
+// countsShard is where a part of the pokemon for the current time
+// period are counted. Each has its own lock, so that webhook workers
+// don't all wait on a single one.
+type countsShard struct {
+	mutex        sync.Mutex
+	nestCounts   map[int64]*CountsByPokemon
+	globalCounts *CountsByPokemon
+	areaCounts   map[string]*CountsByPokemon
+	// keep shards on separate cache lines.
+	_ [64]byte
+}
+
+// requires shard.mutex be locked. 'spawnpointId' is 0 if unknown.
+func (shard *countsShard) addPokemon(pokemonKey models.PokemonKey, spawnpointId uint64, nests []*models.Nest, areaNames []string) {
+	shard.globalCounts.Total++
+	shard.globalCounts.ByPokemon[pokemonKey]++
+
+	for _, nest := range nests {
+		nestCount := shard.nestCounts[nest.Id]
+		if nestCount == nil {
+			nestCount = NewCountsByPokemon()
+			shard.nestCounts[nest.Id] = nestCount
+		}
+		nestCount.Total++
+		nestCount.ByPokemon[pokemonKey]++
+		if spawnpointId != 0 {
+			nestCount.addSpawnpoint(pokemonKey, spawnpointId)
+		}
+	}
+
+	for _, areaName := range areaNames {
+		areaCount := shard.areaCounts[areaName]
+		if areaCount == nil {
+			areaCount = NewCountsByPokemon()
+			shard.areaCounts[areaName] = areaCount
+		}
+		areaCount.Total++
+		areaCount.ByPokemon[pokemonKey]++
+	}
+}
+
+func newCountsShard() *countsShard {
+	return &countsShard{
+		nestCounts:   make(map[int64]*CountsByPokemon),
+		globalCounts: NewCountsByPokemon(),
+		areaCounts:   make(map[string]*CountsByPokemon),
+	}
+}
+
 // CountsForTImePeriod contain pokemon counts for a specific time
 // period. StartTime is set upon creation of the struct. EndTime will
 // be set when the next time period is created.
+//
+// The current time period counts pokemon into shards. They are merged
+// into NestCounts, GlobalCounts, and AreaCounts when it is frozen or
+// cloned.
 type CountsForTimePeriod struct {
 	logger *logrus.Logger
 	mutex  sync.RWMutex
 
+	shards    []*countsShard
+	nextShard atomic.Uint64
+
 	Frozen       bool                       `json:"frozen"`
 	StartTime    time.Time                  `json:"start_time"`
 	EndTime      time.Time                  `json:"end_time"`
 	NestCounts   map[int64]*CountsByPokemon `json:"nest_counts"`
 	GlobalCounts *CountsByPokemon           `json:"global_counts"`
+	// AreaCounts are like GlobalCounts, but per area. Only
+	// kept when area baselines are enabled.
+	AreaCounts map[string]*CountsByPokemon `json:"area_counts,omitempty"`
+	// SkippedRanges are parts of this time period where pokemon were
+	// not counted due to global events. Set on rotation.
+	SkippedRanges []SkippedRange `json:"skipped_ranges,omitempty"`
 }
 
 func (tpCounts *CountsForTimePeriod) clone(endTime time.Time) *CountsForTimePeriod {
@@ -115,55 +241,127 @@ func (tpCounts *CountsForTimePeriod) clone(endTime time.Time) *CountsForTimePeri
 		ntpCounts.NestCounts[k] = v.clone()
 	}
 	ntpCounts.GlobalCounts = tpCounts.GlobalCounts.clone()
+	for k, v := range tpCounts.AreaCounts {
+		ntpCounts.AreaCounts[k] = v.clone()
+	}
+	for _, shard := range tpCounts.shards {
+		shard.mutex.Lock()
+		ntpCounts.addCounts(shard.nestCounts, shard.globalCounts, shard.areaCounts, true)
+		shard.mutex.Unlock()
+	}
+	ntpCounts.SkippedRanges = append([]SkippedRange(nil), tpCounts.SkippedRanges...)
 	return ntpCounts
 }
 
+// freeze merges the shards, compacts the counts, and marks the time
+// period as frozen. Nothing may be adding pokemon.
+func (tpCounts *CountsForTimePeriod) freeze() {
+	tpCounts.mutex.Lock()
+	defer tpCounts.mutex.Unlock()
+
+	for _, shard := range tpCounts.shards {
+		tpCounts.addCounts(shard.nestCounts, shard.globalCounts, shard.areaCounts, true)
+	}
+	tpCounts.shards = nil
+	tpCounts.Frozen = true
+	tpCounts.compact()
+}
+
 func (tpCounts *CountsForTimePeriod) subtract(logger *logrus.Logger, other *CountsForTimePeriod) {
 	if !tpCounts.Frozen {
 		tpCounts.mutex.Lock()
 		defer tpCounts.mutex.Unlock()
 	}
 
-	tpCounts.GlobalCounts.subtract(logger, other.GlobalCounts)
+	if tpCounts.GlobalCounts.subtract(logger, other.GlobalCounts) {
+		// everything was subtracted. totals still get added to.
+		tpCounts.GlobalCounts = NewCountsByPokemon()
+	}
 	for nestId, delNestCount := range other.NestCounts {
 		if tpCounts.NestCounts[nestId].subtract(logger, delNestCount) {
 			delete(tpCounts.NestCounts, nestId)
 		}
 	}
+	for areaName, delAreaCount := range other.AreaCounts {
+		if tpCounts.AreaCounts[areaName].subtract(logger, delAreaCount) {
+			delete(tpCounts.AreaCounts, areaName)
+		}
+	}
+}
+
+// add is the opposite of subtract. It is used to add finished time
+// periods to the totals, so spawnpoints are not added. See
+// spawnpointsLastSeen. 'other' must not have shards.
+func (tpCounts *CountsForTimePeriod) add(other *CountsForTimePeriod) {
+	if !tpCounts.Frozen {
+		tpCounts.mutex.Lock()
+		defer tpCounts.mutex.Unlock()
+	}
+
+	tpCounts.addCounts(other.NestCounts, other.GlobalCounts, other.AreaCounts, false)
+}
+
+// requires tpCounts.mutex be locked, if needed.
+func (tpCounts *CountsForTimePeriod) addCounts(nestCounts map[int64]*CountsByPokemon, globalCounts *CountsByPokemon, areaCounts map[string]*CountsByPokemon, withSpawnpoints bool) {
+	tpCounts.GlobalCounts.add(globalCounts, withSpawnpoints)
+	for nestId, addNestCount := range nestCounts {
+		nestCount := tpCounts.NestCounts[nestId]
+		if nestCount == nil {
+			nestCount = NewCountsByPokemon()
+			tpCounts.NestCounts[nestId] = nestCount
+		}
+		nestCount.add(addNestCount, withSpawnpoints)
+	}
+	for areaName, addAreaCount := range areaCounts {
+		areaCount := tpCounts.AreaCounts[areaName]
+		if areaCount == nil {
+			areaCount = NewCountsByPokemon()
+			tpCounts.AreaCounts[areaName] = areaCount
+		}
+		areaCount.add(addAreaCount, withSpawnpoints)
+	}
 }
 
-func (tpCounts *CountsForTimePeriod) Duration() time.Duration {
+// Duration is the amount of time pokemon were counted in this time period.
+// 'now' is used as the end time if the time period has not ended.
+func (tpCounts *CountsForTimePeriod) Duration(now time.Time) time.Duration {
 	endTime := tpCounts.EndTime
 	if endTime.IsZero() {
-		endTime = time.Now()
+		endTime = now
+	}
+	duration := endTime.Sub(tpCounts.StartTime)
+	for _, skipped := range tpCounts.SkippedRanges {
+		duration -= skipped.Duration()
+	}
+	if duration < 0 {
+		return 0
 	}
-	return endTime.Sub(tpCounts.StartTime).Truncate(time.Minute)
+	return duration.Truncate(time.Minute)
 }
 
-// AddPokemon returns true if pokemon was added, false if stats were found to be frozen (shouldn't happen)
-func (tpCounts *CountsForTimePeriod) AddPokemon(pokemon *models.Pokemon, nests []*models.Nest) bool {
-	tpCounts.mutex.Lock()
-	defer tpCounts.mutex.Unlock()
-
-	if tpCounts.Frozen {
-		// only happens if we have a bug!
+// AddPokemon returns true if pokemon was added, false if stats were found to be frozen (shouldn't happen).
+// Only the current time period can have pokemon added. The caller must ensure it is not frozen
+// at the same time. 'encounterId' and 'spawnpointId' may be 0 if unknown.
+func (tpCounts *CountsForTimePeriod) AddPokemon(encounterId uint64, pokemonKey models.PokemonKey, spawnpointId uint64, nests []*models.Nest, areaNames []string) bool {
+	numShards := uint64(len(tpCounts.shards))
+	if numShards == 0 {
+		// frozen. only happens if we have a bug!
 		return false
 	}
 
-	pokemonKey := pokemon.Key()
-	tpCounts.GlobalCounts.Total++
-	tpCounts.GlobalCounts.ByPokemon[pokemonKey]++
-
-	for _, nest := range nests {
-		nestCount := tpCounts.NestCounts[nest.Id]
-		if nestCount == nil {
-			nestCount = NewCountsByPokemon()
-		}
-		nestCount.Total++
-		nestCount.ByPokemon[pokemonKey]++
-		tpCounts.NestCounts[nest.Id] = nestCount
+	// spread encounters over the shards. The ID is random enough. Without one,
+	// take turns.
+	shardKey := encounterId
+	if shardKey == 0 {
+		shardKey = tpCounts.nextShard.Add(1)
 	}
 
+	shard := tpCounts.shards[shardKey%numShards]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.addPokemon(pokemonKey, spawnpointId, nests, areaNames)
+
 	return true
 }
 
@@ -173,11 +371,11 @@ func (tpCounts *CountsForTimePeriod) GetOrderedGlobalPokemon() PokemonCountAndTo
 		defer tpCounts.mutex.RUnlock()
 	}
 
-	statsList := make(PokemonCountAndTotals, len(tpCounts.GlobalCounts.ByPokemon))
+	statsList := make(PokemonCountAndTotals, tpCounts.GlobalCounts.Len())
 
 	total := tpCounts.GlobalCounts.Total
 	idx := 0
-	for pokemonKey, count := range tpCounts.GlobalCounts.ByPokemon {
+	tpCounts.GlobalCounts.ForEach(func(pokemonKey models.PokemonKey, count uint64) {
 		statsList[idx] = PokemonCountAndTotal{
 			PokemonKey: pokemonKey,
 			Rank:       idx + 1,
@@ -185,7 +383,7 @@ func (tpCounts *CountsForTimePeriod) GetOrderedGlobalPokemon() PokemonCountAndTo
 			Total:      total,
 		}
 		idx++
-	}
+	})
 
 	sort.Sort(statsList)
 
@@ -195,8 +393,9 @@ func (tpCounts *CountsForTimePeriod) GetOrderedGlobalPokemon() PokemonCountAndTo
 // GetSummaryForNests() returns info about the pokemon existing in the nest, sorted by spawn %/count DESC.
 // Since this object is also abused to keep totals for all time periods and there can be gaps due to
 // throwing out time periods, the StartTime/EndTime in this object won't reflect an accurate duration. This
-// must be passed in.
-func (tpCounts *CountsForTimePeriod) GetSummaryForNest(nest *models.Nest, duration time.Duration) *models.NestTimePeriodSummary {
+// must be passed in. If areaBaselineMinPokemon is > 0 and the nest's area has seen at least that many
+// pokemon, the area's counts are used in place of the global counts.
+func (tpCounts *CountsForTimePeriod) GetSummaryForNest(nest *models.Nest, duration time.Duration, areaBaselineMinPokemon uint64) *models.NestTimePeriodSummary {
 	if !tpCounts.Frozen {
 		tpCounts.mutex.RLock()
 		defer tpCounts.mutex.RUnlock()
@@ -208,20 +407,30 @@ func (tpCounts *CountsForTimePeriod) GetSummaryForNest(nest *models.Nest, durati
 	}
 
 	globalCounts := tpCounts.GlobalCounts
+	var baselineArea string
+
+	if areaBaselineMinPokemon > 0 {
+		areaName := nest.AreaName.ValueOrZero()
+		if areaCounts := tpCounts.AreaCounts[areaName]; areaCounts != nil && areaCounts.Total >= areaBaselineMinPokemon {
+			globalCounts = areaCounts
+			baselineArea = areaName
+		}
+	}
 
-	countsAndTotals := make(models.NestPokemonCountsAndTotals, len(nestCounts.ByPokemon))
+	countsAndTotals := make(models.NestPokemonCountsAndTotals, nestCounts.Len())
 	idx := 0
-	for pokemonKey, count := range nestCounts.ByPokemon {
+	nestCounts.ForEach(func(pokemonKey models.PokemonKey, count uint64) {
 		countsAndTotals[idx] = models.NestPokemonCountAndTotal{
 			Rank:        idx + 1,
 			PokemonKey:  pokemonKey,
 			Count:       count,
 			Total:       nestCounts.Total,
-			Global:      globalCounts.ByPokemon[pokemonKey],
+			Global:      globalCounts.Get(pokemonKey),
 			GlobalTotal: globalCounts.Total,
+			Spawnpoints: nestCounts.NumSpawnpoints(pokemonKey),
 		}
 		idx++
-	}
+	})
 
 	sort.Sort(countsAndTotals)
 
@@ -231,6 +440,8 @@ func (tpCounts *CountsForTimePeriod) GetSummaryForNest(nest *models.Nest, durati
 		StartTime:              tpCounts.StartTime,
 		EndTime:                tpCounts.EndTime,
 		Duration:               duration,
+		BaselineArea:           baselineArea,
+		Spawnpoints:            nestCounts.TotalSpawnpoints(),
 	}
 }
 
@@ -240,17 +451,38 @@ func NewCountsForTimePeriod(logger *logrus.Logger, startTime time.Time) *CountsF
 		StartTime:    startTime,
 		NestCounts:   make(map[int64]*CountsByPokemon),
 		GlobalCounts: NewCountsByPokemon(),
+		AreaCounts:   make(map[string]*CountsByPokemon),
+	}
+}
+
+// newCurrentCountsForTimePeriod creates a time period that pokemon can be
+// added to, with a shard per CPU.
+func newCurrentCountsForTimePeriod(logger *logrus.Logger, startTime time.Time) *CountsForTimePeriod {
+	tpCounts := NewCountsForTimePeriod(logger, startTime)
+	tpCounts.shards = make([]*countsShard, runtime.GOMAXPROCS(0))
+	for idx := range tpCounts.shards {
+		tpCounts.shards[idx] = newCountsShard()
 	}
+	return tpCounts
 }
 
 type FrozenStatsCollection struct {
 	// Duration is the sum of the durations of all
 	// time periods.
 	Duration time.Duration
+	// SkippedPeriods are whole time periods that were
+	// thrown away.
+	SkippedPeriods []SkippedRange
 	// CountsByTimePeriod is the list of stats for each time period
 	CountsByTimePeriod []*CountsForTimePeriod
 	// Totals are the sums of stats from all time periods.
 	Totals *CountsForTimePeriod
+	// Generation is the stats collection's generation when this was
+	// taken. It changes when all stats are reset.
+	Generation uint64
+
+	// see NestSpawnpoints()
+	nestSpawnpoints map[int64]*nestSpawnpointCounts
 }
 
 func (fstats *FrozenStatsCollection) Len() int {
@@ -262,18 +494,63 @@ func (fstats *FrozenStatsCollection) LatestEntry() *CountsForTimePeriod {
 	return fstats.CountsByTimePeriod[len(fstats.CountsByTimePeriod)-1]
 }
 
+// NestCoverage estimates the coverage of the nest in each time period
+// and averages them, weighted by duration. Over the whole history,
+// nearly every spawnpoint is seen eventually, even in a nest that is
+// rarely scanned. Time periods where nothing was seen in the nest count
+// as not covered at all. Low is set if it is known and less than 'minPct'.
+func (fstats *FrozenStatsCollection) NestCoverage(nest *models.Nest, minPct float64) models.NestCoverage {
+	var spawnpointsSeen, pct, hourlySightings float64
+	var totalDuration time.Duration
+
+	coverage := models.NewNestCoverage(nest, 0, 0, 0, minPct)
+	if !coverage.Known() {
+		return coverage
+	}
+
+	for _, tpCounts := range fstats.CountsByTimePeriod {
+		duration := tpCounts.Duration(tpCounts.EndTime)
+		if duration <= 0 {
+			continue
+		}
+
+		var tpCoverage models.NestCoverage
+		if nestCounts := tpCounts.NestCounts[nest.Id]; nestCounts != nil {
+			tpCoverage = models.NewNestCoverage(nest, nestCounts.TotalSpawnpoints(), nestCounts.Total, duration, minPct)
+		}
+
+		weight := float64(duration)
+		spawnpointsSeen += weight * float64(tpCoverage.SpawnpointsSeen)
+		pct += weight * tpCoverage.Pct
+		hourlySightings += weight * tpCoverage.HourlySightingsPerSpawnpoint
+		totalDuration += duration
+	}
+
+	if totalDuration > 0 {
+		weight := float64(totalDuration)
+		coverage.SpawnpointsSeen = uint64(math.Round(spawnpointsSeen / weight))
+		coverage.Pct = pct / weight
+		coverage.HourlySightingsPerSpawnpoint = hourlySightings / weight
+	}
+	coverage.Low = coverage.Pct < minPct
+
+	return coverage
+}
+
 // Current stats collection. Duration is only set when returning
 // copies of the stats collection.
 type StatsCollection struct {
 	logger *logrus.Logger
+	clock  clock.Clock
 
-	// Totals are the sums of stats from all time periods.
+	// Totals are the sums of stats from all finished time periods.
+	// The current time period is added to it in snapshots.
 	Totals *CountsForTimePeriod
 
 	// mutex protects only the below addresses being
 	// changed and they only change on rotation or purge.
-	// CountsByTimePeriod is guarded by its own locks,
-	// as is Totals above.
+	// CountsByTimePeriod is guarded by its own locks.
+	// Totals only changes on rotation or purge.
 	mutex sync.RWMutex
 
 	// Duration is the sum of the durations of all
@@ -281,6 +558,24 @@ type StatsCollection struct {
 	Duration time.Duration
 	// CountsByTimePeriod is the list of stats for each time period
 	CountsByTimePeriod []*CountsForTimePeriod
+	// SkippedPeriods are the time periods that were thrown
+	// away within the max history duration.
+	SkippedPeriods []SkippedRange
+	// generation goes up every time all stats are reset.
+	generation uint64
+	// spawnpointsSeen are the spawnpoints seen in the finished
+	// time periods.
+	spawnpointsSeen spawnpointsLastSeen
+}
+
+// requires stats.mutex be write locked. Only finished time periods
+// are part of the totals.
+func (stats *StatsCollection) removeFromTotals(tpCounts *CountsForTimePeriod) {
+	if !tpCounts.Frozen {
+		return
+	}
+	stats.Duration -= tpCounts.Duration(tpCounts.EndTime)
+	stats.Totals.subtract(stats.logger, tpCounts)
 }
 
 // requires stats.mutex be write locked. purges from the front.
@@ -290,6 +585,7 @@ func (stats *StatsCollection) keepRecentStats(keepDuration time.Duration) (int,
 
 	var durPurged time.Duration
 	numPurged := 0
+	now := stats.clock.Now()
 
 	for ; l > 0 && stats.Duration > keepDuration; l-- {
 		var del *CountsForTimePeriod
@@ -297,14 +593,14 @@ func (stats *StatsCollection) keepRecentStats(keepDuration time.Duration) (int,
 		del, counts = counts[0], counts[1:]
 
 		numPurged++
-		durPurged += del.Duration()
+		durPurged += del.Duration(now)
 
-		stats.Duration -= del.Duration()
-		stats.Totals.subtract(stats.logger, del)
+		stats.removeFromTotals(del)
+		stats.spawnpointsSeen.removeOldest(del)
 	}
 
 	if l == 0 {
-		counts = append(counts, NewCountsForTimePeriod(stats.logger, time.Now()))
+		counts = append(counts, newCurrentCountsForTimePeriod(stats.logger, now))
 	}
 
 	// XXX: I'm curious if this will help a certain someone with a ton of nests.
@@ -331,19 +627,20 @@ func (stats *StatsCollection) purgeOldestStats(purgeDuration time.Duration) (int
 
 	var durPurged time.Duration
 	numPurged := 0
+	now := stats.clock.Now()
 
 	// don't purge periods that overlap purgeDuration only
 	// partially. always keep current period.
-	for l := len(counts); l > 1 && (durPurged+counts[0].Duration()) < purgeDuration; l-- {
+	for l := len(counts); l > 1 && (durPurged+counts[0].Duration(now)) < purgeDuration; l-- {
 		var del *CountsForTimePeriod
 
 		del, counts = counts[0], counts[1:]
 
 		numPurged++
-		durPurged += del.Duration()
+		durPurged += del.Duration(now)
 
-		stats.Duration -= del.Duration()
-		stats.Totals.subtract(stats.logger, del)
+		stats.removeFromTotals(del)
+		stats.spawnpointsSeen.removeOldest(del)
 	}
 
 	stats.CountsByTimePeriod = counts
@@ -367,19 +664,19 @@ func (stats *StatsCollection) purgeNewestStats(purgeDuration time.Duration, incl
 
 	var durPurged time.Duration
 	numPurged := 0
+	now := stats.clock.Now()
 
 	// don't purge periods that overlap purgeDuration only
 	// partially.
-	for ; l > 0 && (durPurged+counts[0].Duration()) < purgeDuration; l-- {
+	for ; l > 0 && (durPurged+counts[l-1].Duration(now)) < purgeDuration; l-- {
 		var del *CountsForTimePeriod
 
 		del, counts = counts[l-1], counts[:l-1]
 
 		numPurged++
-		durPurged += del.Duration()
+		durPurged += del.Duration(now)
 
-		stats.Duration -= del.Duration()
-		stats.Totals.subtract(stats.logger, del)
+		stats.removeFromTotals(del)
 	}
 
 	if current != nil {
@@ -387,7 +684,12 @@ func (stats *StatsCollection) purgeNewestStats(purgeDuration time.Duration, incl
 		counts = append(counts, current)
 	} else if numPurged > 0 {
 		// current was removed.
-		counts = append(counts, NewCountsForTimePeriod(stats.logger, time.Now()))
+		counts = append(counts, newCurrentCountsForTimePeriod(stats.logger, now))
+	}
+
+	if numPurged > 0 {
+		// when the purged spawnpoints were seen before isn't kept.
+		stats.spawnpointsSeen = newSpawnpointsLastSeen(counts)
 	}
 
 	stats.CountsByTimePeriod = counts
@@ -403,7 +705,7 @@ func (stats *StatsCollection) Len() int {
 	return len(stats.CountsByTimePeriod)
 }
 
-func (stats *StatsCollection) AddPokemon(pokemon *models.Pokemon, nests []*models.Nest) bool {
+func (stats *StatsCollection) AddPokemon(encounterId uint64, pokemonKey models.PokemonKey, spawnpointId uint64, nests []*models.Nest, areaNames []string) bool {
 	// Yes, we'll be writing, but this lock only protects rotation and purges.
 	// Each time period has its own locking that to protect its structures.
 	stats.mutex.RLock()
@@ -411,12 +713,11 @@ func (stats *StatsCollection) AddPokemon(pokemon *models.Pokemon, nests []*model
 
 	// there's always an entry
 	latest := stats.CountsByTimePeriod[len(stats.CountsByTimePeriod)-1]
-	wasCounted := latest.AddPokemon(pokemon, nests)
+	wasCounted := latest.AddPokemon(encounterId, pokemonKey, spawnpointId, nests, areaNames)
 	if !wasCounted {
 		stats.logger.Warnf("time period unexpectedly frozen when adding pokemon")
 		return false
 	}
-	stats.Totals.AddPokemon(pokemon, nests)
 	return true
 }
 
@@ -426,22 +727,62 @@ func (stats *StatsCollection) GetSnapshot() *FrozenStatsCollection {
 	stats.mutex.RLock()
 	defer stats.mutex.RUnlock()
 
-	counts := stats.CountsByTimePeriod[:]
+	// copy the slice, as we're going to replace the last entry
+	// and the original must keep pointing to the live one.
+	counts := make([]*CountsForTimePeriod, len(stats.CountsByTimePeriod))
+	copy(counts, stats.CountsByTimePeriod)
 
-	now := time.Now()
+	now := stats.clock.Now()
 	// we have to clone the last entry, as it will continue to
 	// be updated after we're done with our lock.
 	lastEntry := counts[len(counts)-1].clone(now)
 	counts[len(counts)-1] = lastEntry
 
 	fstats.CountsByTimePeriod = counts
+	fstats.SkippedPeriods = append([]SkippedRange(nil), stats.SkippedPeriods...)
 	fstats.Totals = stats.Totals.clone(now)
+	fstats.Totals.add(lastEntry)
 	// add the partial period we have to Duration
 	fstats.Duration = stats.Duration + lastEntry.EndTime.Sub(lastEntry.StartTime)
+	fstats.Generation = stats.generation
+	fstats.nestSpawnpoints = stats.spawnpointsSeen.counts(lastEntry)
 
 	return fstats
 }
 
+// Reset throws away all of the stats, including the current time
+// period, and starts a new generation. Returns the number of time
+// periods and the duration thrown away.
+func (stats *StatsCollection) Reset() (int, time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	now := stats.clock.Now()
+
+	numPurged := len(stats.CountsByTimePeriod)
+	durPurged := stats.Duration + stats.CountsByTimePeriod[numPurged-1].Duration(now)
+
+	stats.CountsByTimePeriod = append(
+		make([]*CountsForTimePeriod, 0, 8),
+		newCurrentCountsForTimePeriod(stats.logger, now),
+	)
+	stats.Totals = NewCountsForTimePeriod(stats.logger, now)
+	stats.Duration = 0
+	stats.SkippedPeriods = nil
+	stats.spawnpointsSeen = make(spawnpointsLastSeen)
+	stats.generation++
+
+	return numPurged, durPurged
+}
+
+// Generation returns the current generation. See Reset().
+func (stats *StatsCollection) Generation() uint64 {
+	stats.mutex.RLock()
+	defer stats.mutex.RUnlock()
+
+	return stats.generation
+}
+
 func (stats *StatsCollection) KeepRecent(keepDuration time.Duration) (int, time.Duration) {
 	stats.mutex.Lock()
 	defer stats.mutex.Unlock()
@@ -449,10 +790,21 @@ func (stats *StatsCollection) KeepRecent(keepDuration time.Duration) (int, time.
 	return stats.keepRecentStats(keepDuration)
 }
 
+// requires stats.mutex be write locked.
+func (stats *StatsCollection) keepRecentSkippedPeriods(cutoff time.Time) {
+	skipped := stats.SkippedPeriods
+	for len(skipped) > 0 && skipped[0].EndTime.Before(cutoff) {
+		skipped = skipped[1:]
+	}
+	stats.SkippedPeriods = skipped
+}
+
 // Appends a new empty entry and ensures there's at most 'maxHistory' entries.
 // Returns the current entries unless the current period is being thrown out,
-// in which case, nil will be returned.
-func (stats *StatsCollection) Rotate(maxHistoryDuration time.Duration, skipPeriodMinGlobalSpawnPct float64) *FrozenStatsCollection {
+// in which case, nil will be returned. If an event calendar is given, the
+// parts of the period covered by global events are not counted in its
+// duration and the period is thrown out if events covered all of it.
+func (stats *StatsCollection) Rotate(maxHistoryDuration time.Duration, skipPeriodMinGlobalSpawnPct float64, eventCalendar *EventCalendar) *FrozenStatsCollection {
 	stats.mutex.Lock()
 	defer stats.mutex.Unlock()
 
@@ -460,7 +812,7 @@ func (stats *StatsCollection) Rotate(maxHistoryDuration time.Duration, skipPerio
 	// done behind a read lock on stats.mutex and no one
 	// can have that now.
 
-	now := time.Now()
+	now := stats.clock.Now()
 
 	// The last time period ends now. We don't set the
 	// Totals EndTime until we clone it, and only set it
@@ -474,44 +826,129 @@ func (stats *StatsCollection) Rotate(maxHistoryDuration time.Duration, skipPerio
 
 	latestEntry.EndTime = now
 	// locks no longer necessary on this time period.
-	latestEntry.Frozen = true
+	latestEntry.freeze()
+
+	if eventCalendar != nil {
+		latestEntry.SkippedRanges = eventCalendar.GlobalEventRanges(latestEntry.StartTime, now)
+	}
 
 	var currentStats *FrozenStatsCollection
 
 	// check if we're going to throw this period away.
+	var skipReason string
+
 	pokemonKey, maxGblPct := latestEntry.GlobalCounts.mostSpawningPokemon()
 	if skipPeriodMinGlobalSpawnPct > 0 && maxGblPct > skipPeriodMinGlobalSpawnPct {
-		stats.logger.Infof("Throwing away current time period: %s is spawning at %0.3f%%",
-			pokemonKey,
-			maxGblPct,
-		)
+		skipReason = fmt.Sprintf("%s is spawning at %0.3f%%", pokemonKey, maxGblPct)
+	} else if len(latestEntry.SkippedRanges) > 0 && latestEntry.Duration(now) == 0 {
+		skipReason = "covered by events"
+	}
+
+	if skipReason != "" {
+		stats.logger.Infof("Throwing away current time period: %s", skipReason)
 
-		stats.Totals.subtract(stats.logger, latestEntry)
-		counts[lastIdx] = NewCountsForTimePeriod(stats.logger, now)
+		stats.SkippedPeriods = append(stats.SkippedPeriods, SkippedRange{
+			StartTime: latestEntry.StartTime,
+			EndTime:   now,
+			Reason:    skipReason,
+		})
+
+		// it was never added to Totals.
+		counts[lastIdx] = newCurrentCountsForTimePeriod(stats.logger, now)
 		// in case lastIdx == 0:
 		stats.Totals.StartTime = counts[0].StartTime
 		// fall through to rotate old history out in case config settings
 		// changed.
 	} else {
-		stats.Duration += latestEntry.Duration()
+		stats.Duration += latestEntry.Duration(now)
+		stats.Totals.add(latestEntry)
+		stats.spawnpointsSeen.add(latestEntry)
 		currentStats = &FrozenStatsCollection{
 			Duration: stats.Duration,
 			// nothing will write to the arrays and maps in this
-			// time series anymore, so we don't need to clone these
-			CountsByTimePeriod: counts[:],
+			// time series anymore, so we don't need to clone these.
+			// the slice is copied, as purging old stats below
+			// clears out the entries in the original.
+			CountsByTimePeriod: append([]*CountsForTimePeriod(nil), counts...),
 			// we have to clone these because the stats.Totals map
 			// will continue to be updated.
-			Totals: stats.Totals.clone(now),
+			Totals:          stats.Totals.clone(now),
+			Generation:      stats.generation,
+			nestSpawnpoints: stats.spawnpointsSeen.counts(nil),
 		}
-		counts = append(counts, NewCountsForTimePeriod(stats.logger, now))
+		counts = append(counts, newCurrentCountsForTimePeriod(stats.logger, now))
 		stats.CountsByTimePeriod = counts
 	}
 
 	stats.keepRecentStats(maxHistoryDuration)
+	stats.keepRecentSkippedPeriods(now.Add(-maxHistoryDuration))
+
+	if currentStats != nil {
+		currentStats.SkippedPeriods = append([]SkippedRange(nil), stats.SkippedPeriods...)
+	}
 
 	return currentStats
 }
 
+// Downsample merges back-to-back time periods that ended at or before
+// 'olderThan' into time periods covering up to 'bucketDuration'. The
+// totals do not change, but purging old stats will then purge whole
+// buckets. Returns the number of time periods that were merged away.
+func (stats *StatsCollection) Downsample(olderThan time.Time, bucketDuration time.Duration) int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	counts := stats.CountsByTimePeriod
+	lastIdx := len(counts) - 1
+
+	// build a new slice. Rotate() hands out the old one.
+	newCounts := make([]*CountsForTimePeriod, 0, len(counts))
+	var bucket []*CountsForTimePeriod
+
+	flushBucket := func() {
+		switch len(bucket) {
+		case 0:
+		case 1:
+			newCounts = append(newCounts, bucket[0])
+		default:
+			merged := mergeTimePeriods(bucket)
+			for _, tpCounts := range bucket {
+				stats.Duration -= tpCounts.Duration(tpCounts.EndTime)
+			}
+			stats.Duration += merged.Duration(merged.EndTime)
+			newCounts = append(newCounts, merged)
+		}
+		bucket = nil
+	}
+
+	for idx, tpCounts := range counts {
+		// never the current time period.
+		if idx == lastIdx || !tpCounts.Frozen || tpCounts.EndTime.After(olderThan) {
+			flushBucket()
+			newCounts = append(newCounts, tpCounts)
+			continue
+		}
+
+		if l := len(bucket); l > 0 {
+			// only merge periods without a gap between them, so that
+			// the merged period's duration is right.
+			if !bucket[l-1].EndTime.Equal(tpCounts.StartTime) ||
+				tpCounts.EndTime.Sub(bucket[0].StartTime) > bucketDuration {
+				flushBucket()
+			}
+		}
+
+		bucket = append(bucket, tpCounts)
+	}
+
+	numMerged := len(counts) - len(newCounts)
+	if numMerged > 0 {
+		stats.CountsByTimePeriod = newCounts
+	}
+
+	return numMerged
+}
+
 func (stats *StatsCollection) PurgeOldest(purgeDuration time.Duration) (int, time.Duration) {
 	stats.mutex.Lock()
 	defer stats.mutex.Unlock()
@@ -526,15 +963,18 @@ func (stats *StatsCollection) PurgeNewest(purgeDuration time.Duration, includeCu
 	return stats.purgeNewestStats(purgeDuration, includeCurrent)
 }
 
-func NewStatsCollection(logger *logrus.Logger) *StatsCollection {
+func NewStatsCollection(logger *logrus.Logger, clk clock.Clock) *StatsCollection {
+	now := clk.Now()
 	h := &StatsCollection{
 		logger: logger,
+		clock:  clk,
 		// set up the first entry.
 		CountsByTimePeriod: append(
 			make([]*CountsForTimePeriod, 0, 8),
-			NewCountsForTimePeriod(logger, time.Now()),
+			newCurrentCountsForTimePeriod(logger, now),
 		),
-		Totals: NewCountsForTimePeriod(logger, time.Now()),
+		Totals:          NewCountsForTimePeriod(logger, now),
+		spawnpointsSeen: make(spawnpointsLastSeen),
 	}
 	return h
 }
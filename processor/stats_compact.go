@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// pokemonKeyInterner hands out small ids for pokemon keys so that
+// compacted counts can store a uint32 per pokemon instead of a whole
+// key. Ids are never reused or removed. There are only a few thousand
+// pokemon/form combinations.
+type pokemonKeyInterner struct {
+	mutex sync.RWMutex
+	ids   map[models.PokemonKey]uint32
+	keys  []models.PokemonKey
+}
+
+func (interner *pokemonKeyInterner) id(pokemonKey models.PokemonKey) uint32 {
+	interner.mutex.RLock()
+	id, ok := interner.ids[pokemonKey]
+	interner.mutex.RUnlock()
+
+	if ok {
+		return id
+	}
+
+	interner.mutex.Lock()
+	defer interner.mutex.Unlock()
+
+	if id, ok := interner.ids[pokemonKey]; ok {
+		return id
+	}
+
+	id = uint32(len(interner.keys))
+	interner.ids[pokemonKey] = id
+	interner.keys = append(interner.keys, pokemonKey)
+	return id
+}
+
+// lookupId returns the id for the key, if it has one.
+func (interner *pokemonKeyInterner) lookupId(pokemonKey models.PokemonKey) (uint32, bool) {
+	interner.mutex.RLock()
+	defer interner.mutex.RUnlock()
+
+	id, ok := interner.ids[pokemonKey]
+	return id, ok
+}
+
+// snapshot returns the keys indexed by id. keys is only ever appended
+// to, so the returned slice is safe to use without the lock.
+func (interner *pokemonKeyInterner) snapshot() []models.PokemonKey {
+	interner.mutex.RLock()
+	defer interner.mutex.RUnlock()
+
+	return interner.keys
+}
+
+var pokemonKeys = &pokemonKeyInterner{
+	ids: make(map[models.PokemonKey]uint32),
+}
+
+// compact replaces ByPokemon with sorted slices of interned pokemon ids
+// and counts. This is a fraction of the size of the map and is used for
+// counts that will not change anymore, such as in finished time
+// periods. Counts too large for the compact form are left alone.
+func (counts *CountsByPokemon) compact() {
+	if counts.ByPokemon == nil {
+		return
+	}
+
+	keyIds := make([]uint32, 0, len(counts.ByPokemon))
+	for pokemonKey, count := range counts.ByPokemon {
+		if count > math.MaxUint32 {
+			return
+		}
+		keyIds = append(keyIds, pokemonKeys.id(pokemonKey))
+	}
+
+	sort.Slice(keyIds, func(i, j int) bool { return keyIds[i] < keyIds[j] })
+
+	keys := pokemonKeys.snapshot()
+	compactCounts := make([]uint32, len(keyIds))
+	for idx, keyId := range keyIds {
+		compactCounts[idx] = uint32(counts.ByPokemon[keys[keyId]])
+	}
+
+	// before compactKeyIds is set, while the spawnpoints are still sets.
+	counts.compactTotalSpawnpoints = counts.TotalSpawnpoints()
+	counts.compactKeyIds = keyIds
+	counts.compactCounts = compactCounts
+	counts.compactSpawnpoints = counts.compactSpawnpointsFor(keys, keyIds)
+	counts.ByPokemon = nil
+	counts.Spawnpoints = nil
+}
+
+func (counts *CountsByPokemon) isCompact() bool {
+	return counts.compactKeyIds != nil
+}
+
+// expand converts compacted counts back into ByPokemon so that they
+// can be changed again.
+func (counts *CountsByPokemon) expand() {
+	if !counts.isCompact() {
+		if counts.ByPokemon == nil {
+			counts.ByPokemon = make(map[models.PokemonKey]uint64)
+		}
+		return
+	}
+
+	byPokemon := make(map[models.PokemonKey]uint64, len(counts.compactKeyIds))
+	counts.ForEach(func(pokemonKey models.PokemonKey, count uint64) {
+		byPokemon[pokemonKey] = count
+	})
+
+	counts.ByPokemon = byPokemon
+	counts.Spawnpoints = counts.expandSpawnpoints()
+	counts.compactKeyIds = nil
+	counts.compactCounts = nil
+	counts.compactSpawnpoints = nil
+	counts.compactTotalSpawnpoints = 0
+}
+
+// Len returns the number of different pokemon counted.
+func (counts *CountsByPokemon) Len() int {
+	if counts.isCompact() {
+		return len(counts.compactKeyIds)
+	}
+	return len(counts.ByPokemon)
+}
+
+// Get returns the count for a pokemon.
+func (counts *CountsByPokemon) Get(pokemonKey models.PokemonKey) uint64 {
+	if !counts.isCompact() {
+		return counts.ByPokemon[pokemonKey]
+	}
+
+	if idx, ok := counts.compactIndex(pokemonKey); ok {
+		return uint64(counts.compactCounts[idx])
+	}
+	return 0
+}
+
+// compactIndex returns the index of the pokemon in the compacted counts.
+func (counts *CountsByPokemon) compactIndex(pokemonKey models.PokemonKey) (int, bool) {
+	keyId, ok := pokemonKeys.lookupId(pokemonKey)
+	if !ok {
+		return 0, false
+	}
+
+	keyIds := counts.compactKeyIds
+	idx := sort.Search(len(keyIds), func(i int) bool { return keyIds[i] >= keyId })
+	return idx, idx < len(keyIds) && keyIds[idx] == keyId
+}
+
+// ForEach calls fn with every pokemon's count.
+func (counts *CountsByPokemon) ForEach(fn func(models.PokemonKey, uint64)) {
+	if !counts.isCompact() {
+		for pokemonKey, count := range counts.ByPokemon {
+			fn(pokemonKey, count)
+		}
+		return
+	}
+
+	keys := pokemonKeys.snapshot()
+	for idx, keyId := range counts.compactKeyIds {
+		fn(keys[keyId], uint64(counts.compactCounts[idx]))
+	}
+}
+
+// MarshalJSON always writes ByPokemon, so that compacted counts look
+// the same in the API and the stats file.
+func (counts *CountsByPokemon) MarshalJSON() ([]byte, error) {
+	type plainCountsByPokemon struct {
+		Total       uint64                              `json:"total"`
+		ByPokemon   map[models.PokemonKey]uint64        `json:"by_pokemon"`
+		Spawnpoints map[models.PokemonKey]SpawnpointSet `json:"spawnpoints,omitempty"`
+	}
+
+	plain := plainCountsByPokemon{
+		Total:       counts.Total,
+		ByPokemon:   counts.ByPokemon,
+		Spawnpoints: counts.Spawnpoints,
+	}
+
+	if counts.isCompact() {
+		plain.ByPokemon = make(map[models.PokemonKey]uint64, len(counts.compactKeyIds))
+		counts.ForEach(func(pokemonKey models.PokemonKey, count uint64) {
+			plain.ByPokemon[pokemonKey] = count
+		})
+		plain.Spawnpoints = counts.expandSpawnpoints()
+	}
+
+	return json.Marshal(&plain)
+}
+
+// compact compacts all of the counts. The time period must be frozen.
+func (tpCounts *CountsForTimePeriod) compact() {
+	tpCounts.GlobalCounts.compact()
+	for _, nestCounts := range tpCounts.NestCounts {
+		nestCounts.compact()
+	}
+	for _, areaCounts := range tpCounts.AreaCounts {
+		areaCounts.compact()
+	}
+}
+
+// mergeTimePeriods combines back-to-back frozen time periods into a
+// single compacted one.
+func mergeTimePeriods(tpCountsList []*CountsForTimePeriod) *CountsForTimePeriod {
+	first, last := tpCountsList[0], tpCountsList[len(tpCountsList)-1]
+
+	merged := NewCountsForTimePeriod(first.logger, first.StartTime)
+	merged.EndTime = last.EndTime
+	for _, tpCounts := range tpCountsList {
+		merged.addCounts(tpCounts.NestCounts, tpCounts.GlobalCounts, tpCounts.AreaCounts, true)
+		merged.SkippedRanges = append(merged.SkippedRanges, tpCounts.SkippedRanges...)
+	}
+	merged.Frozen = true
+	merged.compact()
+
+	return merged
+}
@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+const statsFileVersion = 1
+
+// savedStats is what is written to the stats file. Totals are
+// not saved. They are re-computed from the time periods on load.
+type savedStats struct {
+	Version            int                    `json:"version"`
+	SavedAt            time.Time              `json:"saved_at"`
+	CountsByTimePeriod []*CountsForTimePeriod `json:"counts_by_time_period"`
+	// SkippedPeriods are missing from older files.
+	SkippedPeriods []SkippedRange `json:"skipped_periods,omitempty"`
+}
+
+// Save writes a snapshot of the stats to 'filename' as gzipped json. The
+// current unfinished time period is saved as if it ended now. The file is
+// written to a temp file first and then renamed, so a crash while saving
+// will not clobber the last good save.
+func (stats *StatsCollection) Save(filename string) error {
+	fstats := stats.GetSnapshot()
+
+	saved := savedStats{
+		Version:            statsFileVersion,
+		SavedAt:            fstats.Totals.EndTime,
+		CountsByTimePeriod: fstats.CountsByTimePeriod,
+		SkippedPeriods:     fstats.SkippedPeriods,
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer f.Close()
+
+		gzWriter := gzip.NewWriter(f)
+		if err := json.NewEncoder(gzWriter).Encode(&saved); err != nil {
+			return fmt.Errorf("couldn't json encode stats: %w", err)
+		}
+		if err := gzWriter.Close(); err != nil {
+			return err
+		}
+		return f.Close()
+	}()
+
+	if err != nil {
+		if unlinkErr := os.Remove(f.Name()); unlinkErr != nil {
+			stats.logger.Warnf("failed to remove tmpfile '%s': %v", f.Name(), unlinkErr)
+		}
+		return err
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("failed to rename tmp stats file: %s -> %s: %w", f.Name(), filename, err)
+	}
+
+	return nil
+}
+
+// LoadStatsCollection creates a new StatsCollection from a file written by
+// Save(). Time periods and skipped periods that ended more than
+// 'maxHistoryDuration' ago are dropped, as are the counts for any nest
+// where 'keepNest' returns false.
+// A new current time period is started, so there will be a gap in the
+// stats covering the time we were not running.
+func LoadStatsCollection(logger *logrus.Logger, clk clock.Clock, filename string, maxHistoryDuration time.Duration, keepNest func(int64) bool) (*StatsCollection, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gzReader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s': %w", filename, err)
+	}
+	defer gzReader.Close()
+
+	var saved savedStats
+
+	if err := json.NewDecoder(gzReader).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("couldn't decode '%s': %w", filename, err)
+	}
+
+	if saved.Version != statsFileVersion {
+		return nil, fmt.Errorf("'%s' has unsupported version %d", filename, saved.Version)
+	}
+
+	now := clk.Now()
+	cutoff := now.Add(-maxHistoryDuration)
+
+	stats := &StatsCollection{
+		logger:             logger,
+		clock:              clk,
+		CountsByTimePeriod: make([]*CountsForTimePeriod, 0, len(saved.CountsByTimePeriod)+1),
+		Totals:             NewCountsForTimePeriod(logger, now),
+		spawnpointsSeen:    make(spawnpointsLastSeen),
+	}
+
+	for _, tpCounts := range saved.CountsByTimePeriod {
+		if tpCounts == nil || tpCounts.EndTime.IsZero() || tpCounts.EndTime.Before(cutoff) {
+			continue
+		}
+
+		tpCounts.logger = logger
+		tpCounts.Frozen = true
+
+		if tpCounts.NestCounts == nil {
+			tpCounts.NestCounts = make(map[int64]*CountsByPokemon)
+		}
+		if tpCounts.GlobalCounts == nil {
+			tpCounts.GlobalCounts = NewCountsByPokemon()
+		}
+		if tpCounts.AreaCounts == nil {
+			tpCounts.AreaCounts = make(map[string]*CountsByPokemon)
+		}
+
+		for nestId, nestCounts := range tpCounts.NestCounts {
+			if nestCounts == nil || !keepNest(nestId) {
+				delete(tpCounts.NestCounts, nestId)
+				continue
+			}
+			if nestCounts.ByPokemon == nil {
+				nestCounts.ByPokemon = make(map[models.PokemonKey]uint64)
+			}
+		}
+
+		for areaName, areaCounts := range tpCounts.AreaCounts {
+			if areaCounts == nil {
+				delete(tpCounts.AreaCounts, areaName)
+				continue
+			}
+			if areaCounts.ByPokemon == nil {
+				areaCounts.ByPokemon = make(map[models.PokemonKey]uint64)
+			}
+		}
+
+		stats.Duration += tpCounts.Duration(now)
+		stats.Totals.add(tpCounts)
+		tpCounts.compact()
+		stats.spawnpointsSeen.add(tpCounts)
+		stats.CountsByTimePeriod = append(stats.CountsByTimePeriod, tpCounts)
+	}
+
+	stats.CountsByTimePeriod = append(stats.CountsByTimePeriod, newCurrentCountsForTimePeriod(logger, now))
+	stats.keepRecentStats(maxHistoryDuration)
+
+	stats.SkippedPeriods = saved.SkippedPeriods
+	stats.keepRecentSkippedPeriods(cutoff)
+
+	return stats, nil
+}
@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestStatsCollectionSaveAndLoad(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nests := []*models.Nest{
+		newTestNest(t, clk, 1, 10, 10, 0.01),
+		newTestNest(t, clk, 2, 10, 10, 0.01),
+	}
+	filename := filepath.Join(t.TempDir(), "stats.json.gz")
+
+	pikachu := models.PokemonKey{PokemonId: 25}
+	eevee := models.PokemonKey{PokemonId: 133}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	// skipped, and too old to be kept when loaded.
+	stats.AddPokemon(0, pikachu, 0, nil, nil)
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 50, nil)
+
+	clk.Advance(2 * time.Hour)
+
+	// skipped, and kept.
+	stats.AddPokemon(0, pikachu, 0, nil, nil)
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 50, nil)
+
+	for i := 0; i < 10; i++ {
+		stats.AddPokemon(0, pikachu, uint64(100+i%3), nests, []string{"a"})
+		stats.AddPokemon(0, eevee, 200, nests[:1], nil)
+	}
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 50, nil)
+
+	// the current time period is saved as if it ended now.
+	stats.AddPokemon(0, eevee, 201, nests[:1], nil)
+	clk.Advance(30 * time.Minute)
+
+	if err := stats.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	want := stats.GetSnapshot()
+
+	clk.Advance(time.Hour)
+
+	// nest 2 is gone.
+	keepNest := func(nestId int64) bool { return nestId == 1 }
+	loaded, err := LoadStatsCollection(newTestLogger(), clk, filename, 4*time.Hour, keepNest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.GetSnapshot()
+
+	// both saved time periods, plus a new current one.
+	if got.Len() != 3 {
+		t.Fatalf("got %d time periods, want 3", got.Len())
+	}
+	if got.Duration != want.Duration {
+		t.Errorf("got a duration of %s, want %s", got.Duration, want.Duration)
+	}
+
+	if len(got.SkippedPeriods) != 1 || !got.SkippedPeriods[0].StartTime.Equal(want.SkippedPeriods[1].StartTime) {
+		t.Errorf("got skipped periods %v, want the second of %v", got.SkippedPeriods, want.SkippedPeriods)
+	}
+
+	if _, ok := got.Totals.NestCounts[2]; ok {
+		t.Errorf("got counts for nest 2, which is not kept")
+	}
+	wantNest, gotNest := want.Totals.NestCounts[1], got.Totals.NestCounts[1]
+	if gotNest == nil || gotNest.Total != wantNest.Total || gotNest.Get(pikachu) != 10 || gotNest.Get(eevee) != 11 {
+		t.Fatalf("got nest counts %+v, want %+v", gotNest, wantNest)
+	}
+	if got.Totals.GlobalCounts.Total != want.Totals.GlobalCounts.Total || got.Totals.AreaCounts["a"].Total != 10 {
+		t.Errorf("got %d global and %d area pokemon, want %d and 10", got.Totals.GlobalCounts.Total, got.Totals.AreaCounts["a"].Total, want.Totals.GlobalCounts.Total)
+	}
+
+	byPokemon, total := got.NestSpawnpoints(1)
+	if byPokemon[pikachu] != 3 || byPokemon[eevee] != 2 || total != 5 {
+		t.Errorf("got spawnpoints %v and %d total, want 3 for pikachu, 2 for eevee, 5 total", byPokemon, total)
+	}
+}
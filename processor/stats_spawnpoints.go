@@ -0,0 +1,317 @@
+package processor
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// SpawnpointSet is the distinct spawnpoints a pokemon was seen on. Only
+// how many there are is used, so nothing is counted per spawnpoint. It
+// is written as a sorted list of spawnpoint ids.
+type SpawnpointSet map[uint64]struct{}
+
+func (set SpawnpointSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(set.sorted())
+}
+
+func (set *SpawnpointSet) UnmarshalJSON(b []byte) error {
+	var spawnpointIds []uint64
+	if err := json.Unmarshal(b, &spawnpointIds); err != nil {
+		return err
+	}
+	*set = make(SpawnpointSet, len(spawnpointIds))
+	for _, spawnpointId := range spawnpointIds {
+		(*set)[spawnpointId] = struct{}{}
+	}
+	return nil
+}
+
+func (set SpawnpointSet) sorted() []uint64 {
+	spawnpointIds := make([]uint64, 0, len(set))
+	for spawnpointId := range set {
+		spawnpointIds = append(spawnpointIds, spawnpointId)
+	}
+	sort.Slice(spawnpointIds, func(i, j int) bool { return spawnpointIds[i] < spawnpointIds[j] })
+	return spawnpointIds
+}
+
+// requires counts not be compacted.
+func (counts *CountsByPokemon) addSpawnpoint(pokemonKey models.PokemonKey, spawnpointId uint64) {
+	if counts.Spawnpoints == nil {
+		counts.Spawnpoints = make(map[models.PokemonKey]SpawnpointSet)
+	}
+	spawnpoints := counts.Spawnpoints[pokemonKey]
+	if spawnpoints == nil {
+		spawnpoints = make(SpawnpointSet)
+		counts.Spawnpoints[pokemonKey] = spawnpoints
+	}
+	spawnpoints[spawnpointId] = struct{}{}
+}
+
+// forEachSpawnpoint calls fn with every spawnpoint every pokemon was
+// seen on.
+func (counts *CountsByPokemon) forEachSpawnpoint(fn func(models.PokemonKey, uint64)) {
+	if !counts.isCompact() {
+		for pokemonKey, spawnpoints := range counts.Spawnpoints {
+			for spawnpointId := range spawnpoints {
+				fn(pokemonKey, spawnpointId)
+			}
+		}
+		return
+	}
+
+	if counts.compactSpawnpoints == nil {
+		return
+	}
+
+	keys := pokemonKeys.snapshot()
+	for idx, keyId := range counts.compactKeyIds {
+		for _, spawnpointId := range counts.compactSpawnpoints[idx] {
+			fn(keys[keyId], spawnpointId)
+		}
+	}
+}
+
+// NumSpawnpoints returns the number of different spawnpoints the
+// pokemon was seen on.
+func (counts *CountsByPokemon) NumSpawnpoints(pokemonKey models.PokemonKey) uint64 {
+	if !counts.isCompact() {
+		return uint64(len(counts.Spawnpoints[pokemonKey]))
+	}
+
+	if counts.compactSpawnpoints == nil {
+		return 0
+	}
+
+	idx, ok := counts.compactIndex(pokemonKey)
+	if !ok {
+		return 0
+	}
+	return uint64(len(counts.compactSpawnpoints[idx]))
+}
+
+// TotalSpawnpoints returns the number of different spawnpoints any
+// pokemon was seen on. Compacted counts keep the number, as it is
+// needed for every time period when estimating coverage.
+func (counts *CountsByPokemon) TotalSpawnpoints() uint64 {
+	if counts.isCompact() {
+		return counts.compactTotalSpawnpoints
+	}
+
+	seen := make(map[uint64]struct{})
+	counts.forEachSpawnpoint(func(_ models.PokemonKey, spawnpointId uint64) {
+		seen[spawnpointId] = struct{}{}
+	})
+	return uint64(len(seen))
+}
+
+// compactSpawnpointsFor returns the spawnpoints for the keys in
+// 'keyIds' as sorted slices, which take a fraction of the memory of
+// the sets.
+func (counts *CountsByPokemon) compactSpawnpointsFor(keys []models.PokemonKey, keyIds []uint32) [][]uint64 {
+	if len(counts.Spawnpoints) == 0 {
+		return nil
+	}
+
+	compactSpawnpoints := make([][]uint64, len(keyIds))
+	for idx, keyId := range keyIds {
+		if spawnpoints := counts.Spawnpoints[keys[keyId]]; len(spawnpoints) > 0 {
+			compactSpawnpoints[idx] = spawnpoints.sorted()
+		}
+	}
+	return compactSpawnpoints
+}
+
+// expandSpawnpoints returns the compacted spawnpoints as sets.
+func (counts *CountsByPokemon) expandSpawnpoints() map[models.PokemonKey]SpawnpointSet {
+	if counts.compactSpawnpoints == nil {
+		return nil
+	}
+
+	keys := pokemonKeys.snapshot()
+	byPokemon := make(map[models.PokemonKey]SpawnpointSet, len(counts.compactKeyIds))
+	for idx, keyId := range counts.compactKeyIds {
+		spawnpointIds := counts.compactSpawnpoints[idx]
+		if len(spawnpointIds) == 0 {
+			continue
+		}
+		spawnpoints := make(SpawnpointSet, len(spawnpointIds))
+		for _, spawnpointId := range spawnpointIds {
+			spawnpoints[spawnpointId] = struct{}{}
+		}
+		byPokemon[keys[keyId]] = spawnpoints
+	}
+	return byPokemon
+}
+
+// nestSpawnpointsLastSeen is when each spawnpoint was last seen in a
+// nest, as the end time of the time period, in unix nanoseconds.
+type nestSpawnpointsLastSeen struct {
+	byPokemon map[models.PokemonKey]map[uint64]int64
+	all       map[uint64]int64
+}
+
+// spawnpointsLastSeen keeps the distinct spawnpoints seen in each nest
+// over the finished time periods of a stats collection. Distinct
+// spawnpoints can't be subtracted when old time periods are purged, but
+// a spawnpoint that was last seen in a purged time period can be
+// dropped. This keeps the time periods' lists from being merged again
+// on every evaluation.
+type spawnpointsLastSeen map[int64]*nestSpawnpointsLastSeen
+
+// add adds a finished time period. Time periods must be added in order.
+func (seen spawnpointsLastSeen) add(tpCounts *CountsForTimePeriod) {
+	endTime := tpCounts.EndTime.UnixNano()
+	for nestId, nestCounts := range tpCounts.NestCounts {
+		nestSeen := seen[nestId]
+		nestCounts.forEachSpawnpoint(func(pokemonKey models.PokemonKey, spawnpointId uint64) {
+			if nestSeen == nil {
+				nestSeen = &nestSpawnpointsLastSeen{
+					byPokemon: make(map[models.PokemonKey]map[uint64]int64),
+					all:       make(map[uint64]int64),
+				}
+				seen[nestId] = nestSeen
+			}
+			pokemonSeen := nestSeen.byPokemon[pokemonKey]
+			if pokemonSeen == nil {
+				pokemonSeen = make(map[uint64]int64)
+				nestSeen.byPokemon[pokemonKey] = pokemonSeen
+			}
+			pokemonSeen[spawnpointId] = endTime
+			nestSeen.all[spawnpointId] = endTime
+		})
+	}
+}
+
+// removeOldest removes the oldest finished time period. Spawnpoints that
+// were seen again later are kept. This also works for time periods that
+// were merged by Downsample(), as only their end time is used.
+func (seen spawnpointsLastSeen) removeOldest(tpCounts *CountsForTimePeriod) {
+	if !tpCounts.Frozen {
+		return
+	}
+
+	endTime := tpCounts.EndTime.UnixNano()
+	for nestId, nestCounts := range tpCounts.NestCounts {
+		nestSeen := seen[nestId]
+		if nestSeen == nil {
+			continue
+		}
+		nestCounts.forEachSpawnpoint(func(pokemonKey models.PokemonKey, spawnpointId uint64) {
+			if pokemonSeen := nestSeen.byPokemon[pokemonKey]; pokemonSeen != nil && pokemonSeen[spawnpointId] <= endTime {
+				delete(pokemonSeen, spawnpointId)
+				if len(pokemonSeen) == 0 {
+					delete(nestSeen.byPokemon, pokemonKey)
+				}
+			}
+			if lastSeen, ok := nestSeen.all[spawnpointId]; ok && lastSeen <= endTime {
+				delete(nestSeen.all, spawnpointId)
+			}
+		})
+		if len(nestSeen.all) == 0 {
+			delete(seen, nestId)
+		}
+	}
+}
+
+// newSpawnpointsLastSeen returns the spawnpoints seen in the finished
+// time periods in 'counts'. This is used when time periods are removed
+// from the end, which happens only when purging by hand.
+func newSpawnpointsLastSeen(counts []*CountsForTimePeriod) spawnpointsLastSeen {
+	seen := make(spawnpointsLastSeen)
+	for _, tpCounts := range counts {
+		if tpCounts.Frozen {
+			seen.add(tpCounts)
+		}
+	}
+	return seen
+}
+
+// nestSpawnpointCounts are the number of distinct spawnpoints seen in a
+// nest. See FrozenStatsCollection.NestSpawnpoints().
+type nestSpawnpointCounts struct {
+	byPokemon map[models.PokemonKey]uint64
+	total     uint64
+}
+
+// counts returns the number of distinct spawnpoints seen in each nest.
+// If 'current' is given, the spawnpoints seen in it are added, too.
+func (seen spawnpointsLastSeen) counts(current *CountsForTimePeriod) map[int64]*nestSpawnpointCounts {
+	byNest := make(map[int64]*nestSpawnpointCounts, len(seen))
+	for nestId, nestSeen := range seen {
+		nestCounts := &nestSpawnpointCounts{
+			byPokemon: make(map[models.PokemonKey]uint64, len(nestSeen.byPokemon)),
+			total:     uint64(len(nestSeen.all)),
+		}
+		for pokemonKey, pokemonSeen := range nestSeen.byPokemon {
+			nestCounts.byPokemon[pokemonKey] = uint64(len(pokemonSeen))
+		}
+		byNest[nestId] = nestCounts
+	}
+
+	if current == nil {
+		return byNest
+	}
+
+	for nestId, currentNestCounts := range current.NestCounts {
+		nestSeen := seen[nestId]
+		nestCounts := byNest[nestId]
+		newSpawnpoints := make(map[uint64]struct{})
+
+		currentNestCounts.forEachSpawnpoint(func(pokemonKey models.PokemonKey, spawnpointId uint64) {
+			if nestCounts == nil {
+				nestCounts = &nestSpawnpointCounts{byPokemon: make(map[models.PokemonKey]uint64)}
+				byNest[nestId] = nestCounts
+			}
+			if nestSeen == nil {
+				nestCounts.byPokemon[pokemonKey]++
+				newSpawnpoints[spawnpointId] = struct{}{}
+				return
+			}
+			if _, ok := nestSeen.byPokemon[pokemonKey][spawnpointId]; !ok {
+				nestCounts.byPokemon[pokemonKey]++
+			}
+			if _, ok := nestSeen.all[spawnpointId]; !ok {
+				newSpawnpoints[spawnpointId] = struct{}{}
+			}
+		})
+
+		if nestCounts != nil {
+			nestCounts.total += uint64(len(newSpawnpoints))
+		}
+	}
+
+	return byNest
+}
+
+// NestSpawnpoints returns how many different spawnpoints each pokemon
+// was seen on in the nest over all of the time periods, and how many
+// different spawnpoints any pokemon was seen on. These are counted
+// when the snapshot is taken. See spawnpointsLastSeen.
+func (fstats *FrozenStatsCollection) NestSpawnpoints(nestId int64) (map[models.PokemonKey]uint64, uint64) {
+	nestCounts := fstats.nestSpawnpoints[nestId]
+	if nestCounts == nil {
+		return nil, 0
+	}
+	return nestCounts.byPokemon, nestCounts.total
+}
+
+// GetSummaryForNest returns the summary of the nest over all of the
+// time periods. Returns nil if there are no stats for the nest.
+func (fstats *FrozenStatsCollection) GetSummaryForNest(nest *models.Nest, areaBaselineMinPokemon uint64) *models.NestTimePeriodSummary {
+	summary := fstats.Totals.GetSummaryForNest(nest, fstats.Duration, areaBaselineMinPokemon)
+	if summary == nil {
+		return nil
+	}
+
+	byPokemon, total := fstats.NestSpawnpoints(nest.Id)
+	for idx := range summary.PokemonCountsAndTotals {
+		pokStats := &summary.PokemonCountsAndTotals[idx]
+		pokStats.Spawnpoints = byPokemon[pokStats.PokemonKey]
+	}
+	summary.Spawnpoints = total
+
+	return summary
+}
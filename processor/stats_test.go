@@ -0,0 +1,584 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+func TestStatsCollectionNestSpawnpoints(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	nests := []*models.Nest{nest}
+
+	pikachu := models.PokemonKey{PokemonId: 25}
+	eevee := models.PokemonKey{PokemonId: 133}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	// the same spawnpoint many times only counts once.
+	for i := 0; i < 10; i++ {
+		stats.AddPokemon(0, pikachu, 100, nests, nil)
+	}
+	stats.AddPokemon(0, pikachu, 101, nests, nil)
+	stats.AddPokemon(0, eevee, 100, nests, nil)
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 0, nil)
+
+	stats.AddPokemon(0, pikachu, 101, nests, nil)
+	stats.AddPokemon(0, pikachu, 102, nests, nil)
+	// no spawnpoint.
+	stats.AddPokemon(0, eevee, 0, nests, nil)
+	clk.Advance(time.Hour)
+	fstats := stats.Rotate(24*time.Hour, 0, nil)
+
+	byPokemon, total := fstats.NestSpawnpoints(nest.Id)
+	if byPokemon[pikachu] != 3 || byPokemon[eevee] != 1 || total != 3 {
+		t.Errorf("got %v and %d total, want 3 for pikachu, 1 for eevee, 3 total", byPokemon, total)
+	}
+
+	summary := fstats.GetSummaryForNest(nest, 0)
+	if summary.Spawnpoints != 3 {
+		t.Errorf("got %d spawnpoints in summary, want 3", summary.Spawnpoints)
+	}
+	for _, pokStats := range summary.PokemonCountsAndTotals {
+		if pokStats.Spawnpoints != byPokemon[pokStats.PokemonKey] {
+			t.Errorf("%s: got %d spawnpoints in summary, want %d", pokStats.PokemonKey, pokStats.Spawnpoints, byPokemon[pokStats.PokemonKey])
+		}
+	}
+
+	// spawnpoints only seen in the purged period are gone.
+	if numPurged, _ := stats.PurgeOldest(time.Hour + time.Minute); numPurged != 1 {
+		t.Fatalf("purged %d time period(s), want 1", numPurged)
+	}
+	byPokemon, total = stats.GetSnapshot().NestSpawnpoints(nest.Id)
+	if byPokemon[pikachu] != 2 || byPokemon[eevee] != 0 || total != 2 {
+		t.Errorf("after purge: got %v and %d total, want 2 for pikachu, 0 for eevee, 2 total", byPokemon, total)
+	}
+}
+
+// mergedNestSpawnpoints merges the spawnpoints of all of the time
+// periods, to check what the stats collection keeps as it goes.
+func mergedNestSpawnpoints(fstats *FrozenStatsCollection, nestId int64) (map[models.PokemonKey]uint64, uint64) {
+	merged := NewCountsByPokemon()
+	for _, tpCounts := range fstats.CountsByTimePeriod {
+		if nestCounts := tpCounts.NestCounts[nestId]; nestCounts != nil {
+			nestCounts.forEachSpawnpoint(merged.addSpawnpoint)
+		}
+	}
+
+	byPokemon := make(map[models.PokemonKey]uint64)
+	for pokemonKey, spawnpoints := range merged.Spawnpoints {
+		byPokemon[pokemonKey] = uint64(len(spawnpoints))
+	}
+	return byPokemon, merged.TotalSpawnpoints()
+}
+
+func TestStatsCollectionNestSpawnpointsMatchMerged(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nests := []*models.Nest{
+		newTestNest(t, clk, 1, 10, 10, 0.01),
+		newTestNest(t, clk, 2, 10, 10, 0.01),
+		newTestNest(t, clk, 3, 10, 10, 0.01),
+	}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	// every time period sees some of the spawnpoints of the one
+	// before and some new ones.
+	addPeriod := func(period int) {
+		for i := 0; i < 200; i++ {
+			pokemonKey := models.PokemonKey{PokemonId: 1 + (i+period)%3}
+			spawnpointId := uint64(1 + period*7 + i%20)
+			stats.AddPokemon(0, pokemonKey, spawnpointId, nests[:1+i%len(nests)], nil)
+		}
+	}
+
+	check := func(desc string, fstats *FrozenStatsCollection) {
+		t.Helper()
+		for _, nest := range nests {
+			byPokemon, total := fstats.NestSpawnpoints(nest.Id)
+			wantByPokemon, wantTotal := mergedNestSpawnpoints(fstats, nest.Id)
+			if total != wantTotal || len(byPokemon) != len(wantByPokemon) {
+				t.Errorf("%s: nest %d: got %d spawnpoints for %d pokemon, want %d for %d", desc, nest.Id, total, len(byPokemon), wantTotal, len(wantByPokemon))
+			}
+			for pokemonKey, want := range wantByPokemon {
+				if n := byPokemon[pokemonKey]; n != want {
+					t.Errorf("%s: nest %d: got %d spawnpoints for %s, want %d", desc, nest.Id, n, pokemonKey, want)
+				}
+			}
+		}
+	}
+
+	// old time periods are rotated out after 4 hours.
+	for period := 0; period < 6; period++ {
+		addPeriod(period)
+		clk.Advance(30 * time.Minute)
+		check(fmt.Sprintf("period %d: snapshot", period), stats.GetSnapshot())
+		clk.Advance(30 * time.Minute)
+		check(fmt.Sprintf("period %d: rotate", period), stats.Rotate(4*time.Hour, 0, nil))
+		check(fmt.Sprintf("period %d: after rotate", period), stats.GetSnapshot())
+	}
+
+	if numMerged := stats.Downsample(clk.Now(), 2*time.Hour); numMerged == 0 {
+		t.Fatalf("downsample merged nothing")
+	}
+	check("downsample", stats.GetSnapshot())
+
+	// the merged time period is rotated out.
+	addPeriod(6)
+	clk.Advance(time.Hour)
+	check("downsample: rotate", stats.Rotate(3*time.Hour, 0, nil))
+	check("downsample: after rotate", stats.GetSnapshot())
+
+	addPeriod(7)
+	if numPurged, _ := stats.PurgeNewest(time.Hour+time.Minute, false); numPurged != 1 {
+		t.Fatalf("purge newest: purged %d time period(s), want 1", numPurged)
+	}
+	check("purge newest", stats.GetSnapshot())
+
+	// the oldest may be a merged time period.
+	if numPurged, _ := stats.PurgeOldest(2*time.Hour + time.Minute); numPurged == 0 {
+		t.Fatalf("purge oldest: purged nothing")
+	}
+	check("purge oldest", stats.GetSnapshot())
+
+	stats.Reset()
+	check("reset", stats.GetSnapshot())
+}
+
+func TestCountsByPokemonSpawnpointsJSON(t *testing.T) {
+	pikachu := models.PokemonKey{PokemonId: 25}
+
+	counts := NewCountsByPokemon()
+	for _, spawnpointId := range []uint64{300, 100, 200, 100} {
+		counts.Total++
+		counts.ByPokemon[pikachu]++
+		counts.addSpawnpoint(pikachu, spawnpointId)
+	}
+	counts.compact()
+
+	b, err := json.Marshal(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"total":4,"by_pokemon":{"25:0":4},"spawnpoints":{"25:0":[100,200,300]}}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	var decoded CountsByPokemon
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if n := decoded.NumSpawnpoints(pikachu); n != 3 {
+		t.Errorf("got %d spawnpoints after decoding, want 3", n)
+	}
+}
+
+func TestFrozenStatsCollectionNestCoverage(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+	numSpawnpoints := int64(4)
+	nest.Spawnpoints = &numSpawnpoints
+	nests := []*models.Nest{nest}
+
+	pikachu := models.PokemonKey{PokemonId: 25}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	// every spawnpoint, twice.
+	for spawnpointId := uint64(1); spawnpointId <= 4; spawnpointId++ {
+		stats.AddPokemon(0, pikachu, spawnpointId, nests, nil)
+		stats.AddPokemon(0, pikachu, spawnpointId, nests, nil)
+	}
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 0, nil)
+
+	// one spawnpoint.
+	stats.AddPokemon(0, pikachu, 1, nests, nil)
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 0, nil)
+
+	// not scanned at all.
+	stats.AddPokemon(0, pikachu, 0, nil, nil)
+	clk.Advance(2 * time.Hour)
+	fstats := stats.Rotate(24*time.Hour, 0, nil)
+
+	coverage := fstats.NestCoverage(nest, 50)
+
+	// (100% * 1h + 25% * 1h + 0% * 2h) / 4h
+	if coverage.Pct != 31.25 {
+		t.Errorf("got coverage %0.3f%%, want 31.25%%", coverage.Pct)
+	}
+	// (8/4 * 1h + 1/4 * 1h) / 4h
+	if want := 2.25 / 4; coverage.HourlySightingsPerSpawnpoint != want {
+		t.Errorf("got %0.3f hourly sightings per spawnpoint, want %0.3f", coverage.HourlySightingsPerSpawnpoint, want)
+	}
+	if coverage.SpawnpointsSeen != 1 || coverage.Spawnpoints != 4 || !coverage.Low {
+		t.Errorf("got %+v, want 1 of 4 spawnpoints seen and low", coverage)
+	}
+
+	nest.Spawnpoints = nil
+	if coverage := fstats.NestCoverage(nest, 50); coverage.Known() || coverage.Low {
+		t.Errorf("got %+v without a spawnpoint count, want unknown and not low", coverage)
+	}
+}
+
+func TestStatsCollectionRotateAndPurge(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+
+	pikachu := models.PokemonKey{PokemonId: 25}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	addPokemon := func(num int) {
+		for i := 0; i < num; i++ {
+			stats.AddPokemon(0, pikachu, 0, nil, nil)
+		}
+	}
+
+	checkSnapshot := func(desc string, wantLen int, wantStart time.Time, wantDuration time.Duration, wantTotal uint64) {
+		t.Helper()
+		fstats := stats.GetSnapshot()
+		if fstats.Len() != wantLen {
+			t.Errorf("%s: got %d time period(s), want %d", desc, fstats.Len(), wantLen)
+		}
+		if !fstats.Totals.StartTime.Equal(wantStart) {
+			t.Errorf("%s: got start time %s, want %s", desc, fstats.Totals.StartTime, wantStart)
+		}
+		if fstats.Duration != wantDuration {
+			t.Errorf("%s: got duration %s, want %s", desc, fstats.Duration, wantDuration)
+		}
+		if fstats.Totals.GlobalCounts.Total != wantTotal {
+			t.Errorf("%s: got %d pokemon, want %d", desc, fstats.Totals.GlobalCounts.Total, wantTotal)
+		}
+	}
+
+	// periods of 1h, 2h and 30m with 1, 2 and 3 pokemon.
+	for idx, duration := range []time.Duration{time.Hour, 2 * time.Hour, 30 * time.Minute} {
+		addPokemon(idx + 1)
+		clk.Advance(duration)
+		fstats := stats.Rotate(24*time.Hour, 0, nil)
+		if fstats == nil {
+			t.Fatalf("period %d: thrown away", idx+1)
+		}
+		if fstats.Len() != idx+1 {
+			t.Errorf("period %d: got %d time period(s), want %d", idx+1, fstats.Len(), idx+1)
+		}
+		if latest := fstats.LatestEntry(); !latest.EndTime.Equal(clk.Now()) || latest.EndTime.Sub(latest.StartTime) != duration {
+			t.Errorf("period %d: got %s to %s, want %s ending now", idx+1, latest.StartTime, latest.EndTime, duration)
+		}
+	}
+
+	// the current period.
+	addPokemon(4)
+	clk.Advance(10 * time.Minute)
+
+	checkSnapshot("rotated", 4, start, 3*time.Hour+40*time.Minute, 10)
+
+	// only whole periods are purged: 1h fits in 90m, 1h+2h does not.
+	if numPurged, durPurged := stats.PurgeOldest(90 * time.Minute); numPurged != 1 || durPurged != time.Hour {
+		t.Errorf("purge oldest: purged %d time period(s) of %s, want 1 of 1h", numPurged, durPurged)
+	}
+	checkSnapshot("purge oldest", 3, start.Add(time.Hour), 2*time.Hour+40*time.Minute, 9)
+
+	// the 30m period, not the current one.
+	if numPurged, durPurged := stats.PurgeNewest(time.Hour, false); numPurged != 1 || durPurged != 30*time.Minute {
+		t.Errorf("purge newest: purged %d time period(s) of %s, want 1 of 30m", numPurged, durPurged)
+	}
+	checkSnapshot("purge newest", 2, start.Add(time.Hour), 2*time.Hour+10*time.Minute, 6)
+
+	// the current period is replaced by an empty one.
+	if numPurged, durPurged := stats.PurgeNewest(time.Hour, true); numPurged != 1 || durPurged != 10*time.Minute {
+		t.Errorf("purge newest with current: purged %d time period(s) of %s, want 1 of 10m", numPurged, durPurged)
+	}
+	checkSnapshot("purge newest with current", 2, start.Add(time.Hour), 2*time.Hour, 2)
+
+	// the oldest period is pushed out of the history.
+	addPokemon(5)
+	clk.Advance(time.Hour)
+	fstats := stats.Rotate(90*time.Minute, 0, nil)
+	if fstats == nil || fstats.Len() != 2 || fstats.Duration != 3*time.Hour {
+		t.Errorf("rotate: got %+v, want the 2 time period(s) of 3h before purging", fstats)
+	}
+	checkSnapshot("rotate with max history", 2, clk.Now().Add(-time.Hour), time.Hour, 5)
+}
+
+func TestStatsCollectionRotateSkipsPeriod(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	// one pokemon is most of the spawns.
+	for i := 0; i < 10; i++ {
+		stats.AddPokemon(0, models.PokemonKey{PokemonId: 25}, 0, nil, nil)
+	}
+	stats.AddPokemon(0, models.PokemonKey{PokemonId: 133}, 0, nil, nil)
+	clk.Advance(time.Hour)
+
+	if fstats := stats.Rotate(24*time.Hour, 40, nil); fstats != nil {
+		t.Fatalf("got %d time period(s), want the period thrown away", fstats.Len())
+	}
+
+	fstats := stats.GetSnapshot()
+	if fstats.Duration != 0 || fstats.Totals.GlobalCounts.Total != 0 {
+		t.Errorf("got %s and %d pokemon, want nothing counted", fstats.Duration, fstats.Totals.GlobalCounts.Total)
+	}
+	if !fstats.Totals.StartTime.Equal(clk.Now()) {
+		t.Errorf("got start time %s, want %s", fstats.Totals.StartTime, clk.Now())
+	}
+	if l := len(fstats.SkippedPeriods); l != 1 {
+		t.Fatalf("got %d skipped period(s), want 1", l)
+	}
+	if skipped := fstats.SkippedPeriods[0]; !skipped.StartTime.Equal(start) || skipped.Duration() != time.Hour {
+		t.Errorf("got skipped period %+v, want the hour from %s", skipped, start)
+	}
+}
+
+// testCounts counts pokemon the simple way, without shards or
+// compacting, to check the stats against.
+type testCounts struct {
+	global      map[models.PokemonKey]uint64
+	nests       map[int64]map[models.PokemonKey]uint64
+	spawnpoints map[int64]map[models.PokemonKey]map[uint64]struct{}
+	areas       map[string]map[models.PokemonKey]uint64
+}
+
+func newTestCounts() *testCounts {
+	return &testCounts{
+		global:      make(map[models.PokemonKey]uint64),
+		nests:       make(map[int64]map[models.PokemonKey]uint64),
+		spawnpoints: make(map[int64]map[models.PokemonKey]map[uint64]struct{}),
+		areas:       make(map[string]map[models.PokemonKey]uint64),
+	}
+}
+
+func (counts *testCounts) addPokemon(pokemonKey models.PokemonKey, spawnpointId uint64, nests []*models.Nest, areaNames []string) {
+	counts.global[pokemonKey]++
+	for _, nest := range nests {
+		if counts.nests[nest.Id] == nil {
+			counts.nests[nest.Id] = make(map[models.PokemonKey]uint64)
+			counts.spawnpoints[nest.Id] = make(map[models.PokemonKey]map[uint64]struct{})
+		}
+		counts.nests[nest.Id][pokemonKey]++
+		if spawnpointId == 0 {
+			continue
+		}
+		if counts.spawnpoints[nest.Id][pokemonKey] == nil {
+			counts.spawnpoints[nest.Id][pokemonKey] = make(map[uint64]struct{})
+		}
+		counts.spawnpoints[nest.Id][pokemonKey][spawnpointId] = struct{}{}
+	}
+	for _, areaName := range areaNames {
+		if counts.areas[areaName] == nil {
+			counts.areas[areaName] = make(map[models.PokemonKey]uint64)
+		}
+		counts.areas[areaName][pokemonKey]++
+	}
+}
+
+func checkTestCounts(t *testing.T, desc string, counts *CountsByPokemon, want map[models.PokemonKey]uint64) {
+	t.Helper()
+
+	if counts == nil {
+		if len(want) > 0 {
+			t.Errorf("%s: got no counts, want %d pokemon", desc, len(want))
+		}
+		return
+	}
+
+	var wantTotal uint64
+	for pokemonKey, wantCount := range want {
+		wantTotal += wantCount
+		if count := counts.Get(pokemonKey); count != wantCount {
+			t.Errorf("%s: got %d of %s, want %d", desc, count, pokemonKey, wantCount)
+		}
+	}
+	if counts.Total != wantTotal || counts.Len() != len(want) {
+		t.Errorf("%s: got %d of %d pokemon, want %d of %d", desc, counts.Total, counts.Len(), wantTotal, len(want))
+	}
+}
+
+// check compares the time period's counts. Spawnpoints are only
+// compared if 'withSpawnpoints' is set, as they are not kept in the
+// totals.
+func (counts *testCounts) check(t *testing.T, desc string, tpCounts *CountsForTimePeriod, withSpawnpoints bool) {
+	t.Helper()
+
+	checkTestCounts(t, desc+": global", tpCounts.GlobalCounts, counts.global)
+
+	if len(tpCounts.NestCounts) != len(counts.nests) {
+		t.Errorf("%s: got %d nest(s), want %d", desc, len(tpCounts.NestCounts), len(counts.nests))
+	}
+	for nestId, want := range counts.nests {
+		nestCounts := tpCounts.NestCounts[nestId]
+		checkTestCounts(t, fmt.Sprintf("%s: nest %d", desc, nestId), nestCounts, want)
+		if !withSpawnpoints || nestCounts == nil {
+			continue
+		}
+		for pokemonKey := range want {
+			if n, wantN := nestCounts.NumSpawnpoints(pokemonKey), uint64(len(counts.spawnpoints[nestId][pokemonKey])); n != wantN {
+				t.Errorf("%s: nest %d: got %d spawnpoints for %s, want %d", desc, nestId, n, pokemonKey, wantN)
+			}
+		}
+	}
+
+	if len(tpCounts.AreaCounts) != len(counts.areas) {
+		t.Errorf("%s: got %d area(s), want %d", desc, len(tpCounts.AreaCounts), len(counts.areas))
+	}
+	for areaName, want := range counts.areas {
+		checkTestCounts(t, fmt.Sprintf("%s: area %s", desc, areaName), tpCounts.AreaCounts[areaName], want)
+	}
+}
+
+// checkNestSpawnpoints compares the spawnpoints collected from the time
+// periods.
+func (counts *testCounts) checkNestSpawnpoints(t *testing.T, desc string, fstats *FrozenStatsCollection) {
+	t.Helper()
+
+	for nestId, wantByPokemon := range counts.spawnpoints {
+		byPokemon, total := fstats.NestSpawnpoints(nestId)
+		wantTotal := make(map[uint64]struct{})
+		for pokemonKey, spawnpoints := range wantByPokemon {
+			if n := byPokemon[pokemonKey]; n != uint64(len(spawnpoints)) {
+				t.Errorf("%s: nest %d: got %d spawnpoints for %s, want %d", desc, nestId, n, pokemonKey, len(spawnpoints))
+			}
+			for spawnpointId := range spawnpoints {
+				wantTotal[spawnpointId] = struct{}{}
+			}
+		}
+		if len(byPokemon) != len(wantByPokemon) || total != uint64(len(wantTotal)) {
+			t.Errorf("%s: nest %d: got %d spawnpoints for %d pokemon, want %d for %d", desc, nestId, total, len(byPokemon), len(wantTotal), len(wantByPokemon))
+		}
+	}
+}
+
+// testSpawn returns the i'th of a made up mix of pokemon in 0 to 3 nests
+// and 0 to 2 areas, with and without spawnpoints and encounter ids.
+func testSpawn(i int, nests []*models.Nest) (uint64, models.PokemonKey, uint64, []*models.Nest, []string) {
+	var encounterId uint64
+	if i%5 != 0 {
+		encounterId = uint64(i) * 2654435761
+	}
+	pokemonKey := models.PokemonKey{PokemonId: 1 + i%7, FormId: i % 2}
+	spawnpointId := uint64(i % 50)
+	var areaNames []string
+	switch i % 3 {
+	case 0:
+		areaNames = []string{"a"}
+	case 1:
+		areaNames = []string{"a", "b"}
+	}
+	return encounterId, pokemonKey, spawnpointId, nests[:i%(len(nests)+1)], areaNames
+}
+
+// addTestSpawnsConcurrently adds 'num' spawns from a few goroutines and
+// counts them in 'counts' as well.
+func addTestSpawnsConcurrently(stats *StatsCollection, nests []*models.Nest, start, num int, counts *testCounts) {
+	const numWorkers = 8
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for worker := 0; worker < numWorkers; worker++ {
+		go func(worker int) {
+			defer wg.Done()
+			for i := start + worker; i < start+num; i += numWorkers {
+				stats.AddPokemon(testSpawn(i, nests))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	for i := start; i < start+num; i++ {
+		_, pokemonKey, spawnpointId, spawnNests, areaNames := testSpawn(i, nests)
+		counts.addPokemon(pokemonKey, spawnpointId, spawnNests, areaNames)
+	}
+}
+
+func TestStatsCollectionShardsMatchUnsharded(t *testing.T) {
+	// a shard per CPU. make sure there are a few.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nests := []*models.Nest{
+		newTestNest(t, clk, 1, 10, 10, 0.01),
+		newTestNest(t, clk, 2, 10, 10, 0.01),
+		newTestNest(t, clk, 3, 10, 10, 0.01),
+	}
+
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	period1 := newTestCounts()
+	addTestSpawnsConcurrently(stats, nests, 0, 5000, period1)
+	clk.Advance(30 * time.Minute)
+
+	// the current time period is merged when cloned.
+	fstats := stats.GetSnapshot()
+	period1.check(t, "snapshot: current", fstats.LatestEntry(), true)
+	period1.check(t, "snapshot: totals", fstats.Totals, false)
+	period1.checkNestSpawnpoints(t, "snapshot", fstats)
+
+	// and merged and compacted when frozen.
+	addTestSpawnsConcurrently(stats, nests, 5000, 5000, period1)
+	clk.Advance(30 * time.Minute)
+	fstats = stats.Rotate(24*time.Hour, 0, nil)
+	latest := fstats.LatestEntry()
+	if latest.shards != nil || !latest.GlobalCounts.isCompact() {
+		t.Errorf("rotate: time period was not merged and compacted")
+	}
+	period1.check(t, "rotate: frozen", latest, true)
+	period1.check(t, "rotate: totals", fstats.Totals, false)
+	period1.checkNestSpawnpoints(t, "rotate", fstats)
+
+	period2 := newTestCounts()
+	addTestSpawnsConcurrently(stats, nests, 20000, 3000, period2)
+	clk.Advance(time.Hour)
+	stats.Rotate(24*time.Hour, 0, nil)
+
+	// after purging the first time period, the totals are the second's.
+	if numPurged, _ := stats.PurgeOldest(time.Hour + time.Minute); numPurged != 1 {
+		t.Fatalf("purge: purged %d time period(s), want 1", numPurged)
+	}
+	fstats = stats.GetSnapshot()
+	period2.check(t, "purge: totals", fstats.Totals, false)
+	period2.checkNestSpawnpoints(t, "purge", fstats)
+
+	// and after purging the second, there's nothing left.
+	if numPurged, _ := stats.PurgeNewest(time.Hour+time.Minute, false); numPurged != 1 {
+		t.Fatalf("purge newest: purged %d time period(s), want 1", numPurged)
+	}
+	newTestCounts().check(t, "purge newest: totals", stats.GetSnapshot().Totals, false)
+}
+
+func BenchmarkStatsCollectionAddPokemon(b *testing.B) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nests := make([]*models.Nest, 3)
+	for idx := range nests {
+		nest, err := models.NewNestFromKojiFeature(testNestFeature(int64(idx+1), 10, 10, 0.01), clk)
+		if err != nil {
+			b.Fatal(err)
+		}
+		nests[idx] = nest
+	}
+
+	// the shards are created for GOMAXPROCS, which -cpu sets.
+	stats := NewStatsCollection(newTestLogger(), clk)
+
+	var next atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stats.AddPokemon(testSpawn(int(next.Add(1)), nests))
+		}
+	})
+}
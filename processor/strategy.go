@@ -0,0 +1,366 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+const (
+	NESTING_STRATEGY_THRESHOLD = "threshold"
+	NESTING_STRATEGY_SCORE     = "score"
+)
+
+// NestingStrategy decides which pokemon, if any, is nesting in
+// a nest given the stats summary for the nest. If logPrefix is
+// not empty, the candidates and decisions should be logged with it.
+// The candidates that were considered are returned along with the
+// nesting pokemon.
+type NestingStrategy interface {
+	Name() string
+	ComputeNesting(summary models.NestTimePeriodSummary, logPrefix string) (*models.NestingPokemonInfo, []models.NestingCandidate)
+}
+
+func NewNestingStrategy(logger *logrus.Logger, config Config, speciesRules *SpeciesRules) (NestingStrategy, error) {
+	switch config.NestingStrategy {
+	case "", NESTING_STRATEGY_THRESHOLD:
+		return &thresholdStrategy{logger: logger, config: config, speciesRules: speciesRules}, nil
+	case NESTING_STRATEGY_SCORE:
+		return &scoreStrategy{logger: logger, config: config, speciesRules: speciesRules}, nil
+	default:
+		return nil, fmt.Errorf("unknown nesting_strategy '%s'", config.NestingStrategy)
+	}
+}
+
+// newNestingPokemonInfo creates a NestingPokemonInfo for the pokemon in
+// 'pokStats' using the time period in 'summary'.
+func newNestingPokemonInfo(summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal) *models.NestingPokemonInfo {
+	hours := float64(summary.Duration) / float64(time.Hour)
+
+	return &models.NestingPokemonInfo{
+		PokemonKey: pokStats.PokemonKey,
+
+		StatsDurationMinutes: uint64(summary.Duration / time.Minute),
+
+		UpdatedAt:  summary.EndTime,
+		DetectedAt: summary.EndTime,
+
+		NestCount:       pokStats.Count,
+		NestTotal:       pokStats.Total,
+		NestHourlyCount: float64(pokStats.Count) / hours,
+		NestHourlyTotal: float64(pokStats.Total) / hours,
+		Spawnpoints:     pokStats.Spawnpoints,
+		NestSpawnpoints: summary.Spawnpoints,
+
+		GlobalCount:       pokStats.Global,
+		GlobalTotal:       pokStats.GlobalTotal,
+		GlobalHourlyCount: float64(pokStats.Global) / hours,
+		GlobalHourlyTotal: float64(pokStats.GlobalTotal) / hours,
+		BaselineArea:      summary.BaselineArea,
+	}
+}
+
+// checkSpawnpoints returns why the pokemon was seen on too few of the
+// nest's spawnpoints or "" if it was seen on enough. Only the spawnpoints
+// something was seen on count, so that unscanned ones don't matter.
+func checkSpawnpoints(cfg Config, summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal) string {
+	minPct := cfg.MinNestSpawnpointPct
+	if minPct <= 0 || summary.Spawnpoints == 0 {
+		return ""
+	}
+
+	if spawnpointPct := pokStats.SpawnpointPct(summary.Spawnpoints); spawnpointPct < minPct {
+		return fmt.Sprintf("this pokemon was seen on too few of the nest's spawnpoints (%d/%d = %0.3f%% < %0.3f%%)", pokStats.Spawnpoints, summary.Spawnpoints, spawnpointPct, minPct)
+	}
+
+	return ""
+}
+
+// newAdditionalNestingPokemon creates an AdditionalNestingPokemon for the
+// pokemon in 'pokStats' using the time period in 'summary'.
+func newAdditionalNestingPokemon(summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal, confidence float64) models.AdditionalNestingPokemon {
+	hours := float64(summary.Duration) / float64(time.Hour)
+
+	return models.AdditionalNestingPokemon{
+		PokemonKey:      pokStats.PokemonKey,
+		NestCount:       pokStats.Count,
+		NestHourlyCount: float64(pokStats.Count) / hours,
+		NestPct:         pokStats.NestPct(),
+		Confidence:      confidence,
+	}
+}
+
+// nestingScore is how much more a pokemon spawns in the nest than
+// we'd expect, weighted by how unusual that is. 0 if it does not
+// spawn more in the nest than globally.
+func nestingScore(pokStats models.NestPokemonCountAndTotal) float64 {
+	nestPct := pokStats.NestPct()
+	gblPct := pokStats.GlobalPct()
+
+	if nestPct <= gblPct || gblPct <= 0 {
+		return 0
+	}
+
+	return (nestPct - gblPct) * math.Log2(nestPct/gblPct)
+}
+
+// confidences returns each score's share of the total.
+func confidences(scores []float64) []float64 {
+	var totalScore float64
+	for _, score := range scores {
+		totalScore += score
+	}
+
+	confidences := make([]float64, len(scores))
+	if totalScore > 0 {
+		for idx, score := range scores {
+			confidences[idx] = score / totalScore
+		}
+	}
+
+	return confidences
+}
+
+func newNestingCandidate(pokStats models.NestPokemonCountAndTotal, confidence float64, rejectReason string) models.NestingCandidate {
+	nestPct := pokStats.NestPct()
+	gblPct := pokStats.GlobalPct()
+	var nestPctToGblPct float64
+
+	if gblPct != 0 {
+		nestPctToGblPct = nestPct / gblPct
+	}
+
+	return models.NestingCandidate{
+		PokemonKey:              pokStats.PokemonKey,
+		NestCount:               pokStats.Count,
+		NestPct:                 nestPct,
+		Spawnpoints:             pokStats.Spawnpoints,
+		GlobalPct:               gblPct,
+		NestPctToGlobalPctRatio: nestPctToGblPct,
+		Confidence:              confidence,
+		Nesting:                 rejectReason == "",
+		RejectReason:            rejectReason,
+	}
+}
+
+// finishCandidates limits the candidates to the configured max and
+// attaches them to the nesting pokemon, if there is one.
+func finishCandidates(cfg Config, nestingPokemonInfo *models.NestingPokemonInfo, candidates []models.NestingCandidate) []models.NestingCandidate {
+	if maxCandidates := cfg.MaxNestingCandidates; len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+	if len(candidates) == 0 {
+		candidates = nil
+	}
+
+	if nestingPokemonInfo != nil {
+		nestingPokemonInfo.Candidates = candidates
+	}
+
+	return candidates
+}
+
+// candidatesToConsider returns the top pokemon in the nest that
+// have sane stats and are able to nest.
+func candidatesToConsider(logger *logrus.Logger, speciesRules *SpeciesRules, summary models.NestTimePeriodSummary, logPrefix string) models.NestPokemonCountsAndTotals {
+	candidates := make(models.NestPokemonCountsAndTotals, 0, 10)
+
+	for idx, pokStats := range summary.PokemonCountsAndTotals {
+		// stop at 10 pokemon
+		if len(candidates) > 9 {
+			if logPrefix != "" {
+				logger.Infof(
+					"%s NEST [%s] Stopping at %d out of %d pokemon",
+					logPrefix,
+					summary.Nest,
+					idx,
+					len(summary.PokemonCountsAndTotals),
+				)
+			}
+			break
+		}
+		if speciesRules != nil && !speciesRules.CanNest(pokStats.PokemonKey.PokemonId) {
+			logCandidate(logger, logPrefix, summary.Nest, pokStats, "this pokemon does not nest")
+			continue
+		}
+		if pokStats.GlobalTotal <= 0 || pokStats.Global <= 0 ||
+			pokStats.Total <= 0 || pokStats.Count <= 0 {
+			logger.Warnf("PROCESSOR: Got unexpected stats when processing time period: %#v", pokStats)
+			continue
+		}
+		candidates = append(candidates, pokStats)
+	}
+
+	return candidates
+}
+
+func logCandidate(logger *logrus.Logger, logPrefix string, nest *models.Nest, pokStats models.NestPokemonCountAndTotal, extra string) {
+	if logPrefix == "" {
+		return
+	}
+
+	nestPct := pokStats.NestPct()
+	gblPct := pokStats.GlobalPct()
+	var nestPctToGblPct float64
+
+	if gblPct != 0 {
+		nestPctToGblPct = nestPct / gblPct
+	}
+
+	fmt := "%s NEST [%s] #%02d: %d:%d nest: %d/%d (%0.3f%%), global: %d/%d (%0.3f%%), nestPctToGlobalPctRatio: %0.3f)"
+	if extra != "" {
+		fmt += ": " + extra
+	}
+	logger.Infof(fmt,
+		logPrefix,
+		nest,
+		pokStats.Rank,
+		pokStats.PokemonKey.PokemonId,
+		pokStats.PokemonKey.FormId,
+		pokStats.Count,
+		pokStats.Total,
+		nestPct,
+		pokStats.Global,
+		pokStats.GlobalTotal,
+		gblPct,
+		nestPctToGblPct,
+	)
+}
+
+// thresholdStrategy is the original strategy. Candidates are checked
+// in order of count in the nest and the first one passing all of the
+// configured thresholds is the nesting pokemon.
+type thresholdStrategy struct {
+	logger       *logrus.Logger
+	config       Config
+	speciesRules *SpeciesRules
+}
+
+func (*thresholdStrategy) Name() string {
+	return NESTING_STRATEGY_THRESHOLD
+}
+
+// checkCandidate returns the reason the candidate is not nesting or
+// "" if it is.
+func (strategy *thresholdStrategy) checkCandidate(cfg Config, summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal) string {
+	nestPct := pokStats.NestPct()
+	gblPct := pokStats.GlobalPct()
+	var nestPctToGblPct float64
+
+	if gblPct != 0 {
+		nestPctToGblPct = nestPct / gblPct
+	}
+
+	// The more interesting checks are first to see what they look like in logs.
+
+	if nestPct < cfg.MinNestPokemonPct {
+		return fmt.Sprintf("this pokemon's percent in the nest (%0.3f) too small (< %0.3f)", nestPct, cfg.MinNestPokemonPct)
+	}
+
+	if nestPct < gblPct {
+		return fmt.Sprintf("this pokemon's percent in the nest (%0.3f) is less than global spawn percent (%0.3f)", nestPct, gblPct)
+	}
+
+	if nestPctToGblPct < cfg.MinNestPctToGlobalPctRatio {
+		return fmt.Sprintf("this pokemon's ratio (%0.3f) of nest percent (%0.3f) to global percent (%0.3f) is too small (< %0.3f)", nestPctToGblPct, nestPct, gblPct, cfg.MinNestPctToGlobalPctRatio)
+	}
+
+	// if a mon is spawning enough globally, ignore it. but I find the nestPct:gblPct ratio better.
+	if maxPct := cfg.MaxGlobalSpawnPct; maxPct > 0 {
+		if gblPct > maxPct {
+			return fmt.Sprintf("this pokemon's global spawn pct is too high (%0.3f > %0.3f)", gblPct, maxPct)
+		}
+	}
+
+	if pokStats.Total < uint64(cfg.MinTotalPokemon) {
+		return fmt.Sprintf("not enough pokemon seen overall (%d < %d)", pokStats.Total, cfg.MinTotalPokemon)
+	}
+
+	if pokStats.Count < uint64(cfg.MinNestPokemon) {
+		return fmt.Sprintf("not enough of this pokemon seen (%d < %d)", pokStats.Count, cfg.MinNestPokemon)
+	}
+
+	if reason := checkSpawnpoints(cfg, summary, pokStats); reason != "" {
+		return reason
+	}
+
+	if minHistory := cfg.MinHistoryDuration(); summary.Duration < minHistory {
+		return "not enough stats history yet"
+	}
+
+	return ""
+}
+
+func (strategy *thresholdStrategy) ComputeNesting(summary models.NestTimePeriodSummary, logPrefix string) (*models.NestingPokemonInfo, []models.NestingCandidate) {
+	var nestingPokemonInfo *models.NestingPokemonInfo
+
+	cfg := strategy.config.ForNest(summary.Nest)
+
+	candidates := candidatesToConsider(strategy.logger, strategy.speciesRules, summary, logPrefix)
+	scores := make([]float64, len(candidates))
+	for idx, pokStats := range candidates {
+		scores[idx] = nestingScore(pokStats)
+	}
+	candidateConfidences := confidences(scores)
+	nestingCandidates := make([]models.NestingCandidate, len(candidates))
+
+	// XXX: It's probably more interesting to look at these as a whole. For
+	// example, we can possibly reason about things if we compared against
+	// each other. For example, these are sorted by % in the nest. If there's
+	// a tie, the one spawning the least globally wins. But, let's say we have
+	// 22% in nest spawning @ 3% globally.. and.. 20% in nest spawning at 0.5%
+	// globally. It is more likely that the 20% is the nesting pokemon.
+	//
+	// So, this strategy relies on the 22% to get thrown out and ignored by
+	// its own individual stats. And it would by some of the global configs like:
+	//
+	// 1) you could configure to ignore pokemon spawning at 2.5%+. That might be
+	//    reasonable. From eyeballing prometheus over the past number of months,
+	//    it seems Niantic doesn't go much over 10% for a single mon and often
+	//    looks like around 7%. As I write this, there's only 10 pokemon spawning
+	//    at more than 2%. The highest is ~6% and #2 is ~4.75%.
+	// 2) you could configure the nest_pct_to_global_pct ratio accordingly. The
+	//    default for this one is currently 8, and that's enough to get the 22%
+	//    in the above example thrown out (ratio is ~7). The default of 10 has seemed
+	//    like a good number for this as I've been testing things. The obvious nesting
+	//    mons can have some absurd ratios.
+	//
+	// The 'score' strategy compares the candidates against each other instead.
+	//
+	// With max_nesting_pokemon > 1, the following candidates that also pass
+	// are additional nesting pokemon until there are enough.
+	for idx, pokStats := range candidates {
+		confidence := candidateConfidences[idx]
+
+		if nestingPokemonInfo != nil && len(nestingPokemonInfo.AdditionalPokemon)+1 >= cfg.MaxNestingPokemon {
+			// only log the rest.
+			nestingCandidates[idx] = newNestingCandidate(pokStats, confidence, "a higher ranked pokemon is nesting")
+			logCandidate(strategy.logger, logPrefix, summary.Nest, pokStats, "")
+			continue
+		}
+
+		reason := strategy.checkCandidate(cfg, summary, pokStats)
+		nestingCandidates[idx] = newNestingCandidate(pokStats, confidence, reason)
+		if reason == "" {
+			if nestingPokemonInfo == nil {
+				nestingPokemonInfo = newNestingPokemonInfo(summary, pokStats)
+				nestingPokemonInfo.Confidence = confidence
+				reason = "nesting!"
+			} else {
+				nestingPokemonInfo.AdditionalPokemon = append(
+					nestingPokemonInfo.AdditionalPokemon,
+					newAdditionalNestingPokemon(summary, pokStats, confidence),
+				)
+				reason = "also nesting!"
+			}
+		}
+
+		logCandidate(strategy.logger, logPrefix, summary.Nest, pokStats, reason)
+	}
+
+	return nestingPokemonInfo, finishCandidates(cfg, nestingPokemonInfo, nestingCandidates)
+}
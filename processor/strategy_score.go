@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// scoreStrategy looks at all of the candidates together. Each candidate
+// that has enough stats gets a score based on how much more it spawns in
+// the nest than globally. The highest score wins, as long as it is enough
+// of the nest and its share of all of the scores (the confidence) is
+// high enough.
+//
+// For example: 22% in nest spawning @ 3% globally vs 20% in nest spawning
+// @ 0.5% globally. The 2nd one is much more likely to be the nesting pokemon
+// and it will score ~2x the 1st.
+type scoreStrategy struct {
+	logger       *logrus.Logger
+	config       Config
+	speciesRules *SpeciesRules
+}
+
+func (*scoreStrategy) Name() string {
+	return NESTING_STRATEGY_SCORE
+}
+
+// scoreCandidate returns the score for a candidate or the reason it
+// can't be scored.
+func (strategy *scoreStrategy) scoreCandidate(cfg Config, pokStats models.NestPokemonCountAndTotal) (float64, string) {
+	nestPct := pokStats.NestPct()
+	gblPct := pokStats.GlobalPct()
+
+	if nestPct <= gblPct {
+		return 0, fmt.Sprintf("this pokemon's percent in the nest (%0.3f) is not more than global spawn percent (%0.3f)", nestPct, gblPct)
+	}
+
+	if maxPct := cfg.MaxGlobalSpawnPct; maxPct > 0 && gblPct > maxPct {
+		return 0, fmt.Sprintf("this pokemon's global spawn pct is too high (%0.3f > %0.3f)", gblPct, maxPct)
+	}
+
+	if pokStats.Count < uint64(cfg.MinNestPokemon) {
+		return 0, fmt.Sprintf("not enough of this pokemon seen (%d < %d)", pokStats.Count, cfg.MinNestPokemon)
+	}
+
+	return nestingScore(pokStats), ""
+}
+
+// checkNesting returns the reason a scored candidate is not nesting
+// or "" if it is.
+func (strategy *scoreStrategy) checkNesting(cfg Config, summary models.NestTimePeriodSummary, pokStats models.NestPokemonCountAndTotal, confidence, minConfidence float64) string {
+	if nestPct := pokStats.NestPct(); nestPct < cfg.MinNestPokemonPct {
+		return fmt.Sprintf("this pokemon's percent in the nest (%0.3f) too small (< %0.3f)", nestPct, cfg.MinNestPokemonPct)
+	} else if confidence < minConfidence {
+		return fmt.Sprintf("confidence too low (< %0.3f)", minConfidence)
+	} else if pokStats.Total < uint64(cfg.MinTotalPokemon) {
+		return fmt.Sprintf("not enough pokemon seen overall (%d < %d)", pokStats.Total, cfg.MinTotalPokemon)
+	} else if reason := checkSpawnpoints(cfg, summary, pokStats); reason != "" {
+		return reason
+	} else if minHistory := cfg.MinHistoryDuration(); summary.Duration < minHistory {
+		return "not enough stats history yet"
+	}
+	return ""
+}
+
+func (strategy *scoreStrategy) ComputeNesting(summary models.NestTimePeriodSummary, logPrefix string) (*models.NestingPokemonInfo, []models.NestingCandidate) {
+	cfg := strategy.config.ForNest(summary.Nest)
+
+	candidates := candidatesToConsider(strategy.logger, strategy.speciesRules, summary, logPrefix)
+	scores := make([]float64, len(candidates))
+	reasons := make([]string, len(candidates))
+
+	// indexes of the candidates that could be scored, best first.
+	scored := make([]int, 0, len(candidates))
+
+	for idx, pokStats := range candidates {
+		score, reason := strategy.scoreCandidate(cfg, pokStats)
+		scores[idx] = score
+		reasons[idx] = reason
+		if reason == "" {
+			scored = append(scored, idx)
+		}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool { return scores[scored[i]] > scores[scored[j]] })
+
+	candidateConfidences := confidences(scores)
+
+	var nestingPokemonInfo *models.NestingPokemonInfo
+
+	// the best score is nesting if it passes the checks. With
+	// max_nesting_pokemon > 1, the next best scores can also nest, in
+	// order, but the confidence is then shared, so they only need their
+	// share of min_nesting_confidence.
+	rejectReasons := make(map[int]string, len(scored))
+	for rank, idx := range scored {
+		if rank > 0 && (nestingPokemonInfo == nil || len(nestingPokemonInfo.AdditionalPokemon)+1 >= cfg.MaxNestingPokemon) {
+			rejectReasons[idx] = "another pokemon scored higher"
+			continue
+		}
+
+		pokStats := candidates[idx]
+		confidence := candidateConfidences[idx]
+
+		minConfidence := cfg.MinNestingConfidence
+		if rank > 0 {
+			minConfidence /= float64(cfg.MaxNestingPokemon)
+		}
+
+		rejectReason := strategy.checkNesting(cfg, summary, pokStats, confidence, minConfidence)
+		rejectReasons[idx] = rejectReason
+		if rejectReason != "" {
+			continue
+		}
+
+		if nestingPokemonInfo == nil {
+			nestingPokemonInfo = newNestingPokemonInfo(summary, pokStats)
+			nestingPokemonInfo.Confidence = confidence
+		} else {
+			nestingPokemonInfo.AdditionalPokemon = append(
+				nestingPokemonInfo.AdditionalPokemon,
+				newAdditionalNestingPokemon(summary, pokStats, confidence),
+			)
+		}
+	}
+
+	nestingCandidates := make([]models.NestingCandidate, len(candidates))
+
+	for idx, pokStats := range candidates {
+		confidence := candidateConfidences[idx]
+		reason := reasons[idx]
+		rejectReason := reason
+
+		if reason == "" {
+			reason = fmt.Sprintf("score %0.3f, confidence %0.3f", scores[idx], confidence)
+			rejectReason = rejectReasons[idx]
+
+			switch {
+			case rejectReason != "":
+				reason += ": " + rejectReason
+			case nestingPokemonInfo.PokemonKey == pokStats.PokemonKey:
+				reason += ": nesting!"
+			default:
+				reason += ": also nesting!"
+			}
+		}
+
+		nestingCandidates[idx] = newNestingCandidate(pokStats, confidence, rejectReason)
+		logCandidate(strategy.logger, logPrefix, summary.Nest, pokStats, reason)
+	}
+
+	return nestingPokemonInfo, finishCandidates(cfg, nestingPokemonInfo, nestingCandidates)
+}
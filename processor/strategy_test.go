@@ -0,0 +1,348 @@
+package processor
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UnownHash/Fletchling/processor/clock"
+	"github.com/UnownHash/Fletchling/processor/models"
+)
+
+// testStrategyPokemon is a pokemon in a test summary.
+type testStrategyPokemon struct {
+	pokemonId   int
+	count       uint64
+	global      uint64
+	spawnpoints uint64
+}
+
+// newTestStrategySummary returns a summary for 'nest' with the pokemon
+// ranked the way stats summaries are.
+func newTestStrategySummary(nest *models.Nest, endTime time.Time, duration time.Duration, globalTotal uint64, pokemon ...testStrategyPokemon) models.NestTimePeriodSummary {
+	var total uint64
+	for _, pok := range pokemon {
+		total += pok.count
+	}
+
+	countsAndTotals := make(models.NestPokemonCountsAndTotals, len(pokemon))
+	for idx, pok := range pokemon {
+		countsAndTotals[idx] = models.NestPokemonCountAndTotal{
+			PokemonKey:  models.PokemonKey{PokemonId: pok.pokemonId},
+			Count:       pok.count,
+			Total:       total,
+			Global:      pok.global,
+			GlobalTotal: globalTotal,
+			Spawnpoints: pok.spawnpoints,
+		}
+	}
+	sort.Sort(countsAndTotals)
+	for idx := range countsAndTotals {
+		countsAndTotals[idx].Rank = idx + 1
+	}
+
+	return models.NestTimePeriodSummary{
+		Nest:                   nest,
+		EndTime:                endTime,
+		Duration:               duration,
+		PokemonCountsAndTotals: countsAndTotals,
+		Spawnpoints:            10,
+	}
+}
+
+func TestNestingStrategyComputeNesting(t *testing.T) {
+	clk := clock.NewSimulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	nest := newTestNest(t, clk, 1, 10, 10, 0.01)
+
+	// out of 10000 globally:
+	//
+	// #1: 58% of the nest, but 96.5% globally.
+	// #2: 22% of the nest at 3% globally: a ratio of ~7.3.
+	// #3: 20% of the nest at 0.5% globally: a ratio of 40.
+	//
+	// #3 scores (20-0.5)*log2(40) = ~103.8 and #2 (22-3)*log2(22/3) =
+	// ~54.6, so their confidences are ~0.655 and ~0.345.
+	const (
+		background = 900
+		common     = 16
+		rare       = 133
+	)
+	pokemon := []testStrategyPokemon{
+		{pokemonId: background, count: 58, global: 9650, spawnpoints: 10},
+		{pokemonId: common, count: 22, global: 300, spawnpoints: 5},
+		{pokemonId: rare, count: 20, global: 50, spawnpoints: 2},
+	}
+	wantConfidences := map[int]float64{
+		background: 0,
+		common:     0.345,
+		rare:       0.655,
+	}
+
+	newConfig := func() Config {
+		config := newTestConfig()
+		config.MinNestPokemon = 10
+		config.MinNestPokemonPct = 10
+		config.MinTotalPokemon = 50
+		config.MinNestPctToGlobalPctRatio = 8
+		config.MaxGlobalSpawnPct = 0
+		config.MinNestSpawnpointPct = 0
+		config.MinNestingConfidence = 0.5
+		config.MinHistoryDurationHours = 1
+		config.MaxNestingPokemon = 1
+		config.MaxNestingCandidates = 10
+		return config
+	}
+
+	type wantCandidate struct {
+		pokemonId int
+		// "" if nesting, or a part of the reject reason.
+		rejectReason string
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		config   func(*Config)
+		duration time.Duration
+		// defaults to the confidences above.
+		wantConfidences map[int]float64
+		// the nesting pokemon, then any additional ones.
+		wantNesting    []int
+		wantCandidates []wantCandidate
+	}{
+		{
+			name:        "threshold: only the rare one passes",
+			strategy:    NESTING_STRATEGY_THRESHOLD,
+			wantNesting: []int{rare},
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "ratio (7.333)"},
+				{rare, ""},
+			},
+		},
+		{
+			name:        "threshold: the first that passes wins",
+			strategy:    NESTING_STRATEGY_THRESHOLD,
+			config:      func(cfg *Config) { cfg.MinNestPctToGlobalPctRatio = 5 },
+			wantNesting: []int{common},
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, ""},
+				{rare, "a higher ranked pokemon is nesting"},
+			},
+		},
+		{
+			name:     "threshold: max_nesting_pokemon 2",
+			strategy: NESTING_STRATEGY_THRESHOLD,
+			config: func(cfg *Config) {
+				cfg.MinNestPctToGlobalPctRatio = 5
+				cfg.MaxNestingPokemon = 2
+			},
+			wantNesting: []int{common, rare},
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, ""},
+				{rare, ""},
+			},
+		},
+		{
+			name:     "threshold: global spawn pct too high",
+			strategy: NESTING_STRATEGY_THRESHOLD,
+			config: func(cfg *Config) {
+				cfg.MinNestPctToGlobalPctRatio = 5
+				cfg.MaxGlobalSpawnPct = 2
+			},
+			wantNesting: []int{rare},
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "global spawn pct is too high"},
+				{rare, ""},
+			},
+		},
+		{
+			name:     "threshold: too few seen",
+			strategy: NESTING_STRATEGY_THRESHOLD,
+			config:   func(cfg *Config) { cfg.MinNestPokemon = 21 },
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "ratio (7.333)"},
+				{rare, "not enough of this pokemon seen (20 < 21)"},
+			},
+		},
+		{
+			name:     "threshold: too few spawnpoints",
+			strategy: NESTING_STRATEGY_THRESHOLD,
+			config:   func(cfg *Config) { cfg.MinNestSpawnpointPct = 30 },
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "ratio (7.333)"},
+				{rare, "too few of the nest's spawnpoints (2/10"},
+			},
+		},
+		{
+			name:     "threshold: not enough history",
+			strategy: NESTING_STRATEGY_THRESHOLD,
+			duration: 30 * time.Minute,
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "ratio (7.333)"},
+				{rare, "not enough stats history yet"},
+			},
+		},
+		{
+			name:        "threshold: candidates are limited",
+			strategy:    NESTING_STRATEGY_THRESHOLD,
+			config:      func(cfg *Config) { cfg.MaxNestingCandidates = 2 },
+			wantNesting: []int{rare},
+			wantCandidates: []wantCandidate{
+				{background, "less than global spawn percent"},
+				{common, "ratio (7.333)"},
+			},
+		},
+		{
+			// the ratio doesn't matter to this strategy.
+			name:        "score: the best score wins",
+			strategy:    NESTING_STRATEGY_SCORE,
+			config:      func(cfg *Config) { cfg.MinNestPctToGlobalPctRatio = 5 },
+			wantNesting: []int{rare},
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, "another pokemon scored higher"},
+				{rare, ""},
+			},
+		},
+		{
+			name:     "score: max_nesting_pokemon 2 shares the confidence",
+			strategy: NESTING_STRATEGY_SCORE,
+			config: func(cfg *Config) {
+				// #2 needs 0.6/2.
+				cfg.MinNestingConfidence = 0.6
+				cfg.MaxNestingPokemon = 2
+			},
+			wantNesting: []int{rare, common},
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, ""},
+				{rare, ""},
+			},
+		},
+		{
+			name:     "score: confidence too low",
+			strategy: NESTING_STRATEGY_SCORE,
+			config:   func(cfg *Config) { cfg.MinNestingConfidence = 0.7 },
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, "another pokemon scored higher"},
+				{rare, "confidence too low (< 0.700)"},
+			},
+		},
+		{
+			// a pokemon that can't be scored has no share of the confidence.
+			name:            "score: global spawn pct too high",
+			strategy:        NESTING_STRATEGY_SCORE,
+			config:          func(cfg *Config) { cfg.MaxGlobalSpawnPct = 1 },
+			wantConfidences: map[int]float64{background: 0, common: 0, rare: 1},
+			wantNesting:     []int{rare},
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, "global spawn pct is too high"},
+				{rare, ""},
+			},
+		},
+		{
+			name:     "score: too few overall",
+			strategy: NESTING_STRATEGY_SCORE,
+			config:   func(cfg *Config) { cfg.MinTotalPokemon = 200 },
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, "another pokemon scored higher"},
+				{rare, "not enough pokemon seen overall (100 < 200)"},
+			},
+		},
+		{
+			name:     "score: not enough history",
+			strategy: NESTING_STRATEGY_SCORE,
+			duration: 30 * time.Minute,
+			wantCandidates: []wantCandidate{
+				{background, "not more than global spawn percent"},
+				{common, "another pokemon scored higher"},
+				{rare, "not enough stats history yet"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newConfig()
+			config.NestingStrategy = tt.strategy
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			strategy, err := NewNestingStrategy(newTestLogger(), config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			duration := tt.duration
+			if duration == 0 {
+				duration = 2 * time.Hour
+			}
+			summary := newTestStrategySummary(nest, clk.Now(), duration, 10000, pokemon...)
+
+			nestingPokemonInfo, candidates := strategy.ComputeNesting(summary, "")
+
+			wantConfidences := wantConfidences
+			if tt.wantConfidences != nil {
+				wantConfidences = tt.wantConfidences
+			}
+
+			var nesting []int
+			if nestingPokemonInfo != nil {
+				nesting = append(nesting, nestingPokemonInfo.PokemonKey.PokemonId)
+				for _, additional := range nestingPokemonInfo.AdditionalPokemon {
+					nesting = append(nesting, additional.PokemonKey.PokemonId)
+				}
+			}
+			if len(nesting) != len(tt.wantNesting) {
+				t.Fatalf("got nesting %v, want %v", nesting, tt.wantNesting)
+			}
+			for idx := range nesting {
+				if nesting[idx] != tt.wantNesting[idx] {
+					t.Fatalf("got nesting %v, want %v", nesting, tt.wantNesting)
+				}
+			}
+
+			if len(candidates) != len(tt.wantCandidates) {
+				t.Fatalf("got %d candidates, want %d", len(candidates), len(tt.wantCandidates))
+			}
+			for idx, candidate := range candidates {
+				want := tt.wantCandidates[idx]
+				if candidate.PokemonKey.PokemonId != want.pokemonId {
+					t.Errorf("#%d: got %s, want %d", idx+1, candidate.PokemonKey, want.pokemonId)
+					continue
+				}
+				if candidate.Nesting != (want.rejectReason == "") || !strings.Contains(candidate.RejectReason, want.rejectReason) {
+					t.Errorf("%s: got nesting %t, reject reason '%s', want '%s'", candidate.PokemonKey, candidate.Nesting, candidate.RejectReason, want.rejectReason)
+				}
+				if wantConfidence := wantConfidences[want.pokemonId]; math.Abs(candidate.Confidence-wantConfidence) > 0.001 {
+					t.Errorf("%s: got confidence %0.4f, want %0.3f", candidate.PokemonKey, candidate.Confidence, wantConfidence)
+				}
+			}
+
+			if nestingPokemonInfo != nil {
+				if wantConfidence := wantConfidences[nestingPokemonInfo.PokemonKey.PokemonId]; math.Abs(nestingPokemonInfo.Confidence-wantConfidence) > 0.001 {
+					t.Errorf("got confidence %0.4f for the nesting pokemon", nestingPokemonInfo.Confidence)
+				}
+				if len(nestingPokemonInfo.Candidates) != len(candidates) {
+					t.Errorf("got %d candidates in the nesting info, want %d", len(nestingPokemonInfo.Candidates), len(candidates))
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,30 @@
 package stats_collector
 
-import "github.com/gin-gonic/gin"
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 var _ StatsCollector = (*noopCollector)(nil)
 
 type noopCollector struct {
 }
 
-func (col *noopCollector) Name() string                   { return "no-op" }
-func (col *noopCollector) RegisterGinEngine(*gin.Engine)  {}
-func (col *noopCollector) AddPokemonProcessed(num uint64) {}
-func (col *noopCollector) AddPokemonMatched(num uint64)   {}
-func (col *noopCollector) AddNestsMatched(num uint64)     {}
+func (col *noopCollector) Name() string                                { return "no-op" }
+func (col *noopCollector) RegisterGinEngine(*gin.Engine)               {}
+func (col *noopCollector) AddPokemonProcessed(num uint64)              {}
+func (col *noopCollector) AddPokemonMatched(num uint64)                {}
+func (col *noopCollector) AddPokemonDuplicate(num uint64)              {}
+func (col *noopCollector) AddPokemonIgnored(reason string, num uint64) {}
+func (col *noopCollector) AddNestsMatched(num uint64)                  {}
+
+func (col *noopCollector) ObserveNestCoverage(pct float64) {}
+func (col *noopCollector) SetNestsLowCoverage(num int)     {}
+
+func (col *noopCollector) SetWebhookQueueDepth(depth int)              {}
+func (col *noopCollector) AddWebhooksDropped(num uint64)               {}
+func (col *noopCollector) ObserveWebhookLatency(latency time.Duration) {}
 
 func NewNoopStatsCollector() StatsCollector {
 	return &noopCollector{}
@@ -1,6 +1,8 @@
 package stats_collector
 
 import (
+	"time"
+
 	"github.com/Depado/ginprom"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -41,7 +43,16 @@ type PrometheusCollector struct {
 
 	pokemonProcessed prometheus.Counter
 	pokemonMatched   prometheus.Counter
+	pokemonDuplicate prometheus.Counter
+	pokemonIgnored   *prometheus.CounterVec
 	nestsMatched     prometheus.Counter
+
+	nestCoverage     prometheus.Histogram
+	nestsLowCoverage prometheus.Gauge
+
+	webhookQueueDepth   prometheus.Gauge
+	webhooksDropped     prometheus.Counter
+	webhookQueueLatency prometheus.Histogram
 }
 
 func (col *PrometheusCollector) Name() string {
@@ -68,10 +79,38 @@ func (col *PrometheusCollector) AddPokemonMatched(num uint64) {
 	col.pokemonMatched.Add(float64(num))
 }
 
+func (col *PrometheusCollector) AddPokemonDuplicate(num uint64) {
+	col.pokemonDuplicate.Add(float64(num))
+}
+
+func (col *PrometheusCollector) AddPokemonIgnored(reason string, num uint64) {
+	col.pokemonIgnored.WithLabelValues(reason).Add(float64(num))
+}
+
 func (col *PrometheusCollector) AddNestsMatched(num uint64) {
 	col.nestsMatched.Add(float64(num))
 }
 
+func (col *PrometheusCollector) ObserveNestCoverage(pct float64) {
+	col.nestCoverage.Observe(pct)
+}
+
+func (col *PrometheusCollector) SetNestsLowCoverage(num int) {
+	col.nestsLowCoverage.Set(float64(num))
+}
+
+func (col *PrometheusCollector) SetWebhookQueueDepth(depth int) {
+	col.webhookQueueDepth.Set(float64(depth))
+}
+
+func (col *PrometheusCollector) AddWebhooksDropped(num uint64) {
+	col.webhooksDropped.Add(float64(num))
+}
+
+func (col *PrometheusCollector) ObserveWebhookLatency(latency time.Duration) {
+	col.webhookQueueLatency.Observe(latency.Seconds())
+}
+
 func NewPrometheusCollector(config PrometheusConfig) StatsCollector {
 	ns := config.Namespace
 	if ns == "" {
@@ -95,6 +134,21 @@ func NewPrometheusCollector(config PrometheusConfig) StatsCollector {
 				Help:      "Total number of pokemon matching at least 1 nest",
 			},
 		),
+		pokemonDuplicate: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: ns,
+				Name:      "pokemon_duplicate",
+				Help:      "Total number of re-sent pokemon encounters ignored",
+			},
+		),
+		pokemonIgnored: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: ns,
+				Name:      "pokemon_ignored",
+				Help:      "Total number of pokemon ignored by the ingest rules",
+			},
+			[]string{"reason"},
+		),
 		nestsMatched: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: ns,
@@ -102,6 +156,43 @@ func NewPrometheusCollector(config PrometheusConfig) StatsCollector {
 				Help:      "Total number of nests matched",
 			},
 		),
+		nestCoverage: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: ns,
+				Name:      "nest_coverage_pct",
+				Help:      "Percent of each nest's spawnpoints seen, per nest evaluation",
+				Buckets:   prometheus.LinearBuckets(10, 10, 10),
+			},
+		),
+		nestsLowCoverage: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: ns,
+				Name:      "nests_low_coverage",
+				Help:      "Number of nests below min_nest_coverage_pct in the last evaluation",
+			},
+		),
+		webhookQueueDepth: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: ns,
+				Name:      "webhook_queue_depth",
+				Help:      "Number of webhooks waiting to be processed",
+			},
+		),
+		webhooksDropped: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: ns,
+				Name:      "webhooks_dropped",
+				Help:      "Total number of webhooks thrown away due to a full queue",
+			},
+		),
+		webhookQueueLatency: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: ns,
+				Name:      "webhook_latency_seconds",
+				Help:      "Time from receiving a webhook until it was processed",
+				Buckets:   config.BucketSize,
+			},
+		),
 	}
 
 	processOpts := collectors.ProcessCollectorOpts{
@@ -118,7 +209,14 @@ func NewPrometheusCollector(config PrometheusConfig) StatsCollector {
 		),
 		collector.pokemonProcessed,
 		collector.pokemonMatched,
+		collector.pokemonDuplicate,
+		collector.pokemonIgnored,
 		collector.nestsMatched,
+		collector.nestCoverage,
+		collector.nestsLowCoverage,
+		collector.webhookQueueDepth,
+		collector.webhooksDropped,
+		collector.webhookQueueLatency,
 	)
 
 	return collector
@@ -1,6 +1,8 @@
 package stats_collector
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,7 +12,18 @@ type StatsCollector interface {
 
 	AddPokemonProcessed(num uint64)
 	AddPokemonMatched(num uint64)
+	AddPokemonDuplicate(num uint64)
+	// reason is why the ingest rules ignored the pokemon.
+	AddPokemonIgnored(reason string, num uint64)
 	AddNestsMatched(num uint64)
+
+	// pct is a nest's scan coverage from an evaluation.
+	ObserveNestCoverage(pct float64)
+	SetNestsLowCoverage(num int)
+
+	SetWebhookQueueDepth(depth int)
+	AddWebhooksDropped(num uint64)
+	ObserveWebhookLatency(latency time.Duration)
 }
 
 type Config interface {
@@ -0,0 +1,43 @@
+package webhook_recorder
+
+import (
+	"fmt"
+)
+
+type Config struct {
+	// Filename is the file to record to. Empty disables recording.
+	Filename   string `koanf:"filename"`
+	MaxSizeMB  int    `koanf:"max_size"` // MB
+	MaxBackups int    `koanf:"max_backups"`
+	MaxAgeDays int    `koanf:"max_age"` // Days
+	Compress   bool   `koanf:"compress"`
+}
+
+func (cfg *Config) Enabled() bool {
+	return cfg.Filename != ""
+}
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.MaxSizeMB < 1 {
+		return fmt.Errorf("webhook_recorder max_size should be at least 1, not %d", cfg.MaxSizeMB)
+	}
+	if cfg.MaxBackups < 0 {
+		return fmt.Errorf("webhook_recorder max_backups should not be negative (%d)", cfg.MaxBackups)
+	}
+	if cfg.MaxAgeDays < 0 {
+		return fmt.Errorf("webhook_recorder max_age should not be negative (%d)", cfg.MaxAgeDays)
+	}
+	return nil
+}
+
+func GetDefaultConfig() Config {
+	return Config{
+		MaxSizeMB:  100,
+		MaxBackups: 50,
+		MaxAgeDays: 7,
+		Compress:   true,
+	}
+}
@@ -0,0 +1,64 @@
+package webhook_recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maximum size of a single recorded line.
+const maxRecordSize = 64 * 1024 * 1024
+
+// ReadFile reads the records in a recording, calling 'fn' for each one in
+// order. Files ending in .gz are decompressed. Stops at the first error
+// returned by 'fn'.
+func ReadFile(filename string, fn func(Record) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+
+	if strings.HasSuffix(filename, ".gz") {
+		gzReader, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("couldn't decompress '%s': %w", filename, err)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxRecordSize)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("%s:%d: couldn't decode record: %w", filename, lineNum, err)
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed reading '%s': %w", filename, err)
+	}
+
+	return nil
+}
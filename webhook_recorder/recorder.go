@@ -0,0 +1,72 @@
+package webhook_recorder
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Record is one recorded webhook request: the raw body as received
+// and when it was received.
+type Record struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Recorder writes raw webhook payloads as NDJSON to rotating files.
+type Recorder struct {
+	logger *logrus.Logger
+
+	mutex  sync.Mutex
+	writer *lumberjack.Logger
+}
+
+// Record writes the payload received at 'receivedAt'. Payloads that
+// aren't valid JSON are skipped, as they can't be replayed anyway.
+func (recorder *Recorder) Record(receivedAt time.Time, payload []byte) {
+	if !json.Valid(payload) {
+		recorder.logger.Warnf("WEBHOOK-RECORDER: not recording invalid json payload (%d bytes)", len(payload))
+		return
+	}
+
+	line, err := json.Marshal(Record{
+		ReceivedAt: receivedAt,
+		Payload:    payload,
+	})
+	if err != nil {
+		recorder.logger.Warnf("WEBHOOK-RECORDER: failed to encode payload: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if _, err := recorder.writer.Write(line); err != nil {
+		recorder.logger.Warnf("WEBHOOK-RECORDER: failed to write payload: %v", err)
+	}
+}
+
+func (recorder *Recorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return recorder.writer.Close()
+}
+
+func NewRecorder(logger *logrus.Logger, cfg Config) *Recorder {
+	return &Recorder{
+		logger: logger,
+		writer: &lumberjack.Logger{
+			Filename:   cfg.Filename,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+			LocalTime:  true,
+		},
+	}
+}
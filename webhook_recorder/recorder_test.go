@@ -0,0 +1,132 @@
+package webhook_recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRecording(t *testing.T) (string, []Record) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	filename := filepath.Join(t.TempDir(), "webhooks.ndjson")
+	recorder := NewRecorder(logger, Config{Filename: filename, MaxSizeMB: 1})
+
+	receivedAt := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	want := []Record{
+		{ReceivedAt: receivedAt, Payload: []byte(`[{"type":"pokemon","message":{"pokemon_id":25,"form":0,"spawnpoint_id":"1a2b","latitude":10.005,"longitude":10.005}}]`)},
+		{ReceivedAt: receivedAt.Add(time.Second), Payload: []byte(`[]`)},
+		{ReceivedAt: receivedAt.Add(time.Minute), Payload: []byte(`[{"type":"pokemon","message":{"pokemon_id":133,"form":0,"spawnpoint_id":"3c4d","latitude":10.005,"longitude":10.005}},{"type":"pokestop","message":{}}]`)},
+	}
+
+	recorder.Record(want[0].ReceivedAt, want[0].Payload)
+	// not valid json, so not recorded.
+	recorder.Record(receivedAt, []byte(`[{"type":"pokemon"`))
+	for _, record := range want[1:] {
+		recorder.Record(record.ReceivedAt, record.Payload)
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, want
+}
+
+func TestRecorderReadFile(t *testing.T) {
+	filename, want := newTestRecording(t)
+
+	// lumberjack compresses rotated backups with gzip.
+	gzFilename := filename + ".gz"
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gzWriter := gzip.NewWriter(&compressed)
+	if _, err := gzWriter.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gzFilename, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{filename, gzFilename} {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			var got []Record
+			err := ReadFile(name, func(record Record) error {
+				got = append(got, record)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("got %d records, want %d", len(got), len(want))
+			}
+			for idx := range want {
+				if !got[idx].ReceivedAt.Equal(want[idx].ReceivedAt) {
+					t.Errorf("record %d: got received_at %s, want %s", idx, got[idx].ReceivedAt, want[idx].ReceivedAt)
+				}
+				if !bytes.Equal(got[idx].Payload, want[idx].Payload) {
+					t.Errorf("record %d: got payload %s, want %s", idx, got[idx].Payload, want[idx].Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestReadFileStopsAtError(t *testing.T) {
+	filename, _ := newTestRecording(t)
+
+	stop := errors.New("stop")
+	numRecords := 0
+	err := ReadFile(filename, func(Record) error {
+		numRecords++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("got error %v, want %v", err, stop)
+	}
+	if numRecords != 1 {
+		t.Errorf("got %d records, want 1", numRecords)
+	}
+}
+
+func TestReadFileBadRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "webhooks.ndjson")
+	contents := "{\"received_at\":\"2024-05-01T12:00:00Z\",\"payload\":[]}\n\nnot a record\n"
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	numRecords := 0
+	err := ReadFile(filename, func(Record) error {
+		numRecords++
+		return nil
+	})
+	if err == nil {
+		t.Fatal("reading a bad record didn't fail")
+	}
+	if want := filename + ":3: couldn't decode record"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error '%v', want it to start with '%s'", err, want)
+	}
+	if numRecords != 1 {
+		t.Errorf("got %d records before the bad one, want 1", numRecords)
+	}
+}
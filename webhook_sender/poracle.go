@@ -102,6 +102,12 @@ type NestWebhook struct {
 	PolyPath     string  `json:"poly_path"`  // json encoded path. poracle json parses this.
 	ResetTime    int64   `json:"reset_time"` // used as discover time epoch
 
+	// not used by poracle, but useful for others.
+	Confidence float64                   `json:"confidence"`
+	Candidates []models.NestingCandidate `json:"candidates,omitempty"`
+	// other pokemon also nesting, ranked.
+	AdditionalPokemon []models.AdditionalNestingPokemon `json:"additional_pokemon,omitempty"`
+
 	//PolyType     int         `json:"poly_type"` // 1 if park, else 0? I don't see this in poracle tho
 	//CurrentTime     int         `json:"current_time"`
 	//NestSubmittedBy string      `json:"nest_submitted_by,omitempty"`
@@ -151,7 +157,11 @@ func (sender *PoracleSender) AddNestWebhook(nest *models.Nest, ni *models.Nestin
 		PokemonRatio: ni.NestPct(),
 		ResetTime:    ni.DetectedAt.Unix(),
 		PolyPath:     string(polyPathJson),
+		Confidence:   ni.Confidence,
+		Candidates:   ni.Candidates,
 		AreaName:     areas.AreaStringToAreaName(nest.AreaName.ValueOrZero()),
+
+		AdditionalPokemon: ni.AdditionalPokemon,
 	}
 
 	whMessage := NestWebhookMessage{